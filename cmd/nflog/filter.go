@@ -0,0 +1,105 @@
+// +build linux !darwin
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"go.aporeto.io/netlink-go/nflog"
+)
+
+// filter -- Packets printed. A packet must match every filter set,
+// and one of the addresses and one of the ports when there are several
+// prefix -- start of the log prefix
+// nets -- networks the source or destination address is in
+// ports -- source or destination ports
+type filter struct {
+	prefix string
+	nets   []*net.IPNet
+	ports  []uint16
+}
+
+// newFilter -- Parse the filters of the command line, empty strings match every packet
+func newFilter(prefix string, addrs string, ports string) (*filter, error) {
+	f := &filter{prefix: prefix}
+
+	if addrs != "" {
+		for _, field := range strings.Split(addrs, ",") {
+			ipNet, err := parseNet(strings.TrimSpace(field))
+			if err != nil {
+				return nil, err
+			}
+			f.nets = append(f.nets, ipNet)
+		}
+	}
+
+	if ports != "" {
+		for _, field := range strings.Split(ports, ",") {
+			port, err := strconv.ParseUint(strings.TrimSpace(field), 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid port %q: %w", field, err)
+			}
+			f.ports = append(f.ports, uint16(port))
+		}
+	}
+
+	return f, nil
+}
+
+// match -- True if the packet passes the filters
+func (f *filter) match(p *nflog.NfPacket) bool {
+	if !strings.HasPrefix(p.Prefix, f.prefix) {
+		return false
+	}
+
+	if len(f.nets) > 0 {
+		matched := false
+		for _, ipNet := range f.nets {
+			if (p.SrcIP != nil && ipNet.Contains(p.SrcIP)) || (p.DstIP != nil && ipNet.Contains(p.DstIP)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if len(f.ports) > 0 {
+		matched := false
+		for _, port := range f.ports {
+			if p.SrcPort == port || p.DstPort == port {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseNet -- Parse an address or a CIDR, an address is a network of a single address
+func parseNet(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %w", s, err)
+		}
+		return ipNet, nil
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %q", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
@@ -0,0 +1,216 @@
+// +build linux !darwin
+
+// nflog binds to nflog groups and prints the packets the kernel logs to them, as text or JSON lines.
+// The packets can be filtered by prefix, address and port and written to a pcapng file.
+// Packets are logged by NFLOG rules, as:
+//
+//	iptables -A INPUT -p tcp --dport 22 -j NFLOG --nflog-group 32 --nflog-prefix ssh
+//	nflog -groups 32 -format json -pcapng ssh.pcapng
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.aporeto.io/netlink-go/nflog"
+	"go.aporeto.io/netlink-go/pcapng"
+)
+
+// config -- Command line of the tool
+type config struct {
+	groups    string
+	families  string
+	copyRange uint
+	nlbufsiz  uint
+	qthresh   uint
+	timeout   time.Duration
+	seq       bool
+	conntrack bool
+	netns     string
+	reconnect bool
+	format    string
+	prefix    string
+	addrs     string
+	ports     string
+	pcapng    string
+	linkType  string
+}
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.groups, "groups", "", "comma separated nflog groups to bind to, required")
+	flag.StringVar(&cfg.families, "families", "inet", "comma separated families of the NFLOG rules, each bound before the groups: inet, inet6, bridge")
+	flag.UintVar(&cfg.copyRange, "copy-range", 0xffff, "bytes of each packet copied by the kernel, 0 for the whole packet")
+	flag.UintVar(&cfg.nlbufsiz, "nlbufsiz", 0, "size of the datagrams the kernel batches logs in, kernel default if 0")
+	flag.UintVar(&cfg.qthresh, "qthresh", 0, "number of logs batched in a datagram, kernel default if 0")
+	flag.DurationVar(&cfg.timeout, "timeout", 0, "longest time a log is held in the kernel, kernel default if 0")
+	flag.BoolVar(&cfg.seq, "seq", false, "log the sequence numbers of the packets")
+	flag.BoolVar(&cfg.conntrack, "conntrack", false, "log the conntrack entries of the packets")
+	flag.StringVar(&cfg.netns, "netns", "", "network namespace to bind in, as /var/run/netns/name")
+	flag.BoolVar(&cfg.reconnect, "reconnect", false, "reconnect when reading the logs fails instead of exiting")
+	flag.StringVar(&cfg.format, "format", "text", "output format: text, json or none")
+	flag.StringVar(&cfg.prefix, "prefix", "", "only print the packets whose log prefix starts with this")
+	flag.StringVar(&cfg.addrs, "addr", "", "only print the packets from or to these comma separated addresses or CIDRs")
+	flag.StringVar(&cfg.ports, "port", "", "only print the packets from or to these comma separated ports")
+	flag.StringVar(&cfg.pcapng, "pcapng", "", "also write the printed packets to this pcapng file")
+	flag.StringVar(&cfg.linkType, "linktype", "nflog", "link type of the pcapng file: raw or nflog")
+	flag.Parse()
+
+	if err := run(&cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run -- Print the logs until the tool is interrupted or reading them fails
+func run(cfg *config) error {
+	groups, err := parseGroups(cfg.groups)
+	if err != nil {
+		return err
+	}
+	opts, err := nflogOptions(cfg)
+	if err != nil {
+		return err
+	}
+	filter, err := newFilter(cfg.prefix, cfg.addrs, cfg.ports)
+	if err != nil {
+		return err
+	}
+	printer, err := newPrinter(os.Stdout, cfg.format)
+	if err != nil {
+		return err
+	}
+
+	var capture *pcapng.Writer
+	if cfg.pcapng != "" {
+		linkType, err := parseLinkType(cfg.linkType)
+		if err != nil {
+			return err
+		}
+		f, err := os.Create(cfg.pcapng)
+		if err != nil {
+			return err
+		}
+		defer f.Close() // nolint
+		if capture, err = pcapng.NewWriter(f, linkType, pcapng.WithApplication("nflog")); err != nil {
+			return err
+		}
+	}
+
+	callback := func(p *nflog.NfPacket, _ interface{}) {
+		if !filter.match(p) {
+			return
+		}
+		printer.print(p)
+		if capture != nil {
+			capture.WriteNflog(p) // nolint
+		}
+	}
+	errorCallback := func(err error) {
+		fmt.Fprintln(os.Stderr, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	handle, err := nflog.BindAndListenForLogsWithContext(ctx, groups, uint32(cfg.copyRange), callback, errorCallback, opts...)
+	if err != nil {
+		return err
+	}
+	if err := handle.Wait(); err != nil {
+		return err
+	}
+	if capture != nil {
+		return capture.Err()
+	}
+	return nil
+}
+
+// nflogOptions -- Options of the nflog handle set by the command line
+func nflogOptions(cfg *config) ([]nflog.Option, error) {
+	families, err := parseFamilies(cfg.families)
+	if err != nil {
+		return nil, err
+	}
+	opts := []nflog.Option{
+		nflog.WithFamilies(families...),
+		nflog.WithNlBufSiz(uint32(cfg.nlbufsiz)),
+		nflog.WithQThresh(uint32(cfg.qthresh)),
+		nflog.WithFlushTimeout(cfg.timeout),
+	}
+
+	var flags uint16
+	if cfg.seq {
+		flags |= nflog.NFULNL_CFG_F_SEQ | nflog.NFULNL_CFG_F_SEQ_GLOBAL
+	}
+	if cfg.conntrack {
+		flags |= nflog.NFULNL_CFG_F_CONNTRACK
+	}
+	opts = append(opts, nflog.WithLogFlags(flags))
+
+	if cfg.netns != "" {
+		opts = append(opts, nflog.WithNetNSPath(cfg.netns))
+	}
+	if cfg.reconnect {
+		opts = append(opts, nflog.WithReconnect(100*time.Millisecond, 10*time.Second))
+	}
+	return opts, nil
+}
+
+// parseGroups -- Parse a comma separated list of groups
+func parseGroups(s string) ([]uint16, error) {
+	if s == "" {
+		return nil, fmt.Errorf("no nflog group, pass -groups")
+	}
+	var groups []uint16
+	for _, field := range strings.Split(s, ",") {
+		group, err := strconv.ParseUint(strings.TrimSpace(field), 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid nflog group %q: %w", field, err)
+		}
+		groups = append(groups, uint16(group))
+	}
+	return groups, nil
+}
+
+// parseFamilies -- Parse a comma separated list of families
+func parseFamilies(s string) ([]uint8, error) {
+	var families []uint8
+	for _, field := range strings.Split(s, ",") {
+		switch strings.TrimSpace(field) {
+		case "inet", "ipv4":
+			families = append(families, syscall.AF_INET)
+		case "inet6", "ipv6":
+			families = append(families, syscall.AF_INET6)
+		case "bridge":
+			families = append(families, syscall.AF_BRIDGE)
+		default:
+			return nil, fmt.Errorf("invalid family %q", field)
+		}
+	}
+	return families, nil
+}
+
+// parseLinkType -- Parse the link type of the pcapng file
+func parseLinkType(s string) (pcapng.LinkType, error) {
+	switch s {
+	case "raw":
+		return pcapng.LinkTypeRaw, nil
+	case "nflog":
+		return pcapng.LinkTypeNFLOG, nil
+	}
+	return 0, fmt.Errorf("invalid link type %q", s)
+}
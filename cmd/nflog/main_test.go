@@ -0,0 +1,196 @@
+// +build linux !darwin
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/netlink-go/nflog"
+	"go.aporeto.io/netlink-go/pcapng"
+)
+
+// loggedPacket -- A TCP packet from 10.0.0.1:40000 to 10.0.0.2:22 logged with prefix
+func loggedPacket(prefix string) *nflog.NfPacket {
+	p := &nflog.NfPacket{Prefix: prefix}
+	p.SrcIP = net.ParseIP("10.0.0.1").To4()
+	p.DstIP = net.ParseIP("10.0.0.2").To4()
+	p.Version = 4
+	p.Protocol = syscall.IPPROTO_TCP
+	p.IPLayer.Length = 60
+	p.SrcPort = 40000
+	p.DstPort = 22
+	p.Family = syscall.AF_INET
+	p.Group = 32
+	p.Hook = 1
+	p.Mark = 0x10
+	p.Timestamp = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	return p
+}
+
+func TestCommandLine(t *testing.T) {
+	Convey("Given the command line of the tool", t, func() {
+
+		Convey("Groups should be parsed", func() {
+			groups, err := parseGroups("32, 33")
+			So(err, ShouldBeNil)
+			So(groups, ShouldResemble, []uint16{32, 33})
+
+			_, err = parseGroups("")
+			So(err, ShouldNotBeNil)
+			_, err = parseGroups("70000")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Families should be parsed", func() {
+			families, err := parseFamilies("inet,inet6,bridge")
+			So(err, ShouldBeNil)
+			So(families, ShouldResemble, []uint8{syscall.AF_INET, syscall.AF_INET6, syscall.AF_BRIDGE})
+
+			_, err = parseFamilies("arp")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Link types should be parsed", func() {
+			linkType, err := parseLinkType("raw")
+			So(err, ShouldBeNil)
+			So(linkType, ShouldEqual, pcapng.LinkTypeRaw)
+
+			_, err = parseLinkType("ether")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Invalid options should be rejected", func() {
+			_, err := nflogOptions(&config{families: "inet,ipx"})
+			So(err, ShouldNotBeNil)
+			_, err = newPrinter(&bytes.Buffer{}, "xml")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestFilter(t *testing.T) {
+	Convey("Given a logged packet", t, func() {
+		p := loggedPacket("ssh-in")
+
+		Convey("An empty filter should match it", func() {
+			f, err := newFilter("", "", "")
+			So(err, ShouldBeNil)
+			So(f.match(p), ShouldBeTrue)
+		})
+
+		Convey("The prefix filter should match the start of the prefix", func() {
+			f, err := newFilter("ssh", "", "")
+			So(err, ShouldBeNil)
+			So(f.match(p), ShouldBeTrue)
+
+			f, err = newFilter("in", "", "")
+			So(err, ShouldBeNil)
+			So(f.match(p), ShouldBeFalse)
+		})
+
+		Convey("The address filter should match the source or the destination", func() {
+			f, err := newFilter("", "192.168.0.1, 10.0.0.2", "")
+			So(err, ShouldBeNil)
+			So(f.match(p), ShouldBeTrue)
+
+			f, err = newFilter("", "10.0.0.0/30", "")
+			So(err, ShouldBeNil)
+			So(f.match(p), ShouldBeTrue)
+
+			f, err = newFilter("", "10.0.1.0/24,fd00::1", "")
+			So(err, ShouldBeNil)
+			So(f.match(p), ShouldBeFalse)
+			So(f.match(&nflog.NfPacket{}), ShouldBeFalse)
+		})
+
+		Convey("The port filter should match the source or the destination", func() {
+			f, err := newFilter("", "", "22")
+			So(err, ShouldBeNil)
+			So(f.match(p), ShouldBeTrue)
+
+			f, err = newFilter("", "", "80,443")
+			So(err, ShouldBeNil)
+			So(f.match(p), ShouldBeFalse)
+		})
+
+		Convey("Every filter should match", func() {
+			f, err := newFilter("ssh", "10.0.0.1", "80")
+			So(err, ShouldBeNil)
+			So(f.match(p), ShouldBeFalse)
+		})
+
+		Convey("Invalid filters should be rejected", func() {
+			_, err := newFilter("", "10.0.0", "")
+			So(err, ShouldNotBeNil)
+			_, err = newFilter("", "10.0.0.0/33", "")
+			So(err, ShouldNotBeNil)
+			_, err = newFilter("", "", "http")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestPrinter(t *testing.T) {
+	Convey("Given a logged packet", t, func() {
+		p := loggedPacket("ssh-in")
+		var out bytes.Buffer
+
+		Convey("The text format should print it on a line", func() {
+			pr, err := newPrinter(&out, "text")
+			So(err, ShouldBeNil)
+			p.HasUID = true
+			p.UID = 1000
+			pr.print(p)
+
+			So(out.String(), ShouldEqual, `2020-01-02T03:04:05Z group=32 prefix="ssh-in" tcp 10.0.0.1:40000 -> 10.0.0.2:22 len=60 mark=0x10 uid=1000`+"\n")
+		})
+
+		Convey("The text format should print the family of a packet which is not IP", func() {
+			pr, err := newPrinter(&out, "text")
+			So(err, ShouldBeNil)
+			arp := &nflog.NfPacket{Prefix: "arp"}
+			arp.Family = syscall.AF_BRIDGE
+			arp.Timestamp = p.Timestamp
+			pr.print(arp)
+
+			So(out.String(), ShouldEqual, `2020-01-02T03:04:05Z group=0 prefix="arp" family=bridge`+"\n")
+		})
+
+		Convey("The json format should print a JSON line", func() {
+			pr, err := newPrinter(&out, "json")
+			So(err, ShouldBeNil)
+			pr.interfaces[2] = "eth0"
+			p.InDev = 2
+			p.HasSeq = true
+			pr.print(p)
+			pr.print(p)
+
+			lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+			So(len(lines), ShouldEqual, 2)
+			var r map[string]interface{}
+			So(json.Unmarshal([]byte(lines[0]), &r), ShouldBeNil)
+			So(r["prefix"], ShouldEqual, "ssh-in")
+			So(r["in"], ShouldEqual, "eth0")
+			So(r["proto"], ShouldEqual, "tcp")
+			So(r["src"], ShouldEqual, "10.0.0.1")
+			So(r["dport"], ShouldEqual, 22)
+			So(r["seq"], ShouldEqual, 0)
+			So(r, ShouldNotContainKey, "uid")
+			So(r, ShouldNotContainKey, "out")
+		})
+
+		Convey("The none format should print nothing", func() {
+			pr, err := newPrinter(&out, "none")
+			So(err, ShouldBeNil)
+			pr.print(p)
+			So(out.Len(), ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,212 @@
+// +build linux !darwin
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.aporeto.io/netlink-go/nflog"
+)
+
+// record -- Printed fields of a logged packet, the JSON line of the packet
+type record struct {
+	Time     time.Time `json:"time"`
+	Group    uint16    `json:"group"`
+	Prefix   string    `json:"prefix"`
+	Family   string    `json:"family"`
+	Hook     uint8     `json:"hook"`
+	In       string    `json:"in,omitempty"`
+	Out      string    `json:"out,omitempty"`
+	Protocol string    `json:"proto,omitempty"`
+	Src      string    `json:"src,omitempty"`
+	Dst      string    `json:"dst,omitempty"`
+	SrcPort  uint16    `json:"sport,omitempty"`
+	DstPort  uint16    `json:"dport,omitempty"`
+	Length   uint16    `json:"len,omitempty"`
+	Mark     uint32    `json:"mark,omitempty"`
+	UID      *uint32   `json:"uid,omitempty"`
+	GID      *uint32   `json:"gid,omitempty"`
+	Seq      *uint32   `json:"seq,omitempty"`
+	CTID     uint32    `json:"ct_id,omitempty"`
+	CTMark   uint32    `json:"ct_mark,omitempty"`
+}
+
+// printer -- Prints the records in a format
+// interfaces -- names of the interface indexes looked up, the callback is called by a single reader
+type printer struct {
+	w          io.Writer
+	json       bool
+	none       bool
+	interfaces map[uint32]string
+}
+
+// newPrinter -- Create a printer of records in format: text, json or none
+func newPrinter(w io.Writer, format string) (*printer, error) {
+	p := &printer{w: w, interfaces: make(map[uint32]string)}
+	switch format {
+	case "text":
+	case "json":
+		p.json = true
+	case "none":
+		p.none = true
+	default:
+		return nil, fmt.Errorf("invalid format %q", format)
+	}
+	return p, nil
+}
+
+// print -- Print the record of a logged packet
+func (p *printer) print(pkt *nflog.NfPacket) {
+	if p.none {
+		return
+	}
+
+	r := p.record(pkt)
+	if p.json {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(p.w, "%s\n", line)
+		return
+	}
+	fmt.Fprintln(p.w, r.text())
+}
+
+// record -- Record of a logged packet
+func (p *printer) record(pkt *nflog.NfPacket) *record {
+	r := &record{
+		Time:   pkt.Timestamp,
+		Group:  pkt.Group,
+		Prefix: pkt.Prefix,
+		Family: familyName(pkt.Family),
+		Hook:   pkt.Hook,
+		Mark:   pkt.Mark,
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	if pkt.InDev != 0 {
+		r.In = p.interfaceName(pkt.InDev)
+	}
+	if pkt.OutDev != 0 {
+		r.Out = p.interfaceName(pkt.OutDev)
+	}
+	if pkt.SrcIP != nil {
+		r.Protocol = protocolName(pkt.Protocol)
+		r.Src = pkt.SrcIP.String()
+		r.Dst = pkt.DstIP.String()
+		r.SrcPort = pkt.SrcPort
+		r.DstPort = pkt.DstPort
+		r.Length = pkt.IPLayer.Length
+	}
+	if pkt.HasUID {
+		r.UID = &pkt.UID
+	}
+	if pkt.HasGID {
+		r.GID = &pkt.GID
+	}
+	if pkt.HasSeq {
+		r.Seq = &pkt.Seq
+	}
+	if pkt.Conntrack != nil {
+		r.CTID = pkt.Conntrack.ID
+		r.CTMark = pkt.Conntrack.Mark
+	}
+	return r
+}
+
+// text -- Single line of the record: time, group and prefix, interfaces, addresses and the other fields set
+func (r *record) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s group=%d prefix=%q", r.Time.Format(time.RFC3339Nano), r.Group, r.Prefix)
+	if r.In != "" {
+		fmt.Fprintf(&b, " in=%s", r.In)
+	}
+	if r.Out != "" {
+		fmt.Fprintf(&b, " out=%s", r.Out)
+	}
+	if r.Src != "" {
+		fmt.Fprintf(&b, " %s %s -> %s", r.Protocol, hostPort(r.Src, r.SrcPort), hostPort(r.Dst, r.DstPort))
+		fmt.Fprintf(&b, " len=%d", r.Length)
+	} else {
+		fmt.Fprintf(&b, " family=%s", r.Family)
+	}
+	if r.Mark != 0 {
+		fmt.Fprintf(&b, " mark=0x%x", r.Mark)
+	}
+	if r.UID != nil {
+		fmt.Fprintf(&b, " uid=%d", *r.UID)
+	}
+	if r.GID != nil {
+		fmt.Fprintf(&b, " gid=%d", *r.GID)
+	}
+	if r.Seq != nil {
+		fmt.Fprintf(&b, " seq=%d", *r.Seq)
+	}
+	if r.CTID != 0 {
+		fmt.Fprintf(&b, " ct_id=%d", r.CTID)
+	}
+	if r.CTMark != 0 {
+		fmt.Fprintf(&b, " ct_mark=0x%x", r.CTMark)
+	}
+	return b.String()
+}
+
+// interfaceName -- Name of an interface index in the current network namespace, its index if it does not exist
+func (p *printer) interfaceName(index uint32) string {
+	if name, ok := p.interfaces[index]; ok {
+		return name
+	}
+	name := strconv.Itoa(int(index))
+	if iface, err := net.InterfaceByIndex(int(index)); err == nil {
+		name = iface.Name
+	}
+	p.interfaces[index] = name
+	return name
+}
+
+// hostPort -- Address and port, the address alone without port
+func hostPort(host string, port uint16) string {
+	if port == 0 {
+		return host
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(port)))
+}
+
+// familyName -- Name of a nfgen family
+func familyName(family uint8) string {
+	switch family {
+	case syscall.AF_INET:
+		return "inet"
+	case syscall.AF_INET6:
+		return "inet6"
+	case syscall.AF_BRIDGE:
+		return "bridge"
+	}
+	return strconv.Itoa(int(family))
+}
+
+// protocolName -- Name of an IP protocol
+func protocolName(protocol uint8) string {
+	switch protocol {
+	case syscall.IPPROTO_ICMP:
+		return "icmp"
+	case syscall.IPPROTO_TCP:
+		return "tcp"
+	case syscall.IPPROTO_UDP:
+		return "udp"
+	case syscall.IPPROTO_ICMPV6:
+		return "icmpv6"
+	case syscall.IPPROTO_SCTP:
+		return "sctp"
+	}
+	return strconv.Itoa(int(protocol))
+}
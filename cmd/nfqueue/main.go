@@ -0,0 +1,257 @@
+// +build linux !darwin
+
+// nfqueue attaches to a netfilter queue and sets the verdict of the queued packets from a rule file,
+// printing the decision taken for each packet and periodic stats. Packets are queued by NFQUEUE rules, as:
+//
+//	iptables -A INPUT -p tcp --dport 80 -j NFQUEUE --queue-num 10
+//	nfqueue -queue 10 -rules policy.rules -stats 5s
+//
+// See parseRules for the rule file format.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"go.aporeto.io/netlink-go/common/packet"
+	"go.aporeto.io/netlink-go/nfqueue"
+)
+
+// config -- Command line of the tool
+type config struct {
+	queue     uint
+	rules     string
+	def       string
+	maxLen    uint
+	copyRange uint
+	stats     time.Duration
+	quiet     bool
+	failOpen  bool
+	netns     string
+}
+
+func main() {
+	var cfg config
+	flag.UintVar(&cfg.queue, "queue", 0, "queue number to attach to")
+	flag.StringVar(&cfg.rules, "rules", "", "rule file, every packet gets the default verdict without it")
+	flag.StringVar(&cfg.def, "default", "accept", "action of the packets no rule matches")
+	flag.UintVar(&cfg.maxLen, "max-len", 2000, "max number of packets waiting in the queue")
+	flag.UintVar(&cfg.copyRange, "copy-range", 128, "bytes of each packet copied by the kernel, enough for the headers")
+	flag.DurationVar(&cfg.stats, "stats", 10*time.Second, "interval the stats are printed at, 0 to only print them on exit")
+	flag.BoolVar(&cfg.quiet, "quiet", false, "do not print the decision taken for each packet")
+	flag.BoolVar(&cfg.failOpen, "fail-open", false, "have the kernel accept the packets when the queue is full")
+	flag.StringVar(&cfg.netns, "netns", "", "network namespace to attach in, as /var/run/netns/name")
+	flag.Parse()
+
+	if err := run(&cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run -- Set the verdict of the queued packets until the tool is interrupted
+func run(cfg *config) error {
+	if cfg.queue > 0xffff {
+		return fmt.Errorf("invalid queue %d", cfg.queue)
+	}
+	pl, err := newPolicy(cfg.rules, cfg.def)
+	if err != nil {
+		return err
+	}
+	if !cfg.quiet {
+		pl.out = os.Stdout
+	}
+
+	var opts []nfqueue.Option
+	if cfg.netns != "" {
+		opts = append(opts, nfqueue.WithNetNSPath(cfg.netns))
+	}
+	if cfg.failOpen {
+		opts = append(opts, nfqueue.WithQueueFlags(nfqueue.NfqaCfgFFailOpen))
+	}
+
+	callback := func(p *nfqueue.NFPacket, _ interface{}) {
+		pl.handle(p.QueueHandle, p.QueueHandle.QueueNum, uint32(p.ID), uint32(p.Mark), p.Length, p.Buffer)
+	}
+	errorCallback := func(err error, _ interface{}) {
+		fmt.Fprintln(os.Stderr, err)
+	}
+
+	// The queue is unbound by the kernel when the socket is closed on exit
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := nfqueue.CreateAndStartNfQueue(ctx, uint16(cfg.queue), uint32(cfg.maxLen), uint32(cfg.copyRange), callback, errorCallback, nil, opts...); err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	var tick <-chan time.Time
+	if cfg.stats > 0 {
+		ticker := time.NewTicker(cfg.stats)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	last, lastTime := pl.stats.snapshot(), time.Now()
+	for {
+		select {
+		case <-signals:
+			pl.printStats(os.Stdout, last, lastTime)
+			return nil
+		case <-tick:
+			last, lastTime = pl.printStats(os.Stdout, last, lastTime), time.Now()
+		}
+	}
+}
+
+// verdictSetter -- Verdicts the policy sets, implemented by nfqueue.Verdict
+type verdictSetter interface {
+	Accept(packetID uint32)
+	Drop(packetID uint32)
+	Requeue(packetID uint32, num uint16, bypass bool)
+	SetVerdictMarkByID(packetID uint32, verdict uint32, mark uint32)
+}
+
+// stats -- Number of packets handled and of verdicts set, updated atomically
+type stats struct {
+	packets   uint64
+	accepted  uint64
+	dropped   uint64
+	marked    uint64
+	requeued  uint64
+	undecoded uint64
+}
+
+// snapshot -- Copy of the counters
+func (s *stats) snapshot() stats {
+	return stats{
+		packets:   atomic.LoadUint64(&s.packets),
+		accepted:  atomic.LoadUint64(&s.accepted),
+		dropped:   atomic.LoadUint64(&s.dropped),
+		marked:    atomic.LoadUint64(&s.marked),
+		requeued:  atomic.LoadUint64(&s.requeued),
+		undecoded: atomic.LoadUint64(&s.undecoded),
+	}
+}
+
+// policy -- Sets the verdict of the queued packets from the rules
+// out -- where the decisions are printed, nil to not print them
+type policy struct {
+	rules []*rule
+	def   *rule
+	out   io.Writer
+	lock  sync.Mutex
+	stats stats
+}
+
+// newPolicy -- Create a policy from the rule file at path, if any, and the default action
+func newPolicy(path string, def string) (*policy, error) {
+	pl := &policy{}
+
+	fields := strings.Fields(def)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no default action")
+	}
+	var err error
+	if pl.def, err = parseRule(fields); err != nil {
+		return nil, fmt.Errorf("invalid default action: %w", err)
+	}
+	if !pl.def.match(nil) {
+		return nil, fmt.Errorf("invalid default action %q: conditions are not allowed", def)
+	}
+
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close() // nolint
+		if pl.rules, err = parseRules(f); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	return pl, nil
+}
+
+// handle -- Set the verdict of a queued packet and print the decision
+// length -- length of the packet in the kernel, buf only holds the copy range
+func (pl *policy) handle(v verdictSetter, queue uint16, id uint32, mark uint32, length int, buf []byte) {
+	atomic.AddUint64(&pl.stats.packets, 1)
+
+	p, err := packet.DecodeIP(buf)
+	if err != nil {
+		atomic.AddUint64(&pl.stats.undecoded, 1)
+		p = nil
+	}
+	rl := lookup(pl.rules, p, pl.def)
+
+	switch rl.action {
+	case actionAccept:
+		v.Accept(id)
+		atomic.AddUint64(&pl.stats.accepted, 1)
+	case actionDrop:
+		v.Drop(id)
+		atomic.AddUint64(&pl.stats.dropped, 1)
+	case actionMark:
+		v.SetVerdictMarkByID(id, uint32(nfqueue.NF_ACCEPT), rl.mark)
+		atomic.AddUint64(&pl.stats.marked, 1)
+	case actionRequeue:
+		v.Requeue(id, rl.queue, rl.bypass)
+		atomic.AddUint64(&pl.stats.requeued, 1)
+	}
+
+	if pl.out != nil {
+		pl.lock.Lock()
+		fmt.Fprintln(pl.out, decision(queue, id, mark, length, p, rl))
+		pl.lock.Unlock()
+	}
+}
+
+// printStats -- Print the counters and the packet rate since last, returns the counters printed
+func (pl *policy) printStats(w io.Writer, last stats, lastTime time.Time) stats {
+	s := pl.stats.snapshot()
+	rate := 0.0
+	if elapsed := time.Since(lastTime).Seconds(); elapsed > 0 {
+		rate = float64(s.packets-last.packets) / elapsed
+	}
+
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+	fmt.Fprintf(w, "stats packets=%d accepted=%d dropped=%d marked=%d requeued=%d undecoded=%d rate=%.1f/s\n",
+		s.packets, s.accepted, s.dropped, s.marked, s.requeued, s.undecoded, rate)
+	return s
+}
+
+// decision -- Line printed for a packet: queue, id, headers, mark, the rule line which matched and its action
+func decision(queue uint16, id uint32, mark uint32, length int, p *packet.IPHeaders, rl *rule) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "queue=%d id=%d", queue, id)
+	if p != nil {
+		fmt.Fprintf(&b, " %s %s -> %s", protocolName(p.Protocol), hostPort(p.SrcIP, p.SrcPort), hostPort(p.DstIP, p.DstPort))
+		if p.HasTCPFlags {
+			fmt.Fprintf(&b, " flags=%s", flagsString(p.TCPFlags))
+		}
+	} else {
+		b.WriteString(" undecoded")
+	}
+	fmt.Fprintf(&b, " len=%d mark=0x%x", length, mark)
+	if rl.line != 0 {
+		fmt.Fprintf(&b, " rule=%d", rl.line)
+	} else {
+		b.WriteString(" rule=default")
+	}
+	fmt.Fprintf(&b, " %s", rl)
+	return b.String()
+}
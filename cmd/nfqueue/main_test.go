@@ -0,0 +1,241 @@
+// +build linux !darwin
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/netlink-go/common/packet"
+	"go.aporeto.io/netlink-go/nfqueue"
+)
+
+// verdicts -- verdictSetter recording the verdicts set
+type verdicts struct {
+	set []string
+}
+
+func (v *verdicts) Accept(packetID uint32) {
+	v.set = append(v.set, fmt.Sprintf("%d accept", packetID))
+}
+
+func (v *verdicts) Drop(packetID uint32) {
+	v.set = append(v.set, fmt.Sprintf("%d drop", packetID))
+}
+
+func (v *verdicts) Requeue(packetID uint32, num uint16, bypass bool) {
+	v.set = append(v.set, fmt.Sprintf("%d requeue %d %v", packetID, num, bypass))
+}
+
+func (v *verdicts) SetVerdictMarkByID(packetID uint32, verdict uint32, mark uint32) {
+	v.set = append(v.set, fmt.Sprintf("%d verdict %d mark 0x%x", packetID, verdict, mark))
+}
+
+// tcpPacket -- IPv4 TCP packet from 10.0.0.1:40000 to 10.0.0.2:port with flags
+func tcpPacket(port uint16, flags uint8) []byte {
+	return []byte{
+		0x45, 0x00, 0x00, 0x28, 0x00, 0x01, 0x00, 0x00, 0x40, 0x06, 0x00, 0x00,
+		10, 0, 0, 1, 10, 0, 0, 2,
+		0x9c, 0x40, byte(port >> 8), byte(port), 0, 0, 0, 0, 0, 0, 0, 0, 0x50, flags, 0xff, 0xff, 0, 0, 0, 0,
+	}
+}
+
+// udp6Packet -- IPv6 UDP packet from fd00::1:5353 to fd00::2:53
+func udp6Packet() []byte {
+	p := []byte{0x60, 0, 0, 0, 0, 8, 17, 64}
+	p = append(p, 0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1)
+	p = append(p, 0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2)
+	return append(p, 0x14, 0xe9, 0x00, 0x35, 0, 8, 0, 0)
+}
+
+const testRules = `
+# ssh
+drop dport 22 flags SYN,!ACK
+mark 0x10 proto tcp port 80,8000-8080   # web
+requeue 12 bypass src fd00::/64 proto udp
+accept addr 192.168.0.1
+`
+
+func TestDecodePacket(t *testing.T) {
+	Convey("Given queued packets", t, func() {
+
+		Convey("A TCP packet should be decoded with its ports and flags", func() {
+			p, err := packet.DecodeIP(tcpPacket(22, 0x02))
+			So(err, ShouldBeNil)
+			So(p.Version, ShouldEqual, 4)
+			So(p.Protocol, ShouldEqual, 6)
+			So(p.SrcIP.String(), ShouldEqual, "10.0.0.1")
+			So(p.DstIP.String(), ShouldEqual, "10.0.0.2")
+			So(p.SrcPort, ShouldEqual, 40000)
+			So(p.DstPort, ShouldEqual, 22)
+			So(p.HasTCPFlags, ShouldBeTrue)
+			So(p.TCPFlags, ShouldEqual, 0x02)
+		})
+
+		Convey("An IPv6 UDP packet should be decoded with its ports", func() {
+			p, err := packet.DecodeIP(udp6Packet())
+			So(err, ShouldBeNil)
+			So(p.Version, ShouldEqual, 6)
+			So(p.SrcIP.String(), ShouldEqual, "fd00::1")
+			So(p.DstPort, ShouldEqual, 53)
+			So(p.HasTCPFlags, ShouldBeFalse)
+		})
+
+		Convey("A packet cut after its IP header should be decoded without ports", func() {
+			p, err := packet.DecodeIP(tcpPacket(22, 0x02)[:22])
+			So(err, ShouldBeNil)
+			So(p.SrcPort, ShouldEqual, 0)
+			So(p.HasTCPFlags, ShouldBeFalse)
+		})
+
+		Convey("A packet which is not IP should not be decoded", func() {
+			_, err := packet.DecodeIP(nil)
+			So(errors.Is(err, packet.ErrNotIP), ShouldBeTrue)
+			_, err = packet.DecodeIP([]byte{0x45, 0x00})
+			So(errors.Is(err, packet.ErrIPTruncated), ShouldBeTrue)
+			_, err = packet.DecodeIP([]byte{0x00, 0x01, 0x08, 0x00})
+			So(errors.Is(err, packet.ErrNotIP), ShouldBeTrue)
+		})
+	})
+}
+
+func TestRules(t *testing.T) {
+	Convey("Given a rule file", t, func() {
+		rules, err := parseRules(strings.NewReader(testRules))
+		So(err, ShouldBeNil)
+		So(len(rules), ShouldEqual, 4)
+		def := &rule{action: actionAccept}
+
+		decode := func(buf []byte) *packet.IPHeaders {
+			p, err := packet.DecodeIP(buf)
+			So(err, ShouldBeNil)
+			return p
+		}
+
+		Convey("The rules should be parsed with their line", func() {
+			So(rules[0].line, ShouldEqual, 3)
+			So(rules[0].action, ShouldEqual, actionDrop)
+			So(rules[0].flagsMask, ShouldEqual, 0x12)
+			So(rules[0].flags, ShouldEqual, 0x02)
+			So(rules[1].String(), ShouldEqual, "mark 0x10")
+			So(rules[1].port, ShouldResemble, []portRange{{80, 80}, {8000, 8080}})
+			So(rules[2].String(), ShouldEqual, "requeue 12 bypass")
+		})
+
+		Convey("The first rule a packet matches should be used", func() {
+			So(lookup(rules, decode(tcpPacket(22, 0x02)), def), ShouldEqual, rules[0])
+			So(lookup(rules, decode(tcpPacket(8080, 0x02)), def), ShouldEqual, rules[1])
+			So(lookup(rules, decode(udp6Packet()), def), ShouldEqual, rules[2])
+		})
+
+		Convey("Packets no rule matches should get the default rule", func() {
+			So(lookup(rules, decode(tcpPacket(22, 0x12)), def), ShouldEqual, def)
+			So(lookup(rules, decode(tcpPacket(443, 0x02)), def), ShouldEqual, def)
+			So(lookup(rules, nil, def), ShouldEqual, def)
+		})
+
+		Convey("A packet which was not decoded should only match rules without conditions", func() {
+			all, err := parseRules(strings.NewReader("drop dport 22\ndrop\n"))
+			So(err, ShouldBeNil)
+			So(lookup(all, nil, def), ShouldEqual, all[1])
+		})
+	})
+
+	Convey("Given invalid rule files", t, func() {
+		for _, text := range []string{
+			"reject",
+			"mark",
+			"mark x",
+			"requeue 70000",
+			"accept src",
+			"accept src 10.0.0",
+			"accept dst 10.0.0.0/40",
+			"accept port 80-20",
+			"accept dport http",
+			"accept proto gre",
+			"accept flags SYN,BOOM",
+			"accept iface eth0",
+		} {
+			_, err := parseRules(strings.NewReader("accept\n" + text))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "line 2: ")
+		}
+	})
+}
+
+func TestPolicy(t *testing.T) {
+	Convey("Given a policy from a rule file", t, func() {
+		dir, err := ioutil.TempDir("", "nfqueue")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+		path := filepath.Join(dir, "policy.rules")
+		So(ioutil.WriteFile(path, []byte(testRules), 0600), ShouldBeNil)
+
+		pl, err := newPolicy(path, "drop")
+		So(err, ShouldBeNil)
+		var out bytes.Buffer
+		pl.out = &out
+		v := &verdicts{}
+
+		Convey("The verdicts should be set and the decisions printed", func() {
+			pl.handle(v, 10, 1, 0, 40, tcpPacket(22, 0x02))
+			pl.handle(v, 10, 2, 0x1, 1500, tcpPacket(80, 0x10))
+			pl.handle(v, 10, 3, 0, 48, udp6Packet())
+			pl.handle(v, 10, 4, 0, 40, tcpPacket(443, 0x02))
+			pl.handle(v, 10, 5, 0, 4, []byte{0x00, 0x01, 0x08, 0x00})
+
+			So(v.set, ShouldResemble, []string{
+				"1 drop",
+				fmt.Sprintf("2 verdict %d mark 0x10", nfqueue.NF_ACCEPT),
+				"3 requeue 12 true",
+				"4 drop",
+				"5 drop",
+			})
+			So(strings.Split(out.String(), "\n"), ShouldResemble, []string{
+				"queue=10 id=1 tcp 10.0.0.1:40000 -> 10.0.0.2:22 flags=S len=40 mark=0x0 rule=3 drop",
+				"queue=10 id=2 tcp 10.0.0.1:40000 -> 10.0.0.2:80 flags=A len=1500 mark=0x1 rule=4 mark 0x10",
+				"queue=10 id=3 udp [fd00::1]:5353 -> [fd00::2]:53 len=48 mark=0x0 rule=5 requeue 12 bypass",
+				"queue=10 id=4 tcp 10.0.0.1:40000 -> 10.0.0.2:443 flags=S len=40 mark=0x0 rule=default drop",
+				"queue=10 id=5 undecoded len=4 mark=0x0 rule=default drop",
+				"",
+			})
+
+			Convey("The stats should count the verdicts", func() {
+				var printed bytes.Buffer
+				s := pl.printStats(&printed, stats{}, time.Now().Add(-time.Second))
+				So(s.packets, ShouldEqual, 5)
+				So(printed.String(), ShouldStartWith, "stats packets=5 accepted=0 dropped=3 marked=1 requeued=1 undecoded=1 rate=")
+			})
+		})
+
+		Convey("Nothing should be printed without output", func() {
+			pl.out = nil
+			pl.handle(v, 10, 1, 0, 40, tcpPacket(22, 0x02))
+			So(out.Len(), ShouldEqual, 0)
+			So(v.set, ShouldResemble, []string{"1 drop"})
+		})
+	})
+
+	Convey("Given invalid policies", t, func() {
+		_, err := newPolicy("", "")
+		So(err, ShouldNotBeNil)
+		_, err = newPolicy("", "reject")
+		So(err, ShouldNotBeNil)
+		_, err = newPolicy("", "drop dport 22")
+		So(err, ShouldNotBeNil)
+		_, err = newPolicy("/nonexistent/policy.rules", "accept")
+		So(err, ShouldNotBeNil)
+
+		pl, err := newPolicy("", "mark 0x1")
+		So(err, ShouldBeNil)
+		So(pl.def.action, ShouldEqual, actionMark)
+	})
+}
@@ -0,0 +1,43 @@
+// +build linux !darwin
+
+package main
+
+import (
+	"net"
+	"strconv"
+)
+
+// tcpFlagLetters -- Letters of the TCP flags printed, from FIN to CWR
+const tcpFlagLetters = "FSRPAUEC"
+
+// flagsString -- Letters of the TCP flags set
+func flagsString(flags uint8) string {
+	var s []byte
+	for i := 0; i < len(tcpFlagLetters); i++ {
+		if flags&(1<<uint(i)) != 0 {
+			s = append(s, tcpFlagLetters[i])
+		}
+	}
+	if len(s) == 0 {
+		return "none"
+	}
+	return string(s)
+}
+
+// hostPort -- Address and port, the address alone without port
+func hostPort(ip net.IP, port uint16) string {
+	if port == 0 {
+		return ip.String()
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+}
+
+// protocolName -- Name of an IP protocol in the rule file, its number if it has none
+func protocolName(protocol uint8) string {
+	for name, proto := range protocols {
+		if proto == protocol {
+			return name
+		}
+	}
+	return strconv.Itoa(int(protocol))
+}
@@ -0,0 +1,389 @@
+// +build linux !darwin
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"go.aporeto.io/netlink-go/common/packet"
+)
+
+// action -- Verdict a rule sets on the packets it matches
+type action int
+
+const (
+	actionAccept action = iota
+	actionDrop
+	actionMark
+	actionRequeue
+)
+
+// String -- Name of the action in the rule file
+func (a action) String() string {
+	switch a {
+	case actionAccept:
+		return "accept"
+	case actionDrop:
+		return "drop"
+	case actionMark:
+		return "mark"
+	case actionRequeue:
+		return "requeue"
+	}
+	return strconv.Itoa(int(a))
+}
+
+// portRange -- Ports from first to last included
+type portRange struct {
+	first uint16
+	last  uint16
+}
+
+// rule -- A line of the rule file: an action and the conditions a packet must all match
+// Each condition holds a list of values, one of which must match
+// line -- line of the rule in the file, 0 for the default rule
+// mark -- mark set by actionMark, the packet is accepted
+// queue, bypass -- queue actionRequeue moves the packet to, accepting it if no program listens with bypass
+// src, dst, addr -- networks of the source, destination or any of them
+// sport, dport, port -- ports of the source, destination or any of them
+// protocols -- IP protocols
+// flagsMask, flags -- TCP flags: the bits of flagsMask must equal the ones of flags
+type rule struct {
+	line      int
+	action    action
+	mark      uint32
+	queue     uint16
+	bypass    bool
+	src       []*net.IPNet
+	dst       []*net.IPNet
+	addr      []*net.IPNet
+	sport     []portRange
+	dport     []portRange
+	port      []portRange
+	protocols []uint8
+	flagsMask uint8
+	flags     uint8
+}
+
+// tcpFlags -- Names of the TCP flags in the rule file
+var tcpFlags = map[string]uint8{
+	"FIN": 0x01,
+	"SYN": 0x02,
+	"RST": 0x04,
+	"PSH": 0x08,
+	"ACK": 0x10,
+	"URG": 0x20,
+	"ECE": 0x40,
+	"CWR": 0x80,
+}
+
+// protocols -- Names of the IP protocols in the rule file
+var protocols = map[string]uint8{
+	"icmp":   syscall.IPPROTO_ICMP,
+	"tcp":    syscall.IPPROTO_TCP,
+	"udp":    syscall.IPPROTO_UDP,
+	"icmpv6": syscall.IPPROTO_ICMPV6,
+	"sctp":   syscall.IPPROTO_SCTP,
+}
+
+// parseRules -- Parse a rule file, one rule per line:
+//
+//	<action> [<condition> <values>]...
+//
+// Actions: accept, drop, mark <mark>, requeue <queue> [bypass]
+// Conditions: src, dst, addr <address or CIDR>; sport, dport, port <port or first-last>;
+// proto <tcp, udp, icmp, icmpv6, sctp or number>; flags <SYN,!ACK...>, the flags set or cleared with !
+// Values are comma separated, # starts a comment
+func parseRules(r io.Reader) ([]*rule, error) {
+	var rules []*rule
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+
+		rl, err := parseRule(fields)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rl.line = line
+		rules = append(rules, rl)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// parseRule -- Parse the fields of a rule
+func parseRule(fields []string) (*rule, error) {
+	rl := &rule{}
+
+	fields, err := rl.parseAction(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	for len(fields) > 0 {
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("no value for %s", fields[0])
+		}
+		condition, values := fields[0], strings.Split(fields[1], ",")
+		fields = fields[2:]
+
+		switch condition {
+		case "src":
+			rl.src, err = parseNets(values)
+		case "dst":
+			rl.dst, err = parseNets(values)
+		case "addr":
+			rl.addr, err = parseNets(values)
+		case "sport":
+			rl.sport, err = parsePorts(values)
+		case "dport":
+			rl.dport, err = parsePorts(values)
+		case "port":
+			rl.port, err = parsePorts(values)
+		case "proto":
+			rl.protocols, err = parseProtocols(values)
+		case "flags":
+			err = rl.parseFlags(values)
+		default:
+			err = fmt.Errorf("unknown condition %q", condition)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rl, nil
+}
+
+// parseAction -- Parse the action at the start of the fields, returns the fields left
+func (rl *rule) parseAction(fields []string) ([]string, error) {
+	switch fields[0] {
+	case "accept":
+		rl.action = actionAccept
+		return fields[1:], nil
+	case "drop":
+		rl.action = actionDrop
+		return fields[1:], nil
+	case "mark":
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("no mark")
+		}
+		mark, err := strconv.ParseUint(fields[1], 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mark %q: %w", fields[1], err)
+		}
+		rl.action = actionMark
+		rl.mark = uint32(mark)
+		return fields[2:], nil
+	case "requeue":
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("no queue")
+		}
+		queue, err := strconv.ParseUint(fields[1], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid queue %q: %w", fields[1], err)
+		}
+		rl.action = actionRequeue
+		rl.queue = uint16(queue)
+		fields = fields[2:]
+		if len(fields) > 0 && fields[0] == "bypass" {
+			rl.bypass = true
+			fields = fields[1:]
+		}
+		return fields, nil
+	}
+	return nil, fmt.Errorf("unknown action %q", fields[0])
+}
+
+// parseFlags -- Parse the TCP flags, the rule only matches TCP packets
+func (rl *rule) parseFlags(values []string) error {
+	for _, value := range values {
+		set := true
+		if strings.HasPrefix(value, "!") {
+			set = false
+			value = value[1:]
+		}
+		flag, ok := tcpFlags[strings.ToUpper(value)]
+		if !ok {
+			return fmt.Errorf("unknown TCP flag %q", value)
+		}
+		rl.flagsMask |= flag
+		if set {
+			rl.flags |= flag
+		}
+	}
+	return nil
+}
+
+// parseNets -- Parse addresses or CIDRs, an address is a network of a single address
+func parseNets(values []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, value := range values {
+		if strings.Contains(value, "/") {
+			_, ipNet, err := net.ParseCIDR(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid network %q: %w", value, err)
+			}
+			nets = append(nets, ipNet)
+			continue
+		}
+
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address %q", value)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			nets = append(nets, &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)})
+		} else {
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)})
+		}
+	}
+	return nets, nil
+}
+
+// parsePorts -- Parse ports or port ranges first-last
+func parsePorts(values []string) ([]portRange, error) {
+	var ports []portRange
+	for _, value := range values {
+		first, last := value, value
+		if i := strings.IndexByte(value, '-'); i >= 0 {
+			first, last = value[:i], value[i+1:]
+		}
+		f, err := strconv.ParseUint(first, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q: %w", value, err)
+		}
+		l, err := strconv.ParseUint(last, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q: %w", value, err)
+		}
+		if l < f {
+			return nil, fmt.Errorf("invalid port range %q", value)
+		}
+		ports = append(ports, portRange{first: uint16(f), last: uint16(l)})
+	}
+	return ports, nil
+}
+
+// parseProtocols -- Parse protocol names or numbers
+func parseProtocols(values []string) ([]uint8, error) {
+	var protos []uint8
+	for _, value := range values {
+		if proto, ok := protocols[strings.ToLower(value)]; ok {
+			protos = append(protos, proto)
+			continue
+		}
+		proto, err := strconv.ParseUint(value, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid protocol %q: %w", value, err)
+		}
+		protos = append(protos, uint8(proto))
+	}
+	return protos, nil
+}
+
+// match -- True if the packet matches every condition of the rule.
+// A packet which could not be decoded only matches rules without conditions.
+func (rl *rule) match(p *packet.IPHeaders) bool {
+	if p == nil {
+		return rl.src == nil && rl.dst == nil && rl.addr == nil && rl.sport == nil && rl.dport == nil &&
+			rl.port == nil && rl.protocols == nil && rl.flagsMask == 0
+	}
+
+	if rl.src != nil && !matchNets(rl.src, p.SrcIP) {
+		return false
+	}
+	if rl.dst != nil && !matchNets(rl.dst, p.DstIP) {
+		return false
+	}
+	if rl.addr != nil && !matchNets(rl.addr, p.SrcIP) && !matchNets(rl.addr, p.DstIP) {
+		return false
+	}
+	if rl.sport != nil && !matchPorts(rl.sport, p.SrcPort) {
+		return false
+	}
+	if rl.dport != nil && !matchPorts(rl.dport, p.DstPort) {
+		return false
+	}
+	if rl.port != nil && !matchPorts(rl.port, p.SrcPort) && !matchPorts(rl.port, p.DstPort) {
+		return false
+	}
+	if rl.protocols != nil && !matchProtocols(rl.protocols, p.Protocol) {
+		return false
+	}
+	if rl.flagsMask != 0 && (!p.HasTCPFlags || p.TCPFlags&rl.flagsMask != rl.flags) {
+		return false
+	}
+	return true
+}
+
+// String -- The action of the rule and its parameters
+func (rl *rule) String() string {
+	switch rl.action {
+	case actionMark:
+		return fmt.Sprintf("mark 0x%x", rl.mark)
+	case actionRequeue:
+		if rl.bypass {
+			return fmt.Sprintf("requeue %d bypass", rl.queue)
+		}
+		return fmt.Sprintf("requeue %d", rl.queue)
+	}
+	return rl.action.String()
+}
+
+func matchNets(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPorts -- True if port is in one of the ranges, port 0 is only set for packets without ports
+func matchPorts(ports []portRange, port uint16) bool {
+	for _, r := range ports {
+		if port >= r.first && port <= r.last && port != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func matchProtocols(protos []uint8, proto uint8) bool {
+	for _, p := range protos {
+		if p == proto {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup -- The first rule the packet matches, def if it matches none
+func lookup(rules []*rule, p *packet.IPHeaders, def *rule) *rule {
+	for _, rl := range rules {
+		if rl.match(p) {
+			return rl
+		}
+	}
+	return def
+}
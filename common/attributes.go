@@ -0,0 +1,360 @@
+// +build linux !darwin
+
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+var (
+	// ErrAttrTruncated is returned when an attribute header or its payload runs past the buffer
+	ErrAttrTruncated = errors.New("netlink attribute truncated")
+	// ErrAttrInvalidLength is returned when an attribute length is smaller than its header
+	ErrAttrInvalidLength = errors.New("netlink attribute has invalid length")
+	// ErrAttrValueTooShort is returned when an attribute payload is too short for the value read
+	ErrAttrValueTooShort = errors.New("netlink attribute value too short")
+	// ErrAttrTooLarge is recorded by AttrBuilder when an attribute or a nest does not fit in the 16 bit length
+	ErrAttrTooLarge = errors.New("netlink attribute too large")
+)
+
+//AttrBuilder -- Builds a sequence of netlink attributes in a byte slice
+//Attributes are padded to 4 bytes. The length of a nested attribute is filled in
+//when the nest is closed, so nested attributes can be built in any order.
+//buf -- the serialized attributes
+//nests -- offsets of the nested attributes not closed yet
+//err -- first attribute too large for its length, the attributes must not be sent
+type AttrBuilder struct {
+	buf   []byte
+	nests []int
+	err   error
+}
+
+//NewAttrBuilder -- Create a builder appending to buf. Pass buf[:0] to reuse a buffer.
+func NewAttrBuilder(buf []byte) *AttrBuilder {
+	return &AttrBuilder{buf: buf}
+}
+
+//Bytes -- Returns the attributes built so far
+func (b *AttrBuilder) Bytes() []byte {
+	return b.buf
+}
+
+//Err -- Returns ErrAttrTooLarge if an attribute or a nest was larger than its 16 bit length allows.
+//The attributes are still built but their length is wrong, they must not be sent.
+func (b *AttrBuilder) Err() error {
+	return b.err
+}
+
+//Len -- Returns the length of the attributes built so far
+func (b *AttrBuilder) Len() int {
+	return len(b.buf)
+}
+
+//PutHeader -- Add only the header of an attribute whose payload of dataLen bytes is sent separately
+//The payload is expected to be padded to 4 bytes by the caller
+func (b *AttrBuilder) PutHeader(attrType uint16, dataLen int) {
+	b.buf = b.grow(int(SizeofNfAttr))
+	b.putHeader(len(b.buf)-int(SizeofNfAttr), attrType, int(SizeofNfAttr)+dataLen)
+}
+
+//PutBytes -- Add an attribute with data as payload
+func (b *AttrBuilder) PutBytes(attrType uint16, data []byte) {
+	start := len(b.buf)
+	b.buf = b.grow(int(NfaAlign32(uint32(SizeofNfAttr) + uint32(len(data)))))
+	b.putHeader(start, attrType, int(SizeofNfAttr)+len(data))
+	copy(b.buf[start+int(SizeofNfAttr):], data)
+}
+
+//PutString -- Add a null terminated string attribute
+func (b *AttrBuilder) PutString(attrType uint16, s string) {
+	start := len(b.buf)
+	b.buf = b.grow(int(NfaAlign32(uint32(SizeofNfAttr) + uint32(len(s)) + 1)))
+	b.putHeader(start, attrType, int(SizeofNfAttr)+len(s)+1)
+	copy(b.buf[start+int(SizeofNfAttr):], s)
+}
+
+//Reserve -- Add an attribute with a zeroed payload of size bytes and return the payload to fill in
+func (b *AttrBuilder) Reserve(attrType uint16, size int) []byte {
+	return b.putValue(attrType, size)
+}
+
+//PutUint8 -- Add a u8 attribute
+func (b *AttrBuilder) PutUint8(attrType uint16, v uint8) {
+	data := b.putValue(attrType, 1)
+	data[0] = v
+}
+
+//PutUint16 -- Add a u16 attribute in network byte order
+func (b *AttrBuilder) PutUint16(attrType uint16, v uint16) {
+	binary.BigEndian.PutUint16(b.putValue(attrType, 2), v)
+}
+
+//PutUint32 -- Add a u32 attribute in network byte order
+func (b *AttrBuilder) PutUint32(attrType uint16, v uint32) {
+	binary.BigEndian.PutUint32(b.putValue(attrType, 4), v)
+}
+
+//PutUint64 -- Add a u64 attribute in network byte order
+func (b *AttrBuilder) PutUint64(attrType uint16, v uint64) {
+	binary.BigEndian.PutUint64(b.putValue(attrType, 8), v)
+}
+
+//PutNativeUint32 -- Add a u32 attribute in host byte order
+func (b *AttrBuilder) PutNativeUint32(attrType uint16, v uint32) {
+	NativeEndian().PutUint32(b.putValue(attrType, 4), v)
+}
+
+//BeginNested -- Open a nested attribute, NLA_F_NESTED is added to the type
+//Every attribute added until the matching EndNested is part of the nest
+func (b *AttrBuilder) BeginNested(attrType uint16) {
+	b.nests = append(b.nests, len(b.buf))
+	b.PutHeader(attrType|NLA_F_NESTED, 0)
+}
+
+//EndNested -- Close the last nested attribute opened and fill in its length
+func (b *AttrBuilder) EndNested() {
+	if len(b.nests) == 0 {
+		return
+	}
+	start := b.nests[len(b.nests)-1]
+	b.nests = b.nests[:len(b.nests)-1]
+	b.putLen(start, len(b.buf)-start)
+}
+
+//putValue -- Add an attribute with a fixed size payload and return the payload slice
+func (b *AttrBuilder) putValue(attrType uint16, size int) []byte {
+	start := len(b.buf)
+	b.buf = b.grow(int(NfaAlign32(uint32(SizeofNfAttr) + uint32(size))))
+	b.putHeader(start, attrType, int(SizeofNfAttr)+size)
+	return b.buf[start+int(SizeofNfAttr) : start+int(SizeofNfAttr)+size]
+}
+
+//putHeader -- Write an attribute header at offset
+func (b *AttrBuilder) putHeader(offset int, attrType uint16, attrLen int) {
+	NativeEndian().PutUint16(b.buf[offset+2:], attrType)
+	b.putLen(offset, attrLen)
+}
+
+//putLen -- Write the length of the attribute at offset, recording ErrAttrTooLarge if it does not fit
+func (b *AttrBuilder) putLen(offset int, attrLen int) {
+	if attrLen > 0xffff && b.err == nil {
+		b.err = fmt.Errorf("%w: attribute of type %d is %d bytes", ErrAttrTooLarge, NativeEndian().Uint16(b.buf[offset+2:])&nlaTypeMask, attrLen)
+	}
+	NativeEndian().PutUint16(b.buf[offset:], uint16(attrLen))
+}
+
+//grow -- Extend the buffer by n zeroed bytes
+func (b *AttrBuilder) grow(n int) []byte {
+	l := len(b.buf)
+	if l+n <= cap(b.buf) {
+		buf := b.buf[:l+n]
+		for i := l; i < l+n; i++ {
+			buf[i] = 0
+		}
+		return buf
+	}
+	buf := make([]byte, l+n, 2*cap(b.buf)+n)
+	copy(buf, b.buf)
+	return buf
+}
+
+//NfnlRequest -- Builds a nfnetlink message: netlink header, nfgen header and attributes
+type NfnlRequest struct {
+	AttrBuilder
+}
+
+//NewNfnlRequest -- Start a nfnetlink message
+//buf -- buffer to reuse, may be nil
+//msgType -- subsystem and message type
+//msgFlags -- request flags
+//family -- nfgen family
+//resID -- nfgen resource id (queue or group number)
+func NewNfnlRequest(buf []byte, msgType msgTypes, msgFlags NlmFlags, family uint8, resID uint16) *NfnlRequest {
+	r := &NfnlRequest{AttrBuilder{buf: buf[:0]}}
+	r.buf = r.grow(syscall.SizeofNlMsghdr + int(SizeofNfGenMsg))
+	SerializeNlMsgHdrBuf(&syscall.NlMsghdr{
+		Type:  uint16(msgType),
+		Flags: uint16(msgFlags),
+	}, r.buf)
+	nfgen := &NfqGenMsg{nfgenFamily: family, version: NFNetlinkV0, resID: resID}
+	nfgen.ToWireFormatBuf(r.buf[syscall.SizeofNlMsghdr:])
+	return r
+}
+
+//Bytes -- Returns the serialized message with its length filled in
+func (r *NfnlRequest) Bytes() []byte {
+	return r.BytesWithPayload(0)
+}
+
+//BytesWithPayload -- Returns the serialized message, its length accounts for payloadLen
+//bytes which are sent separately after it (iovec)
+func (r *NfnlRequest) BytesWithPayload(payloadLen int) []byte {
+	NativeEndian().PutUint32(r.buf, uint32(len(r.buf)+payloadLen))
+	return r.buf
+}
+
+//Message -- Returns the request as a syscall.NetlinkMessage
+func (r *NfnlRequest) Message() *syscall.NetlinkMessage {
+	hdr, data, _ := NetlinkMessageToStruct(r.Bytes())
+	return &syscall.NetlinkMessage{
+		Header: *hdr,
+		Data:   data,
+	}
+}
+
+//AttrDecoder -- Walks a sequence of netlink attributes without copying them
+//Lengths are validated, the first malformed attribute stops the walk and is reported by Err.
+//Values are slices of the decoded buffer and are only valid as long as the buffer is.
+type AttrDecoder struct {
+	buf      []byte
+	offset   int
+	attrType uint16
+	data     []byte
+	err      error
+}
+
+//NewAttrDecoder -- Create a decoder for the attributes in buf
+func NewAttrDecoder(buf []byte) AttrDecoder {
+	return AttrDecoder{buf: buf}
+}
+
+//Next -- Advance to the next attribute. Returns false at the end or on error
+func (d *AttrDecoder) Next() bool {
+	if d.err != nil || d.offset >= len(d.buf) {
+		return false
+	}
+
+	rest := d.buf[d.offset:]
+	if len(rest) < int(SizeofNfAttr) {
+		d.err = fmt.Errorf("%w: %d bytes left at offset %d", ErrAttrTruncated, len(rest), d.offset)
+		return false
+	}
+
+	attrLen := int(NativeEndian().Uint16(rest))
+	if attrLen < int(SizeofNfAttr) {
+		d.err = fmt.Errorf("%w: length %d at offset %d", ErrAttrInvalidLength, attrLen, d.offset)
+		return false
+	}
+	if attrLen > len(rest) {
+		d.err = fmt.Errorf("%w: length %d at offset %d, %d bytes left", ErrAttrTruncated, attrLen, d.offset, len(rest))
+		return false
+	}
+
+	d.attrType = NativeEndian().Uint16(rest[2:])
+	d.data = rest[SizeofNfAttr:attrLen]
+	d.offset += int(NfaAlign32(uint32(attrLen)))
+	return true
+}
+
+//Err -- Returns the error which stopped the walk, nil if the attributes were well formed
+func (d *AttrDecoder) Err() error {
+	return d.err
+}
+
+//SetErr -- Stop the walk with err, the error of a nested decoder. Does nothing if err is nil
+//or an error was already recorded.
+func (d *AttrDecoder) SetErr(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+//Type -- Type of the current attribute without the NLA_F_NESTED and NLA_F_NET_BYTEORDER flags
+func (d *AttrDecoder) Type() uint16 {
+	return d.attrType & nlaTypeMask
+}
+
+//IsNested -- True if the current attribute has the NLA_F_NESTED flag
+func (d *AttrDecoder) IsNested() bool {
+	return d.attrType&NLA_F_NESTED != 0
+}
+
+//IsNetByteOrder -- True if the current attribute has the NLA_F_NET_BYTEORDER flag
+func (d *AttrDecoder) IsNetByteOrder() bool {
+	return d.attrType&NLA_F_NET_BYTEORDER != 0
+}
+
+//Bytes -- Payload of the current attribute
+func (d *AttrDecoder) Bytes() []byte {
+	return d.data
+}
+
+//String -- Payload of the current attribute as a string, up to the first null byte
+func (d *AttrDecoder) String() string {
+	return nullTerminated(d.data)
+}
+
+//Nested -- Decoder for the attributes nested in the current attribute
+func (d *AttrDecoder) Nested() AttrDecoder {
+	return AttrDecoder{buf: d.data}
+}
+
+//Struct -- Payload of the current attribute holding a struct of size bytes, nil if it is shorter
+func (d *AttrDecoder) Struct(size int) []byte {
+	if !d.check(size) {
+		return nil
+	}
+	return d.data
+}
+
+//Uint8 -- Payload of the current attribute as u8
+func (d *AttrDecoder) Uint8() uint8 {
+	if !d.check(1) {
+		return 0
+	}
+	return d.data[0]
+}
+
+//Uint16 -- Payload of the current attribute as u16 in network byte order
+func (d *AttrDecoder) Uint16() uint16 {
+	if !d.check(2) {
+		return 0
+	}
+	return binary.BigEndian.Uint16(d.data)
+}
+
+//Uint32 -- Payload of the current attribute as u32 in network byte order
+func (d *AttrDecoder) Uint32() uint32 {
+	if !d.check(4) {
+		return 0
+	}
+	return binary.BigEndian.Uint32(d.data)
+}
+
+//Uint64 -- Payload of the current attribute as u64 in network byte order
+func (d *AttrDecoder) Uint64() uint64 {
+	if !d.check(8) {
+		return 0
+	}
+	return binary.BigEndian.Uint64(d.data)
+}
+
+//NativeUint32 -- Payload of the current attribute as u32 in host byte order
+func (d *AttrDecoder) NativeUint32() uint32 {
+	if !d.check(4) {
+		return 0
+	}
+	return NativeEndian().Uint32(d.data)
+}
+
+//NativeUint64 -- Payload of the current attribute as u64 in host byte order
+func (d *AttrDecoder) NativeUint64() uint64 {
+	if !d.check(8) {
+		return 0
+	}
+	return NativeEndian().Uint64(d.data)
+}
+
+//check -- Record an error if the payload is shorter than size
+func (d *AttrDecoder) check(size int) bool {
+	if len(d.data) >= size {
+		return true
+	}
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: type %d has %d bytes, want %d", ErrAttrValueTooShort, d.Type(), len(d.data), size)
+	}
+	return false
+}
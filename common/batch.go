@@ -0,0 +1,72 @@
+// +build linux !darwin
+
+package common
+
+import (
+	"syscall"
+
+	"go.aporeto.io/netlink-go/common/syscallwrappers"
+)
+
+//RecvBatch -- Preallocated buffers several datagrams are read in with one recvmmsg call
+//The datagrams are valid until the next Socket.RecvBatch on the batch
+type RecvBatch struct {
+	bufs [][]byte
+	iovs []syscall.Iovec
+	msgs []syscallwrappers.Mmsghdr
+	n    int
+	next int
+}
+
+//NewRecvBatch -- Allocate a batch of count buffers of size bytes
+func NewRecvBatch(count int, size int) *RecvBatch {
+	b := &RecvBatch{
+		bufs: make([][]byte, count),
+		iovs: make([]syscall.Iovec, count),
+		msgs: make([]syscallwrappers.Mmsghdr, count),
+	}
+	for i := range b.bufs {
+		b.bufs[i] = make([]byte, size)
+		b.iovs[i].Base = &b.bufs[i][0]
+		b.iovs[i].SetLen(size)
+		b.msgs[i].Hdr.Iov = &b.iovs[i]
+		b.msgs[i].Hdr.Iovlen = 1
+	}
+	return b
+}
+
+//Cap -- Maximum number of datagrams read in one call
+func (b *RecvBatch) Cap() int {
+	return len(b.msgs)
+}
+
+//Len -- Number of datagrams read by the last call
+func (b *RecvBatch) Len() int {
+	return b.n
+}
+
+//Datagram -- The i-th datagram read by the last call
+func (b *RecvBatch) Datagram(i int) []byte {
+	return b.bufs[i][:b.msgs[i].Len]
+}
+
+//Next -- The next datagram not returned yet, false once all of them were
+func (b *RecvBatch) Next() ([]byte, bool) {
+	if b.next >= b.n {
+		return nil, false
+	}
+	b.next++
+	return b.Datagram(b.next - 1), true
+}
+
+//RecvBatch -- Read up to b.Cap() datagrams with one recvmmsg call
+//flags -- syscall.MSG_WAITFORONE returns as soon as one datagram was read on a blocking socket
+func (sh *Socket) RecvBatch(b *RecvBatch, flags int) (int, error) {
+	b.n, b.next = 0, 0
+	n, err := sh.Syscalls.Recvmmsg(sh.fd, b.msgs, flags)
+	if err != nil {
+		return 0, err
+	}
+	b.n = n
+	return n, nil
+}
@@ -0,0 +1,580 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/netlink-go/common/syscallwrappers"
+	"golang.org/x/sys/unix"
+)
+
+// errorMessage builds the payload of a NLMSG_ERROR message for a request of reqLen bytes
+func errorMessage(errno int32, reqLen uint32, tlvs []byte) []byte {
+	buf := make([]byte, 4+int(NlMsgAlign(reqLen)))
+	NativeEndian().PutUint32(buf, uint32(errno))
+	SerializeNlMsgHdrBuf(&syscall.NlMsghdr{Len: reqLen, Type: uint16(NfnlConntrackTable), Seq: 7}, buf[4:])
+	return append(buf, tlvs...)
+}
+
+// extAckAttr builds one extended ACK TLV
+func extAckAttr(attrType uint16, data []byte) []byte {
+	buf := make([]byte, NfaAlign(SizeofNfAttr+uint16(len(data))))
+	NativeEndian().PutUint16(buf, SizeofNfAttr+uint16(len(data)))
+	NativeEndian().PutUint16(buf[2:], attrType)
+	copy(buf[SizeofNfAttr:], data)
+	return buf
+}
+
+func TestNewNetlinkError(t *testing.T) {
+	Convey("Given I receive an ACK", t, func() {
+		hdr := &syscall.NlMsghdr{Type: syscall.NLMSG_ERROR}
+		err := NewNetlinkError(hdr, errorMessage(0, 20, nil))
+
+		Convey("Then I should not get any error", func() {
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("Given I receive a NLMSG_ERROR with ENOENT", t, func() {
+		hdr := &syscall.NlMsghdr{Type: syscall.NLMSG_ERROR}
+		err := NewNetlinkError(hdr, errorMessage(-int32(syscall.ENOENT), 20, nil))
+
+		Convey("Then the error should match the errno and carry the request header", func() {
+			So(err, ShouldNotBeNil)
+			So(errors.Is(err, syscall.ENOENT), ShouldBeTrue)
+			So(errors.Is(err, syscall.EPERM), ShouldBeFalse)
+
+			var nlErr *NetlinkError
+			So(errors.As(err, &nlErr), ShouldBeTrue)
+			So(nlErr.Header.Seq, ShouldEqual, 7)
+			So(nlErr.Header.Len, ShouldEqual, 20)
+		})
+	})
+
+	Convey("Given I receive a NLMSG_ERROR with extended ACK attributes", t, func() {
+		offset := make([]byte, 4)
+		NativeEndian().PutUint32(offset, 24)
+		tlvs := append(extAckAttr(NLMSGERR_ATTR_MSG, []byte("missing attribute\x00")), extAckAttr(NLMSGERR_ATTR_OFFS, offset)...)
+
+		hdr := &syscall.NlMsghdr{Type: syscall.NLMSG_ERROR, Flags: uint16(NlmFAckTlvs)}
+		err := NewNetlinkError(hdr, errorMessage(-int32(syscall.EINVAL), 30, tlvs))
+
+		Convey("Then the message and offset should be set", func() {
+			var nlErr *NetlinkError
+			So(errors.As(err, &nlErr), ShouldBeTrue)
+			So(nlErr.Errno, ShouldEqual, syscall.EINVAL)
+			So(nlErr.Msg, ShouldEqual, "missing attribute")
+			So(nlErr.Offset, ShouldEqual, 24)
+		})
+	})
+
+	Convey("Given I receive a capped NLMSG_ERROR with a policy attribute", t, func() {
+		u32 := func(v uint32) []byte {
+			b := make([]byte, 4)
+			NativeEndian().PutUint32(b, v)
+			return b
+		}
+		u64 := func(v uint64) []byte {
+			b := make([]byte, 8)
+			NativeEndian().PutUint64(b, v)
+			return b
+		}
+		policy := append(extAckAttr(NL_POLICY_TYPE_ATTR_TYPE, u32(4)), extAckAttr(NL_POLICY_TYPE_ATTR_MAX_VALUE_U, u64(65535))...)
+
+		// With NETLINK_CAP_ACK only the header of the request is echoed back
+		payload := errorMessage(-int32(syscall.ERANGE), syscall.SizeofNlMsghdr, nil)
+		NativeEndian().PutUint32(payload[4:], 64)
+		payload = append(payload, extAckAttr(NLMSGERR_ATTR_POLICY|0x8000, policy)...)
+
+		hdr := &syscall.NlMsghdr{Type: syscall.NLMSG_ERROR, Flags: uint16(NlmFAckTlvs | NlmFCapped)}
+		err := NewNetlinkError(hdr, payload)
+
+		Convey("Then the policy should be decoded", func() {
+			var nlErr *NetlinkError
+			So(errors.As(err, &nlErr), ShouldBeTrue)
+			So(nlErr.Header.Len, ShouldEqual, 64)
+			So(nlErr.Policy, ShouldNotBeNil)
+			So(nlErr.Policy.Type, ShouldEqual, "u32")
+			So(nlErr.Policy.Max, ShouldEqual, 65535)
+			So(err.Error(), ShouldContainSubstring, "type u32")
+		})
+	})
+
+	Convey("Given I receive a truncated NLMSG_ERROR", t, func() {
+		err := NewNetlinkError(&syscall.NlMsghdr{Type: syscall.NLMSG_ERROR}, []byte{0xfe})
+
+		Convey("Then I should get an error", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestAttrBuilder(t *testing.T) {
+	Convey("Given I build nested attributes", t, func() {
+		b := NewAttrBuilder(nil)
+		b.BeginNested(1)
+		b.PutUint8(2, 6)
+		b.PutUint16(3, 80)
+		b.EndNested()
+		b.PutString(4, "abc")
+		b.PutUint32(5, 0x01020304)
+
+		Convey("Then every attribute should be padded and the nest length filled in", func() {
+			buf := b.Bytes()
+			So(len(buf), ShouldEqual, 4+8+8+8+8)
+			So(NativeEndian().Uint16(buf), ShouldEqual, 20)
+			So(NativeEndian().Uint16(buf[2:]), ShouldEqual, 1|NLA_F_NESTED)
+			So(buf[16:18], ShouldResemble, []byte{0, 80})
+			So(buf[24:28], ShouldResemble, []byte("abc\x00"))
+		})
+
+		Convey("Then no error should be recorded", func() {
+			So(b.Err(), ShouldBeNil)
+		})
+
+		Convey("Then I should be able to decode them back", func() {
+			attrs := NewAttrDecoder(b.Bytes())
+			So(attrs.Next(), ShouldBeTrue)
+			So(attrs.Type(), ShouldEqual, 1)
+			So(attrs.IsNested(), ShouldBeTrue)
+
+			nested := attrs.Nested()
+			So(nested.Next(), ShouldBeTrue)
+			So(nested.Uint8(), ShouldEqual, 6)
+			So(nested.Next(), ShouldBeTrue)
+			So(nested.Uint16(), ShouldEqual, 80)
+			So(nested.Next(), ShouldBeFalse)
+			So(nested.Err(), ShouldBeNil)
+
+			So(attrs.Next(), ShouldBeTrue)
+			So(attrs.String(), ShouldEqual, "abc")
+			So(attrs.Next(), ShouldBeTrue)
+			So(attrs.Uint32(), ShouldEqual, 0x01020304)
+			So(attrs.Next(), ShouldBeFalse)
+			So(attrs.Err(), ShouldBeNil)
+		})
+	})
+
+	Convey("Given I build attributes larger than 64KiB", t, func() {
+		Convey("When a single attribute is too large", func() {
+			b := NewAttrBuilder(nil)
+			b.PutBytes(7, make([]byte, 0x10000))
+
+			Convey("Then ErrAttrTooLarge should be recorded", func() {
+				So(errors.Is(b.Err(), ErrAttrTooLarge), ShouldBeTrue)
+			})
+		})
+
+		Convey("When a nest holds more than 64KiB of attributes", func() {
+			b := NewAttrBuilder(nil)
+			b.BeginNested(1)
+			b.PutBytes(2, make([]byte, 0x8000))
+			b.PutBytes(3, make([]byte, 0x8000))
+			So(b.Err(), ShouldBeNil)
+			b.EndNested()
+
+			Convey("Then ErrAttrTooLarge should be recorded for the nest", func() {
+				So(errors.Is(b.Err(), ErrAttrTooLarge), ShouldBeTrue)
+				So(b.Err().Error(), ShouldContainSubstring, "type 1 ")
+			})
+		})
+
+		Convey("When a request holds a too large attribute", func() {
+			req := NewNfnlRequest(nil, NfnlNFLog, NlmFRequest|NlmFAck, syscall.AF_INET, 3)
+			req.PutBytes(1, make([]byte, 0x10000))
+
+			Convey("Then the request should report it", func() {
+				So(errors.Is(req.Err(), ErrAttrTooLarge), ShouldBeTrue)
+			})
+		})
+	})
+
+	Convey("Given I build a nfnetlink request", t, func() {
+		req := NewNfnlRequest(nil, NfnlConntrackTable, NlmFRequest|NlmFAck, syscall.AF_INET, 3)
+		req.PutUint32(1, 10)
+		msg := req.Message()
+
+		Convey("Then the headers should be set", func() {
+			So(msg.Header.Len, ShouldEqual, syscall.SizeofNlMsghdr+SizeofNfGenMsg+8)
+			So(msg.Header.Type, ShouldEqual, uint16(NfnlConntrackTable))
+			So(msg.Header.Flags, ShouldEqual, uint16(NlmFRequest|NlmFAck))
+			So(msg.Data[0], ShouldEqual, syscall.AF_INET)
+			So(msg.Data[2:4], ShouldResemble, []byte{0, 3})
+		})
+	})
+}
+
+func TestAttrDecoder(t *testing.T) {
+	Convey("Given an attribute running past the buffer", t, func() {
+		attrs := NewAttrDecoder(extAckAttr(1, []byte{1, 2, 3, 4})[:6])
+
+		Convey("Then decoding should stop with ErrAttrTruncated", func() {
+			So(attrs.Next(), ShouldBeFalse)
+			So(errors.Is(attrs.Err(), ErrAttrTruncated), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given an attribute shorter than its header", t, func() {
+		buf := extAckAttr(1, nil)
+		NativeEndian().PutUint16(buf, 2)
+		attrs := NewAttrDecoder(buf)
+
+		Convey("Then decoding should stop with ErrAttrInvalidLength", func() {
+			So(attrs.Next(), ShouldBeFalse)
+			So(errors.Is(attrs.Err(), ErrAttrInvalidLength), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given an attribute too short for the value read", t, func() {
+		attrs := NewAttrDecoder(extAckAttr(1, []byte{1, 2}))
+
+		Convey("Then the value should be zero and the error recorded", func() {
+			So(attrs.Next(), ShouldBeTrue)
+			So(attrs.Uint32(), ShouldEqual, 0)
+			So(errors.Is(attrs.Err(), ErrAttrValueTooShort), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given an attribute too short for the struct read", t, func() {
+		attrs := NewAttrDecoder(extAckAttr(1, []byte{1, 2, 3, 4}))
+
+		Convey("Then the struct should be nil and the error recorded", func() {
+			So(attrs.Next(), ShouldBeTrue)
+			So(attrs.Struct(4), ShouldResemble, []byte{1, 2, 3, 4})
+			So(attrs.Struct(8), ShouldBeNil)
+			So(errors.Is(attrs.Err(), ErrAttrValueTooShort), ShouldBeTrue)
+			So(attrs.Next(), ShouldBeFalse)
+		})
+	})
+}
+
+// replyMessage builds a netlink message with a 4 bytes payload
+func replyMessage(msgType uint16, flags NlmFlags, seq uint32, value uint32) []byte {
+	buf := make([]byte, syscall.SizeofNlMsghdr+4)
+	SerializeNlMsgHdrBuf(&syscall.NlMsghdr{Len: uint32(len(buf)), Type: msgType, Flags: uint16(flags), Seq: seq}, buf)
+	NativeEndian().PutUint32(buf[syscall.SizeofNlMsghdr:], value)
+	return buf
+}
+
+func TestMessageIterator(t *testing.T) {
+	Convey("Given a datagram with several messages", t, func() {
+		var buf []byte
+		buf = append(buf, replyMessage(uint16(NfqnlMsgPacket), 0, 1, 10)...)
+		buf = append(buf, replyMessage(uint16(NfqnlMsgPacket), 0, 2, 20)...)
+		msgs := NewMessageIterator(buf)
+
+		Convey("Then I should get every message", func() {
+			So(msgs.Next(), ShouldBeTrue)
+			So(msgs.Header().Seq, ShouldEqual, 1)
+			So(NativeEndian().Uint32(msgs.Data()), ShouldEqual, 10)
+			So(msgs.Next(), ShouldBeTrue)
+			So(msgs.Header().Seq, ShouldEqual, 2)
+			So(NativeEndian().Uint32(msgs.Data()), ShouldEqual, 20)
+			So(msgs.Next(), ShouldBeFalse)
+			So(msgs.Err(), ShouldBeNil)
+		})
+	})
+
+	Convey("Given a datagram with a truncated message", t, func() {
+		buf := append(replyMessage(uint16(NfqnlMsgPacket), 0, 1, 10), replyMessage(uint16(NfqnlMsgPacket), 0, 2, 20)[:18]...)
+		msgs := NewMessageIterator(buf)
+
+		Convey("Then the walk should stop with ErrMsgTruncated", func() {
+			So(msgs.Next(), ShouldBeTrue)
+			So(msgs.Next(), ShouldBeFalse)
+			So(errors.Is(msgs.Err(), ErrMsgTruncated), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given an overrun message", t, func() {
+		msgs := NewMessageIterator(replyMessage(NlMsgOverrun, 0, 0, 0))
+
+		Convey("Then the message error should be ErrOverrun", func() {
+			So(msgs.Next(), ShouldBeTrue)
+			So(msgs.MessageErr(), ShouldEqual, ErrOverrun)
+		})
+	})
+}
+
+func TestReceiveReply(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a dump spread over several reads", t, func() {
+		mockSyscalls := syscallwrappers.NewMockSyscalls(ctrl)
+
+		reads := [][]byte{
+			append(replyMessage(uint16(NfnlConntrackTable), NlmFMulti, 9, 1), replyMessage(uint16(NfnlConntrackTable), NlmFMulti, 5, 1)...),
+			append(replyMessage(uint16(NfnlConntrackTable), NlmFMulti, 5, 2), replyMessage(uint16(NfnlConntrackTable), NlmFMulti, 5, 3)...),
+			replyMessage(NlMsgDone, NlmFMulti, 5, 0),
+		}
+		for _, r := range reads {
+			msg := r
+			mockSyscalls.EXPECT().Recvfrom(3, gomock.Any(), 0).Times(1).DoAndReturn(func(fd int, p []byte, flags int) (int, syscall.Sockaddr, error) {
+				return copy(p, msg), nil, nil
+			})
+		}
+
+		Convey("Then every message of the reply should be passed to the callback", func() {
+			var values []uint32
+			err := ReceiveReply(mockSyscalls, 3, make([]byte, 256), 5, 0, func(hdr *syscall.NlMsghdr, data []byte) error {
+				values = append(values, NativeEndian().Uint32(data))
+				return nil
+			})
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []uint32{1, 2, 3})
+		})
+	})
+
+	Convey("Given a dump interrupted by a change", t, func() {
+		mockSyscalls := syscallwrappers.NewMockSyscalls(ctrl)
+
+		msg := append(replyMessage(uint16(NfnlConntrackTable), NlmFMulti|NlmFDumpintr, 5, 1), replyMessage(NlMsgDone, NlmFMulti|NlmFDumpintr, 5, 0)...)
+		mockSyscalls.EXPECT().Recvfrom(3, gomock.Any(), 0).Times(1).DoAndReturn(func(fd int, p []byte, flags int) (int, syscall.Sockaddr, error) {
+			return copy(p, msg), nil, nil
+		})
+
+		Convey("Then I should get ErrDumpInterrupted after the whole dump was read", func() {
+			count := 0
+			err := ReceiveReply(mockSyscalls, 3, make([]byte, 256), 5, 0, func(hdr *syscall.NlMsghdr, data []byte) error {
+				count++
+				return nil
+			})
+			So(err, ShouldEqual, ErrDumpInterrupted)
+			So(count, ShouldEqual, 1)
+		})
+	})
+
+	Convey("Given the request is rejected", t, func() {
+		mockSyscalls := syscallwrappers.NewMockSyscalls(ctrl)
+
+		errno := -int32(syscall.EPERM)
+		msg := replyMessage(NlMsgError, 0, 5, 0)
+		NativeEndian().PutUint32(msg[syscall.SizeofNlMsghdr:], uint32(errno))
+		msg = append(msg, make([]byte, syscall.SizeofNlMsghdr)...)
+		NativeEndian().PutUint32(msg, uint32(len(msg)))
+		mockSyscalls.EXPECT().Recvfrom(3, gomock.Any(), 0).Times(1).DoAndReturn(func(fd int, p []byte, flags int) (int, syscall.Sockaddr, error) {
+			return copy(p, msg), nil, nil
+		})
+
+		Convey("Then I should get the netlink error", func() {
+			err := ReceiveReply(mockSyscalls, 3, make([]byte, 256), 5, 0, nil)
+			So(errors.Is(err, syscall.EPERM), ShouldBeTrue)
+		})
+	})
+}
+
+func TestNewSocket(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given I open a socket with options", t, func() {
+		mockSyscalls := syscallwrappers.NewMockSyscalls(ctrl)
+
+		mockSyscalls.EXPECT().Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_NONBLOCK, syscall.NETLINK_NETFILTER).Times(1).Return(5, nil)
+		mockSyscalls.EXPECT().Bind(5, gomock.Any()).Times(1).Return(nil)
+		mockSyscalls.EXPECT().Getsockname(5).Times(1).Return(&syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Pid: 100}, nil)
+		mockSyscalls.EXPECT().SetsockoptInt(5, SolNetlink, NetlinkExtAck, 1).Times(1)
+		mockSyscalls.EXPECT().SetsockoptInt(5, SolNetlink, NetlinkCapAck, 1).Times(1)
+		mockSyscalls.EXPECT().SetsockoptInt(5, SolNetlink, syscall.NETLINK_NO_ENOBUFS, 1).Times(1)
+		mockSyscalls.EXPECT().SetsockoptInt(5, syscall.SOL_SOCKET, syscall.SO_RCVBUFFORCE, 1<<20).Times(1).Return(syscall.EPERM)
+		mockSyscalls.EXPECT().SetsockoptInt(5, syscall.SOL_SOCKET, syscall.SO_RCVBUF, 1<<20).Times(1)
+		mockSyscalls.EXPECT().SetsockoptInt(5, SolNetlink, syscall.NETLINK_ADD_MEMBERSHIP, 2).Times(1)
+
+		sock, err := NewSocket(mockSyscalls,
+			WithNonBlocking(true),
+			WithNoENOBUFS(true),
+			WithRcvBufSize(1<<20, true),
+			WithMulticastGroups(2),
+			WithReadBuffer(make([]byte, 4096)),
+		)
+
+		Convey("Then the socket should be set up", func() {
+			So(err, ShouldBeNil)
+			So(sock.Fd(), ShouldEqual, 5)
+			So(sock.LocalAddress().Pid, ShouldEqual, 100)
+			So(sock.RcvBufSize(), ShouldEqual, 4096)
+		})
+
+		Convey("When I send a request", func() {
+			var sent []byte
+			mockSyscalls.EXPECT().Sendto(5, gomock.Any(), 0, gomock.Any()).Times(1).DoAndReturn(func(fd int, p []byte, flags int, to syscall.Sockaddr) error {
+				sent = append([]byte(nil), p...)
+				So(to.(*syscall.SockaddrNetlink).Pid, ShouldEqual, 0)
+				return nil
+			})
+			ack := append(replyMessage(NlMsgError, 0, 1, 0), make([]byte, syscall.SizeofNlMsghdr)...)
+			NativeEndian().PutUint32(ack, uint32(len(ack)))
+			NativeEndian().PutUint32(ack[12:], 100)
+			mockSyscalls.EXPECT().Recvfrom(5, gomock.Any(), 0).Times(1).DoAndReturn(func(fd int, p []byte, flags int) (int, syscall.Sockaddr, error) {
+				return copy(p, ack), nil, nil
+			})
+
+			err := sock.Query(NewNfnlRequest(nil, NfnlNFLog, NlmFRequest|NlmFAck, syscall.AF_UNSPEC, 0).Message())
+
+			Convey("Then it should carry the sequence number and port id and be acknowledged", func() {
+				So(err, ShouldBeNil)
+				So(NativeEndian().Uint32(sent[8:]), ShouldEqual, 1)
+				So(NativeEndian().Uint32(sent[12:]), ShouldEqual, 100)
+			})
+		})
+	})
+
+	Convey("Given I open a socket in a network namespace", t, func() {
+		mockSyscalls := syscallwrappers.NewMockSyscalls(ctrl)
+
+		gomock.InOrder(
+			mockSyscalls.EXPECT().Open(gomock.Any(), syscall.O_RDONLY|syscall.O_CLOEXEC, uint32(0)).Times(1).Return(7, nil),
+			mockSyscalls.EXPECT().Setns(9, syscall.CLONE_NEWNET).Times(1).Return(nil),
+			mockSyscalls.EXPECT().Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_NETFILTER).Times(1).Return(5, nil),
+			mockSyscalls.EXPECT().Setns(7, syscall.CLONE_NEWNET).Times(1).Return(nil),
+			mockSyscalls.EXPECT().Close(7).Times(1),
+		)
+		mockSyscalls.EXPECT().Bind(5, gomock.Any()).Times(1).Return(nil)
+		mockSyscalls.EXPECT().Getsockname(5).Times(1).Return(&syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Pid: 100}, nil)
+		mockSyscalls.EXPECT().SetsockoptInt(5, gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+
+		sock, err := NewSocket(mockSyscalls, WithNetNS(9))
+
+		Convey("Then the socket should be opened in the namespace", func() {
+			So(err, ShouldBeNil)
+			So(sock.Fd(), ShouldEqual, 5)
+		})
+	})
+}
+
+func TestENOBUFSHandler(t *testing.T) {
+	Convey("Given the default policy", t, func() {
+		h := &ENOBUFSHandler{}
+
+		Convey("Then ENOBUFS should be reported", func() {
+			So(h.Handle(syscall.ENOBUFS), ShouldBeFalse)
+			So(h.Count(), ShouldEqual, 0)
+		})
+	})
+
+	Convey("Given the count policy", t, func() {
+		h := &ENOBUFSHandler{Policy: ENOBUFSCount}
+
+		Convey("Then wrapped ENOBUFS should be counted and other errors reported", func() {
+			So(h.Handle(fmt.Errorf("Recvfrom returned error %w", syscall.ENOBUFS)), ShouldBeTrue)
+			So(h.Handle(syscall.EINTR), ShouldBeFalse)
+			So(h.Handle(nil), ShouldBeFalse)
+			So(h.Count(), ShouldEqual, 1)
+		})
+	})
+
+	Convey("Given the resync policy", t, func() {
+		var resyncs int
+		h := &ENOBUFSHandler{Policy: ENOBUFSResync, Resync: func() { resyncs++ }}
+
+		Convey("Then the resync callback should be called on every overflow", func() {
+			So(h.Handle(syscall.ENOBUFS), ShouldBeTrue)
+			So(h.Handle(syscall.ENOBUFS), ShouldBeTrue)
+			So(resyncs, ShouldEqual, 2)
+			So(h.Count(), ShouldEqual, 2)
+		})
+	})
+}
+
+// recvBatch returns a Recvmmsg stub which copies one datagram in each buffer of the batch
+func recvBatch(datagrams ...[]byte) func(int, []syscallwrappers.Mmsghdr, int) (int, error) {
+	return func(fd int, msgs []syscallwrappers.Mmsghdr, flags int) (int, error) {
+		for i, d := range datagrams {
+			iov := msgs[i].Hdr.Iov
+			msgs[i].Len = uint32(copy((*[1 << 30]byte)(unsafe.Pointer(iov.Base))[:iov.Len:iov.Len], d))
+		}
+		return len(datagrams), nil
+	}
+}
+
+func TestRecvBatch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a socket and a batch of 4 buffers", t, func() {
+		mockSyscalls := syscallwrappers.NewMockSyscalls(ctrl)
+		sock := &Socket{Syscalls: mockSyscalls, fd: 5}
+		batch := NewRecvBatch(4, 64)
+		So(batch.Cap(), ShouldEqual, 4)
+
+		Convey("When one call reads two datagrams", func() {
+			first := replyMessage(NlMsgNoop, 0, 1, 1)
+			second := replyMessage(NlMsgNoop, 0, 2, 2)
+			mockSyscalls.EXPECT().Recvmmsg(5, gomock.Any(), syscall.MSG_WAITFORONE).Times(1).DoAndReturn(recvBatch(first, second))
+
+			n, err := sock.RecvBatch(batch, syscall.MSG_WAITFORONE)
+
+			Convey("Then each datagram should be returned once", func() {
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, 2)
+				So(batch.Len(), ShouldEqual, 2)
+
+				d, ok := batch.Next()
+				So(ok, ShouldBeTrue)
+				So(d, ShouldResemble, first)
+				d, ok = batch.Next()
+				So(ok, ShouldBeTrue)
+				So(d, ShouldResemble, second)
+				_, ok = batch.Next()
+				So(ok, ShouldBeFalse)
+			})
+		})
+
+		Convey("When the call fails", func() {
+			mockSyscalls.EXPECT().Recvmmsg(5, gomock.Any(), 0).Times(1).Return(-1, syscall.EAGAIN)
+
+			n, err := sock.RecvBatch(batch, 0)
+
+			Convey("Then the batch should be empty", func() {
+				So(err, ShouldEqual, syscall.EAGAIN)
+				So(n, ShouldEqual, 0)
+				_, ok := batch.Next()
+				So(ok, ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestSendmsg(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a socket", t, func() {
+		mockSyscalls := syscallwrappers.NewMockSyscalls(ctrl)
+		sock := &Socket{Syscalls: mockSyscalls, fd: 5}
+
+		Convey("When I send a message made of two buffers", func() {
+			hdr, payload := make([]byte, 16), make([]byte, 8)
+			iovecs := []syscall.Iovec{{Base: &hdr[0]}, {Base: &payload[0]}}
+			iovecs[0].SetLen(len(hdr))
+			iovecs[1].SetLen(len(payload))
+			var sent *syscall.Msghdr
+			mockSyscalls.EXPECT().Syscall(uintptr(unix.SYS_SENDMSG), uintptr(5), gomock.Any(), uintptr(0)).Times(1).DoAndReturn(func(trap, a1, a2, a3 uintptr) (uintptr, uintptr, syscall.Errno) {
+				sent = *(**syscall.Msghdr)(unsafe.Pointer(&a2))
+				return 24, 0, 0
+			})
+
+			err := sock.Sendmsg(iovecs)
+
+			Convey("Then both buffers should be passed to sendmsg", func() {
+				So(err, ShouldBeNil)
+				So(sent.Iov, ShouldEqual, &iovecs[0])
+				So(sent.Iovlen, ShouldEqual, 2)
+			})
+		})
+
+		Convey("When I send no buffer", func() {
+			err := sock.Sendmsg(nil)
+
+			Convey("Then I should get an error without calling sendmsg", func() {
+				So(err, ShouldEqual, errNoIovecs)
+			})
+		})
+	})
+}
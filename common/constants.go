@@ -11,6 +11,16 @@ import (
 const (
 	nlMsgAlignTo = 4
 	nfaAlignTo   = 4
+	// nlaTypeMask strips NLA_F_NESTED and NLA_F_NET_BYTEORDER from the attribute type
+	nlaTypeMask = 0x3fff
+)
+
+// https://github.com/torvalds/linux/blob/master/include/uapi/linux/netlink.h -> attribute type flags
+const (
+	// NLA_F_NESTED -- the attribute carries nested attributes
+	NLA_F_NESTED = (1 << 15)
+	// NLA_F_NET_BYTEORDER -- the attribute payload is in network byte order
+	NLA_F_NET_BYTEORDER = (1 << 14)
 )
 const (
 	// ConntrackTable Conntrack table
@@ -27,6 +37,10 @@ const (
 	SOCKFAMILY = syscall.AF_NETLINK
 	//SolNetlink  costant for SOL_NETLINK
 	SolNetlink = 270 /* syscall.SOL_NETLINK not defined */
+	//NetlinkCapAck -- only echo the header of the request in NLMSG_ERROR messages
+	NetlinkCapAck = 10 /* syscall.NETLINK_CAP_ACK not defined */
+	//NetlinkExtAck -- request extended ACK TLVs in NLMSG_ERROR messages
+	NetlinkExtAck = 11 /* syscall.NETLINK_EXT_ACK not defined */
 
 	//NFQNL - Netfilter Queue Netink message types
 
@@ -60,6 +74,10 @@ const (
 	NlmFDumpintr NlmFlags = 0x10
 	/*NlmFDumpFiltered -- Dump was filtered as requested */
 	NlmFDumpFiltered NlmFlags = 0x20
+	/*NlmFCapped -- Request was capped in the error message */
+	NlmFCapped NlmFlags = 0x100
+	/*NlmFAckTlvs -- Extended ACK TLVs were included in the error message */
+	NlmFAckTlvs NlmFlags = 0x200
 
 	//NfnlBuffSize -- Buffer size of socket
 	NfnlBuffSize uint32 = (75 * 1024)
@@ -133,3 +151,50 @@ const (
 const (
 	NFULNL_MSG_CONFIG = 1
 )
+
+// https://github.com/torvalds/linux/blob/master/include/uapi/linux/netlink.h -> enum nlmsgerr_attrs
+const (
+	NLMSGERR_ATTR_UNUSED = iota
+	NLMSGERR_ATTR_MSG
+	NLMSGERR_ATTR_OFFS
+	NLMSGERR_ATTR_COOKIE
+	NLMSGERR_ATTR_POLICY
+	NLMSGERR_ATTR_MISS_TYPE
+	NLMSGERR_ATTR_MISS_NEST
+)
+
+// https://github.com/torvalds/linux/blob/master/include/uapi/linux/netlink.h -> enum netlink_policy_type_attr
+const (
+	NL_POLICY_TYPE_ATTR_UNSPEC = iota
+	NL_POLICY_TYPE_ATTR_TYPE
+	NL_POLICY_TYPE_ATTR_MIN_VALUE_S
+	NL_POLICY_TYPE_ATTR_MAX_VALUE_S
+	NL_POLICY_TYPE_ATTR_MIN_VALUE_U
+	NL_POLICY_TYPE_ATTR_MAX_VALUE_U
+	NL_POLICY_TYPE_ATTR_MIN_LENGTH
+	NL_POLICY_TYPE_ATTR_MAX_LENGTH
+	NL_POLICY_TYPE_ATTR_POLICY_IDX
+	NL_POLICY_TYPE_ATTR_POLICY_MAXTYPE
+	NL_POLICY_TYPE_ATTR_BITFIELD32_MASK
+	NL_POLICY_TYPE_ATTR_PAD
+	NL_POLICY_TYPE_ATTR_MASK
+)
+
+// https://github.com/torvalds/linux/blob/master/include/uapi/linux/netlink.h -> enum netlink_attribute_type
+var nlAttrTypeNames = map[uint32]string{
+	1:  "flag",
+	2:  "u8",
+	3:  "u16",
+	4:  "u32",
+	5:  "u64",
+	6:  "s8",
+	7:  "s16",
+	8:  "s32",
+	9:  "s64",
+	10: "binary",
+	11: "string",
+	12: "nul-string",
+	13: "nested",
+	14: "nested-array",
+	15: "bitfield32",
+}
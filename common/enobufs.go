@@ -0,0 +1,60 @@
+// +build linux !darwin
+
+package common
+
+import (
+	"errors"
+	"sync/atomic"
+	"syscall"
+)
+
+//ENOBUFSPolicy -- What a reader does when a read fails with ENOBUFS: the socket receive buffer
+//overflowed and the kernel dropped messages. The kernel only reports it if NETLINK_NO_ENOBUFS is off.
+type ENOBUFSPolicy int
+
+const (
+	//ENOBUFSReport -- Report the error like any other read error, the default
+	ENOBUFSReport ENOBUFSPolicy = iota
+	//ENOBUFSIgnore -- Keep reading silently
+	ENOBUFSIgnore
+	//ENOBUFSCount -- Count the overflow and keep reading
+	ENOBUFSCount
+	//ENOBUFSResync -- Count the overflow, call the resync callback and keep reading
+	ENOBUFSResync
+)
+
+//ENOBUFSHandler -- Applies an ENOBUFSPolicy to read errors
+//Policy -- the policy applied
+//Resync -- called on ENOBUFS with the ENOBUFSResync policy, to rebuild the state the lost messages carried
+type ENOBUFSHandler struct {
+	Policy ENOBUFSPolicy
+	Resync func()
+	count  uint64
+}
+
+//Handle -- Returns true if err is ENOBUFS and the policy says to keep reading without reporting it
+func (h *ENOBUFSHandler) Handle(err error) bool {
+	if !errors.Is(err, syscall.ENOBUFS) {
+		return false
+	}
+
+	switch h.Policy {
+	case ENOBUFSIgnore:
+		return true
+	case ENOBUFSCount:
+		atomic.AddUint64(&h.count, 1)
+		return true
+	case ENOBUFSResync:
+		atomic.AddUint64(&h.count, 1)
+		if h.Resync != nil {
+			h.Resync()
+		}
+		return true
+	}
+	return false
+}
+
+//Count -- Number of ENOBUFS counted by the ENOBUFSCount and ENOBUFSResync policies
+func (h *ENOBUFSHandler) Count() uint64 {
+	return atomic.LoadUint64(&h.count)
+}
@@ -0,0 +1,204 @@
+// +build linux !darwin
+
+package common
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+
+	"go.aporeto.io/netlink-go/common/syscallwrappers"
+)
+
+//NetlinkError -- Error returned by the kernel in a NLMSG_ERROR message
+//Errno -- the error, the kernel sends it negated
+//Header -- header of the request which failed
+//Msg -- extended ACK message if the kernel sent one
+//Offset -- offset in the request of the attribute which caused the error, 0 if unknown
+//Policy -- policy the rejected attribute did not comply with, nil if not sent
+//MissingType -- type of a required attribute which was missing, 0 if not sent
+//MissingNest -- offset of the nest the missing attribute was expected in, 0 if not sent
+type NetlinkError struct {
+	Errno       syscall.Errno
+	Header      syscall.NlMsghdr
+	Msg         string
+	Offset      uint32
+	Policy      *NetlinkErrorPolicy
+	MissingType uint32
+	MissingNest uint32
+}
+
+//NetlinkErrorPolicy -- Policy of the attribute rejected by the kernel
+//Type -- attribute type (u8, u32, nested ...)
+//Min, Max -- range of values allowed for integer attributes
+//MinLength, MaxLength -- range of lengths allowed for binary and string attributes
+type NetlinkErrorPolicy struct {
+	Type      string
+	Min       int64
+	Max       int64
+	MinLength uint32
+	MaxLength uint32
+}
+
+//String -- Returns the policy in human readable form
+func (p *NetlinkErrorPolicy) String() string {
+	parts := []string{"type " + p.Type}
+	if p.Min != 0 || p.Max != 0 {
+		parts = append(parts, fmt.Sprintf("range [%d, %d]", p.Min, p.Max))
+	}
+	if p.MinLength != 0 || p.MaxLength != 0 {
+		parts = append(parts, fmt.Sprintf("length [%d, %d]", p.MinLength, p.MaxLength))
+	}
+	return strings.Join(parts, ", ")
+}
+
+//EnableExtAck -- Ask the kernel to add extended ACK TLVs to errors sent on the socket
+//and to only echo the header of the failed request.
+//Kernels older than 4.12 do not support these options, errors are ignored
+func EnableExtAck(s syscallwrappers.Syscalls, fd int) {
+	s.SetsockoptInt(fd, SolNetlink, NetlinkExtAck, 1) // nolint
+	s.SetsockoptInt(fd, SolNetlink, NetlinkCapAck, 1) // nolint
+}
+
+//Error -- Implements the error interface
+func (e *NetlinkError) Error() string {
+	s := fmt.Sprintf("Netlink returned error %d: %v", int(e.Errno), e.Errno)
+	if e.Msg != "" {
+		s += ": " + e.Msg
+	}
+	if e.Offset != 0 {
+		s += fmt.Sprintf(" (attribute at offset %d)", e.Offset)
+	}
+	if e.Policy != nil {
+		s += fmt.Sprintf(" (policy: %s)", e.Policy)
+	}
+	if e.MissingType != 0 {
+		s += fmt.Sprintf(" (missing attribute type %d", e.MissingType)
+		if e.MissingNest != 0 {
+			s += fmt.Sprintf(" in nest at offset %d", e.MissingNest)
+		}
+		s += ")"
+	}
+	return s
+}
+
+//Is -- Allows errors.Is to match the error against a syscall.Errno
+func (e *NetlinkError) Is(target error) bool {
+	errno, ok := target.(syscall.Errno)
+	return ok && errno == e.Errno
+}
+
+//Unwrap -- Returns the underlying syscall.Errno
+func (e *NetlinkError) Unwrap() error {
+	return e.Errno
+}
+
+//SendError -- A request could not be sent on the socket, the kernel never saw it so it
+//can be sent again. Errors reading the reply are not SendErrors
+type SendError struct {
+	Err error
+}
+
+//Error -- Implements the error interface
+func (e *SendError) Error() string {
+	return fmt.Sprintf("Sendto returned error %v", e.Err)
+}
+
+//Unwrap -- Returns the error returned by Sendto
+func (e *SendError) Unwrap() error {
+	return e.Err
+}
+
+//NewNetlinkError -- Parse the payload of a NLMSG_ERROR message
+//hdr -- header of the NLMSG_ERROR message
+//payload -- the message following the header
+//Returns nil if the message is an ACK
+func NewNetlinkError(hdr *syscall.NlMsghdr, payload []byte) error {
+	if len(payload) < 4 {
+		return fmt.Errorf("Netlink error message truncated")
+	}
+
+	errno := -int32(NativeEndian().Uint32(payload))
+	if errno == 0 {
+		return nil
+	}
+
+	nlErr := &NetlinkError{Errno: syscall.Errno(errno)}
+	payload = payload[4:]
+	if len(payload) < syscall.SizeofNlMsghdr {
+		return nlErr
+	}
+
+	orig, _, _ := NetlinkMessageToStruct(payload)
+	nlErr.Header = *orig
+
+	if hdr == nil || hdr.Flags&uint16(NlmFAckTlvs) == 0 {
+		return nlErr
+	}
+
+	// The TLVs follow the original request, which is only its header when capped
+	tlvOffset := NlMsgAlign(orig.Len)
+	if hdr.Flags&uint16(NlmFCapped) != 0 {
+		tlvOffset = syscall.SizeofNlMsghdr
+	}
+	if int(tlvOffset) > len(payload) {
+		return nlErr
+	}
+
+	parseExtAck(nlErr, payload[tlvOffset:])
+	return nlErr
+}
+
+//parseExtAck -- Fill the error with the extended ACK TLVs
+func parseExtAck(nlErr *NetlinkError, buf []byte) {
+	d := NewAttrDecoder(buf)
+	for d.Next() {
+		switch d.Type() {
+		case NLMSGERR_ATTR_MSG:
+			nlErr.Msg = d.String()
+		case NLMSGERR_ATTR_OFFS:
+			nlErr.Offset = d.NativeUint32()
+		case NLMSGERR_ATTR_MISS_TYPE:
+			nlErr.MissingType = d.NativeUint32()
+		case NLMSGERR_ATTR_MISS_NEST:
+			nlErr.MissingNest = d.NativeUint32()
+		case NLMSGERR_ATTR_POLICY:
+			nlErr.Policy = parsePolicy(d.Nested())
+		}
+	}
+}
+
+//parsePolicy -- Parse the nested NLMSGERR_ATTR_POLICY attribute
+func parsePolicy(d AttrDecoder) *NetlinkErrorPolicy {
+	policy := &NetlinkErrorPolicy{}
+	for d.Next() {
+		switch d.Type() {
+		case NL_POLICY_TYPE_ATTR_TYPE:
+			t := d.NativeUint32()
+			if name, ok := nlAttrTypeNames[t]; ok {
+				policy.Type = name
+			} else {
+				policy.Type = fmt.Sprintf("%d", t)
+			}
+		case NL_POLICY_TYPE_ATTR_MIN_VALUE_S, NL_POLICY_TYPE_ATTR_MIN_VALUE_U:
+			policy.Min = int64(d.NativeUint64())
+		case NL_POLICY_TYPE_ATTR_MAX_VALUE_S, NL_POLICY_TYPE_ATTR_MAX_VALUE_U:
+			policy.Max = int64(d.NativeUint64())
+		case NL_POLICY_TYPE_ATTR_MIN_LENGTH:
+			policy.MinLength = d.NativeUint32()
+		case NL_POLICY_TYPE_ATTR_MAX_LENGTH:
+			policy.MaxLength = d.NativeUint32()
+		}
+	}
+	return policy
+}
+
+//nullTerminated -- Return the string up to the first null byte
+func nullTerminated(data []byte) string {
+	for i, b := range data {
+		if b == 0 {
+			return string(data[:i])
+		}
+	}
+	return string(data)
+}
@@ -236,6 +236,7 @@ func (r *NfValue8) Length() uint8 {
 }
 
 //NetlinkMessageToStruct -- Convert netlink message byte slice to struct and payload
+//Only the first message in buf is converted, use MessageIterator to walk a whole datagram
 func NetlinkMessageToStruct(buf []byte) (*syscall.NlMsghdr, []byte, error) {
 	if len(buf) <= 15 {
 		return nil, []byte{}, fmt.Errorf("Buffer is empty")
@@ -253,46 +254,43 @@ func NetlinkMessageToStruct(buf []byte) (*syscall.NlMsghdr, []byte, error) {
 //NetlinkMessageToNfGenStruct -- Convert netlink byte slice to nfqgen msg structure
 func NetlinkMessageToNfGenStruct(buf []byte) (*NfqGenMsg, []byte, error) {
 	hdr := &NfqGenMsg{}
+	data, err := NetlinkMessageToNfGenStructBuf(buf, hdr)
+	if err != nil {
+		return nil, nil, err
+	}
+	return hdr, data, nil
+}
+
+//NetlinkMessageToNfGenStructBuf -- Parse the nfgen header in hdr and return the data following it
+func NetlinkMessageToNfGenStructBuf(buf []byte, hdr *NfqGenMsg) ([]byte, error) {
+	if len(buf) < int(SizeofNfGenMsg) {
+		return nil, fmt.Errorf("NfGen header truncated")
+	}
 	hdr.nfgenFamily = buf[0]
 	hdr.version = buf[1]
 	hdr.resID = binary.BigEndian.Uint16(buf[2:])
-	return hdr, buf[4:], nil
+	return buf[4:], nil
 }
 
 //NetlinkMessageToNfAttrStruct -- Convert byte slice representing nfattr to nfattr struct slice
+//Only the attribute types present as keys in hdr are decoded, their data points into buf.
+//Entries for attributes absent from buf are reset.
 func NetlinkMessageToNfAttrStruct(buf []byte, hdr map[int]*NfAttrResponsePayload) (map[int]*NfAttrResponsePayload, []byte, error) {
-	//hdr := make([]*NfAttrResponsePayload, nfqaMax)
-	i := 0
-	for i < len(buf) {
-		if (i + 4) > len(buf) {
-			i = i + 4
-			break
-		}
-		nfaLen32 := uint32(NativeEndian().Uint16(buf[i:]))
-		nfaType := NativeEndian().Uint16(buf[i+2:])
-		i = i + 4
-
-		if i+int(nfaLen32)-4 <= len(buf) {
-			if nfaType < uint16(nfqaMax) {
-				if _, ok := hdr[int(nfaType)]; !ok {
-					i = i + int(nfaLen32) - 4
-					i = int(NfaAlign32(uint32(i)))
-					continue // Skip unused types
-				}
-
-				hdr[int(nfaType)].data = buf[i : i+int(nfaLen32)-4]
-			}
-		} else {
-			return hdr, nil, fmt.Errorf("Bad Attr")
-		}
-		i = i + int(nfaLen32) - 4
-		i = int(NfaAlign32(uint32(i)))
+	for _, attr := range hdr {
+		attr.data = nil
 	}
 
-	if i >= len(buf) {
-		return hdr, nil, nil
+	d := NewAttrDecoder(buf)
+	for d.Next() {
+		if attr, ok := hdr[int(d.Type())]; ok {
+			attr.data = d.Bytes()
+		}
 	}
-	return hdr, buf[i:], nil
+	if err := d.Err(); err != nil {
+		return hdr, nil, fmt.Errorf("Bad Attr: %w", err)
+	}
+
+	return hdr, nil, nil
 }
 
 //NetlinkErrMessagetoStruct -- parse byte slice and return syscall.NlMsgerr
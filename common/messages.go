@@ -0,0 +1,155 @@
+// +build linux !darwin
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+
+	"go.aporeto.io/netlink-go/common/syscallwrappers"
+)
+
+var (
+	// ErrMsgTruncated is returned when a netlink message header or its payload runs past the datagram
+	ErrMsgTruncated = errors.New("netlink message truncated")
+	// ErrOverrun is returned when the kernel reports NLMSG_OVERRUN, messages were lost
+	ErrOverrun = errors.New("netlink message overrun, data lost")
+	// ErrDumpInterrupted is returned when a dump was modified while it was read (NLM_F_DUMP_INTR)
+	// The messages received are valid but the dump may be inconsistent, it should be retried
+	ErrDumpInterrupted = errors.New("netlink dump interrupted, results may be inconsistent")
+)
+
+//MessageIterator -- Walks every netlink message in a datagram without copying them
+//The walk stops at the first malformed message, reported by Err. Errors carried by a
+//message (NLMSG_ERROR, NLMSG_OVERRUN) are reported by MessageErr while the walk goes on.
+type MessageIterator struct {
+	buf    []byte
+	offset int
+	hdr    syscall.NlMsghdr
+	data   []byte
+	err    error
+}
+
+//NewMessageIterator -- Create an iterator over the messages in buf
+func NewMessageIterator(buf []byte) MessageIterator {
+	return MessageIterator{buf: buf}
+}
+
+//Next -- Advance to the next message. Returns false at the end of the datagram or on error
+func (it *MessageIterator) Next() bool {
+	if it.err != nil || it.offset >= len(it.buf) {
+		return false
+	}
+
+	rest := it.buf[it.offset:]
+	if len(rest) < syscall.SizeofNlMsghdr {
+		it.err = fmt.Errorf("%w: %d bytes left at offset %d", ErrMsgTruncated, len(rest), it.offset)
+		return false
+	}
+
+	msgLen := int(NativeEndian().Uint32(rest))
+	if msgLen < syscall.SizeofNlMsghdr || msgLen > len(rest) {
+		it.err = fmt.Errorf("%w: length %d at offset %d, %d bytes left", ErrMsgTruncated, msgLen, it.offset, len(rest))
+		return false
+	}
+
+	it.hdr.Len = uint32(msgLen)
+	it.hdr.Type = NativeEndian().Uint16(rest[4:])
+	it.hdr.Flags = NativeEndian().Uint16(rest[6:])
+	it.hdr.Seq = NativeEndian().Uint32(rest[8:])
+	it.hdr.Pid = NativeEndian().Uint32(rest[12:])
+	it.data = rest[syscall.SizeofNlMsghdr:msgLen]
+	it.offset += int(NlMsgAlign(uint32(msgLen)))
+	return true
+}
+
+//Err -- Returns the error which stopped the walk, nil if the datagram was well formed
+func (it *MessageIterator) Err() error {
+	return it.err
+}
+
+//Header -- Header of the current message
+func (it *MessageIterator) Header() *syscall.NlMsghdr {
+	return &it.hdr
+}
+
+//Data -- Payload of the current message, valid as long as the datagram buffer is
+func (it *MessageIterator) Data() []byte {
+	return it.data
+}
+
+//MessageErr -- Error carried by the current message
+//NLMSG_ERROR -- a *NetlinkError, nil for an ACK
+//NLMSG_OVERRUN -- ErrOverrun
+func (it *MessageIterator) MessageErr() error {
+	switch it.hdr.Type {
+	case NlMsgError:
+		return NewNetlinkError(&it.hdr, it.data)
+	case NlMsgOverrun:
+		return ErrOverrun
+	}
+	return nil
+}
+
+//DumpInterrupted -- True if the current message has the NLM_F_DUMP_INTR flag
+func (it *MessageIterator) DumpInterrupted() bool {
+	return NlmFlags(it.hdr.Flags)&NlmFDumpintr != 0
+}
+
+//Last -- True if the current message ends the reply to a request:
+//NLMSG_DONE, NLMSG_ERROR or any message which is not part of a multipart reply
+func (it *MessageIterator) Last() bool {
+	switch it.hdr.Type {
+	case NlMsgDone, NlMsgError:
+		return true
+	}
+	return NlmFlags(it.hdr.Flags)&NlmFMulti == 0
+}
+
+//ReceiveReply -- Read the reply to a request from fd, following a multipart reply across reads
+//seq, pid -- only messages with this sequence number and port id are part of the reply,
+//0 matches any. Other messages are discarded.
+//fn -- called for each data message of the reply, may be nil. Returning an error stops the read.
+//Returns the error of a NLMSG_ERROR or NLMSG_OVERRUN message, ErrDumpInterrupted once the whole
+//dump was read if one of its messages was flagged NLM_F_DUMP_INTR, or the socket error.
+func ReceiveReply(s syscallwrappers.Syscalls, fd int, buf []byte, seq uint32, pid uint32, fn func(hdr *syscall.NlMsghdr, data []byte) error) error {
+	var interrupted bool
+
+	for {
+		n, _, err := s.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return fmt.Errorf("Recvfrom returned error %w", err)
+		}
+
+		msgs := NewMessageIterator(buf[:n])
+		for msgs.Next() {
+			hdr := msgs.Header()
+			if (seq != 0 && hdr.Seq != seq) || (pid != 0 && hdr.Pid != pid) {
+				continue
+			}
+			if msgs.DumpInterrupted() {
+				interrupted = true
+			}
+			if err := msgs.MessageErr(); err != nil {
+				return err
+			}
+
+			if hdr.Type != NlMsgNoop && hdr.Type != NlMsgDone && hdr.Type != NlMsgError && fn != nil {
+				if err := fn(hdr, msgs.Data()); err != nil {
+					return err
+				}
+			}
+
+			if msgs.Last() {
+				if interrupted {
+					return ErrDumpInterrupted
+				}
+				return nil
+			}
+		}
+		if err := msgs.Err(); err != nil {
+			return err
+		}
+	}
+}
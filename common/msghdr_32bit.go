@@ -0,0 +1,16 @@
+// +build linux
+// +build 386 arm mips mipsle
+
+package common
+
+import "syscall"
+
+//msghdr -- syscall.Msghdr whose iovec count can be set on every architecture
+type msghdr struct {
+	syscall.Msghdr
+}
+
+//SetIovlen -- Set the number of iovecs, a uint32 on 32 bit architectures
+func (h *msghdr) SetIovlen(length int) {
+	h.Iovlen = uint32(length)
+}
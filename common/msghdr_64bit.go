@@ -0,0 +1,16 @@
+// +build linux
+// +build amd64 arm64 mips64 mips64le ppc64 ppc64le riscv64 s390x
+
+package common
+
+import "syscall"
+
+//msghdr -- syscall.Msghdr whose iovec count can be set on every architecture
+type msghdr struct {
+	syscall.Msghdr
+}
+
+//SetIovlen -- Set the number of iovecs, a uint64 on 64 bit architectures
+func (h *msghdr) SetIovlen(length int) {
+	h.Iovlen = uint64(length)
+}
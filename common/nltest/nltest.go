@@ -0,0 +1,82 @@
+// +build linux !darwin
+
+//Package nltest holds the netlink messages and mock socket expectations shared by the
+//tests of the nfqueue, nflog and conntrack packages
+package nltest
+
+import (
+	"syscall"
+
+	"github.com/golang/mock/gomock"
+	"go.aporeto.io/netlink-go/common"
+	"go.aporeto.io/netlink-go/common/syscallwrappers"
+)
+
+//Sockopt -- Integer socket option expected to be set once on a mocked socket
+type Sockopt struct {
+	Level int
+	Opt   int
+	Value int
+}
+
+//NoENOBUFS -- Sockopt disabling ENOBUFS errors, set by the nfqueue and nflog sockets
+var NoENOBUFS = Sockopt{Level: common.SolNetlink, Opt: syscall.NETLINK_NO_ENOBUFS, Value: 1}
+
+//RcvBufForce -- Sockopt forcing the size of the receive buffer
+func RcvBufForce(size int) Sockopt {
+	return Sockopt{Level: syscall.SOL_SOCKET, Opt: syscall.SO_RCVBUFFORCE, Value: size}
+}
+
+//SndBufForce -- Sockopt forcing the size of the send buffer
+func SndBufForce(size int) Sockopt {
+	return Sockopt{Level: syscall.SOL_SOCKET, Opt: syscall.SO_SNDBUFFORCE, Value: size}
+}
+
+//ExpectSocket -- Expect fd to be opened once as a netfilter socket, bound with port id pid
+//and extended ACKs enabled, then each of sockopts to be set once
+func ExpectSocket(m *syscallwrappers.MockSyscalls, fd int, pid uint32, sockopts ...Sockopt) {
+	m.EXPECT().Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_NETFILTER).Times(1).Return(fd, nil)
+	m.EXPECT().Bind(fd, gomock.Any()).Times(1).Return(nil)
+	m.EXPECT().Getsockname(fd).Times(1).Return(&syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Pid: pid}, nil)
+	m.EXPECT().SetsockoptInt(fd, common.SolNetlink, common.NetlinkExtAck, 1).Times(1)
+	m.EXPECT().SetsockoptInt(fd, common.SolNetlink, common.NetlinkCapAck, 1).Times(1)
+	for _, opt := range sockopts {
+		m.EXPECT().SetsockoptInt(fd, opt.Level, opt.Opt, opt.Value).Times(1)
+	}
+}
+
+//AckMessage -- NLMSG_ERROR reply to the request seq sent from port id pid
+//errno -- error returned by the kernel, negated as the kernel sends it, 0 for an ACK
+func AckMessage(seq, pid uint32, errno int32) []byte {
+	buf := make([]byte, syscall.SizeofNlMsghdr+4+syscall.SizeofNlMsghdr)
+	common.SerializeNlMsgHdrBuf(&syscall.NlMsghdr{
+		Len:  uint32(len(buf)),
+		Type: syscall.NLMSG_ERROR,
+		Seq:  seq,
+		Pid:  pid,
+	}, buf)
+	common.NativeEndian().PutUint32(buf[16:], uint32(errno))
+	return buf
+}
+
+//RecvMessage -- Recvfrom stub copying msg in the buffer passed
+func RecvMessage(msg []byte) func(int, []byte, int) (int, syscall.Sockaddr, error) {
+	return func(fd int, p []byte, flags int) (int, syscall.Sockaddr, error) {
+		return copy(p, msg), nil, nil
+	}
+}
+
+//lastAck -- ACK of the last request sent on a socket expecting queries. It is shared by the
+//expectations of every test run since goconvey registers them again for each leaf
+var lastAck []byte
+
+//ExpectQueries -- Acknowledge every request sent on fd, the ACK is read in buf
+func ExpectQueries(m *syscallwrappers.MockSyscalls, fd int, buf []byte) {
+	m.EXPECT().Sendto(fd, gomock.Any(), 0, gomock.Any()).AnyTimes().DoAndReturn(func(fd int, p []byte, flags int, to syscall.Sockaddr) error {
+		lastAck = AckMessage(common.NativeEndian().Uint32(p[8:]), common.NativeEndian().Uint32(p[12:]), 0)
+		return nil
+	})
+	m.EXPECT().Recvfrom(fd, buf, 0).AnyTimes().DoAndReturn(func(fd int, p []byte, flags int) (int, syscall.Sockaddr, error) {
+		return copy(p, lastAck), nil, nil
+	})
+}
@@ -0,0 +1,99 @@
+package packet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+const (
+	// ipv4FragPos is the location of the IP flags and fragment offset
+	ipv4FragPos = 6
+
+	// ipv4FragOffsetMask is a mask for the fragment offset
+	ipv4FragOffsetMask = 0x1fff
+)
+
+var (
+	// ErrNotIP is returned by DecodeIP when the buffer is empty or is not an IPv4 or IPv6 packet
+	ErrNotIP = errors.New("not an IP packet")
+	// ErrIPTruncated is returned by DecodeIP when the buffer is shorter than the IP header
+	ErrIPTruncated = errors.New("IP packet truncated")
+	// ErrIPMalformed is returned by DecodeIP when the IP header is invalid
+	ErrIPMalformed = errors.New("IP packet malformed")
+)
+
+// IPHeaders holds the fields of the IP and transport headers decoded by DecodeIP.
+// The ports are only set for the first fragment of TCP and UDP packets and the
+// TCP flags when they were in the buffer, HasTCPFlags tells if they were.
+type IPHeaders struct {
+	Version     uint8
+	Length      uint16
+	ID          uint16
+	Protocol    uint8
+	SrcIP       net.IP
+	DstIP       net.IP
+	SrcPort     uint16
+	DstPort     uint16
+	TCPFlags    uint8
+	HasTCPFlags bool
+}
+
+// DecodeIP decodes the IP header of the packet and the ports and flags of its
+// transport header. The packet can be cut after the IP header, the transport
+// fields are only set if they were copied. The addresses share the buffer.
+func DecodeIP(buf []byte) (*IPHeaders, error) {
+	if len(buf) == 0 {
+		return nil, ErrNotIP
+	}
+
+	h := &IPHeaders{}
+	var transport []byte
+	switch buf[0] >> 4 {
+	case 4:
+		if len(buf) < minIPv4HdrSize {
+			return nil, fmt.Errorf("%w: %d bytes of IPv4 header", ErrIPTruncated, len(buf))
+		}
+		hdrLen := int(buf[ipv4HdrLenPos]&ipv4HdrLenMask) * 4
+		if hdrLen < minIPv4HdrSize {
+			return nil, fmt.Errorf("%w: IPv4 header length %d", ErrIPMalformed, hdrLen)
+		}
+		if len(buf) < hdrLen {
+			return nil, fmt.Errorf("%w: %d bytes of IPv4 header with options, want %d", ErrIPTruncated, len(buf), hdrLen)
+		}
+		h.Version = 4
+		h.Length = binary.BigEndian.Uint16(buf[ipv4LengthPos:])
+		h.ID = binary.BigEndian.Uint16(buf[ipv4IDPos:])
+		h.Protocol = buf[ipv4ProtoPos]
+		h.SrcIP = net.IP(buf[ipv4SourceAddrPos : ipv4SourceAddrPos+net.IPv4len])
+		h.DstIP = net.IP(buf[ipv4DestAddrPos : ipv4DestAddrPos+net.IPv4len])
+		// Only the first fragment has the transport header
+		if binary.BigEndian.Uint16(buf[ipv4FragPos:])&ipv4FragOffsetMask == 0 {
+			transport = buf[hdrLen:]
+		}
+	case 6:
+		if len(buf) < ipv6HeaderLen {
+			return nil, fmt.Errorf("%w: %d bytes of IPv6 header", ErrIPTruncated, len(buf))
+		}
+		h.Version = 6
+		h.Length = ipv6HeaderLen + binary.BigEndian.Uint16(buf[ipv6PayloadLenPos:])
+		h.Protocol = buf[ipv6ProtoPos]
+		h.SrcIP = net.IP(buf[ipv6SourceAddrPos : ipv6SourceAddrPos+net.IPv6len])
+		h.DstIP = net.IP(buf[ipv6DestAddrPos : ipv6DestAddrPos+net.IPv6len])
+		transport = buf[ipv6HeaderLen:]
+	default:
+		return nil, ErrNotIP
+	}
+
+	if (h.Protocol == IPProtocolTCP || h.Protocol == IPProtocolUDP) && len(transport) >= tcpDestPortPos+2 {
+		h.SrcPort = binary.BigEndian.Uint16(transport[tcpSourcePortPos:])
+		h.DstPort = binary.BigEndian.Uint16(transport[tcpDestPortPos:])
+	}
+	if h.Protocol == IPProtocolTCP && len(transport) > tcpFlagsOffsetPos {
+		h.TCPFlags = transport[tcpFlagsOffsetPos]
+		h.HasTCPFlags = true
+	}
+
+	return h, nil
+}
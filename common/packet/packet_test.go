@@ -5,6 +5,7 @@ package packet
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -554,3 +555,38 @@ func TestUDPTokenAttach(t *testing.T) {
 	assert.Equal(t, string(pkt.ReadUDPToken()), "helloworld", "token should match helloworld")
 
 }
+
+func TestDecodeIP(t *testing.T) {
+	h, err := DecodeIP(testPackets[synGoodTCPChecksum])
+	assert.Nil(t, err, "SYN packet should be decoded")
+	assert.Equal(t, uint8(4), h.Version, "version should be 4")
+	assert.Equal(t, uint8(IPProtocolTCP), h.Protocol, "protocol should be TCP")
+	assert.Equal(t, loopbackAddress, h.SrcIP.String(), "src addr did not match")
+	assert.Equal(t, loopbackAddress, h.DstIP.String(), "dst addr did not match")
+	assert.Equal(t, uint16(99), h.DstPort, "dst port should be 99")
+	assert.True(t, h.HasTCPFlags, "TCP flags should be read")
+	assert.Equal(t, uint8(TCPSynMask), h.TCPFlags, "TCP flags should be SYN")
+
+	bytes, _ := hex.DecodeString(ipv6UDPPacket)
+	h, err = DecodeIP(bytes)
+	assert.Nil(t, err, "IPv6 packet should be decoded")
+	assert.Equal(t, uint8(6), h.Version, "version should be 6")
+	assert.Equal(t, uint8(IPProtocolUDP), h.Protocol, "protocol should be UDP")
+	assert.Equal(t, "2001:470:e5bf:1096:2:99:c1:10", h.SrcIP.String(), "src addr did not match")
+	assert.False(t, h.HasTCPFlags, "UDP packet should have no TCP flags")
+
+	h, err = DecodeIP(testPackets[synGoodTCPChecksum][:22])
+	assert.Nil(t, err, "packet cut after the IP header should be decoded")
+	assert.Equal(t, uint16(0), h.DstPort, "ports should not be read from a cut header")
+
+	_, err = DecodeIP(nil)
+	assert.True(t, errors.Is(err, ErrNotIP), "empty buffer is not IP")
+	_, err = DecodeIP([]byte{0x00, 0x01})
+	assert.True(t, errors.Is(err, ErrNotIP), "version 0 is not IP")
+	_, err = DecodeIP(testPackets[synGoodTCPChecksum][:10])
+	assert.True(t, errors.Is(err, ErrIPTruncated), "short IPv4 header is truncated")
+	_, err = DecodeIP(append([]byte{0x43}, testPackets[synGoodTCPChecksum][1:]...))
+	assert.True(t, errors.Is(err, ErrIPMalformed), "IHL below 5 is malformed")
+	_, err = DecodeIP(bytes[:30])
+	assert.True(t, errors.Is(err, ErrIPTruncated), "short IPv6 header is truncated")
+}
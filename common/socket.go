@@ -0,0 +1,326 @@
+// +build linux !darwin
+
+package common
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"go.aporeto.io/netlink-go/common/syscallwrappers"
+)
+
+//kernelAddress -- netlink address of the kernel, requests are sent there
+var kernelAddress = &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}
+
+//SockHandle -- Netlink socket used by the nfqueue, nflog and conntrack handles
+//Implemented by Socket, it can be replaced in tests
+type SockHandle interface {
+	Query(msg *syscall.NetlinkMessage) error
+	Send(msg *syscall.NetlinkMessage) error
+	NextSeq() uint32
+	Sendmsg(iovecs []syscall.Iovec) error
+	Recv(flags int) ([]byte, error)
+	RecvBatch(b *RecvBatch, flags int) (int, error)
+	Fd() int
+	RcvBufSize() uint32
+	LocalAddress() syscall.SockaddrNetlink
+	Close() error
+}
+
+//Socket -- Netlink socket shared by all the subsystems
+//fd -- fd of socket
+//rcvbufSize -- size of the buffer messages are read in
+//buf -- buffer messages are read in
+//lsa -- local address, Pid is the port id assigned by the kernel
+//seq -- sequence number of the last request sent on this socket
+//sendAddr, sendHdr -- address and header passed to sendmsg, reused by every call under sendLock
+type Socket struct {
+	Syscalls   syscallwrappers.Syscalls
+	fd         int
+	rcvbufSize uint32
+	buf        []byte
+	lsa        syscall.SockaddrNetlink
+	seq        uint32
+	sendLock   sync.Mutex
+	sendAddr   syscall.RawSockaddrNetlink
+	sendHdr    msghdr
+}
+
+//SocketOption -- Option applied when a Socket is opened
+type SocketOption func(*socketConfig)
+
+//socketConfig -- Options of a Socket, the zero value of each field keeps the kernel default
+type socketConfig struct {
+	buf         []byte
+	rcvBufSize  int
+	rcvBufForce bool
+	sndBufSize  int
+	sndBufForce bool
+	groups      []uint32
+	noENOBUFS   bool
+	netns       int
+	netnsPath   string
+	nonBlocking bool
+}
+
+//WithReadBuffer -- Read messages in buf instead of a buffer of NfnlBuffSize bytes
+func WithReadBuffer(buf []byte) SocketOption {
+	return func(c *socketConfig) {
+		c.buf = buf
+	}
+}
+
+//WithRcvBufSize -- Set the socket receive buffer size (SO_RCVBUF)
+//force -- use SO_RCVBUFFORCE to go over rmem_max, falls back to SO_RCVBUF without CAP_NET_ADMIN
+func WithRcvBufSize(size int, force bool) SocketOption {
+	return func(c *socketConfig) {
+		c.rcvBufSize = size
+		c.rcvBufForce = force
+	}
+}
+
+//WithSndBufSize -- Set the socket send buffer size (SO_SNDBUF)
+//force -- use SO_SNDBUFFORCE to go over wmem_max, falls back to SO_SNDBUF without CAP_NET_ADMIN
+func WithSndBufSize(size int, force bool) SocketOption {
+	return func(c *socketConfig) {
+		c.sndBufSize = size
+		c.sndBufForce = force
+	}
+}
+
+//WithMulticastGroups -- Join the netlink multicast groups once the socket is bound
+func WithMulticastGroups(groups ...uint32) SocketOption {
+	return func(c *socketConfig) {
+		c.groups = append(c.groups, groups...)
+	}
+}
+
+//WithNoENOBUFS -- Set NETLINK_NO_ENOBUFS, the kernel drops messages silently instead of
+//reporting ENOBUFS when the receive buffer is full
+func WithNoENOBUFS(enable bool) SocketOption {
+	return func(c *socketConfig) {
+		c.noENOBUFS = enable
+	}
+}
+
+//WithNetNS -- Open the socket in the network namespace referred to by fd
+//The fd is only used while the socket is opened, it is not closed
+func WithNetNS(fd int) SocketOption {
+	return func(c *socketConfig) {
+		c.netns = fd
+	}
+}
+
+//WithNetNSPath -- Open the socket in the network namespace bound at path, as /var/run/netns/name
+//or /proc/pid/ns/net
+func WithNetNSPath(path string) SocketOption {
+	return func(c *socketConfig) {
+		c.netnsPath = path
+	}
+}
+
+//WithNonBlocking -- Open the socket in non blocking mode, reads return EAGAIN when no message is queued
+func WithNonBlocking(enable bool) SocketOption {
+	return func(c *socketConfig) {
+		c.nonBlocking = enable
+	}
+}
+
+//NewSocket -- Open and bind a NETLINK_NETFILTER socket
+//Extended ACKs are enabled, the port id assigned by the kernel is read back
+func NewSocket(s syscallwrappers.Syscalls, opts ...SocketOption) (*Socket, error) {
+	cfg := &socketConfig{netns: -1}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	sh := &Socket{Syscalls: s, fd: -1, buf: cfg.buf}
+	if sh.buf == nil {
+		sh.buf = make([]byte, NfnlBuffSize)
+	}
+	sh.rcvbufSize = uint32(len(sh.buf))
+	sh.lsa.Family = syscall.AF_NETLINK
+
+	typ := syscall.SOCK_RAW
+	if cfg.nonBlocking {
+		typ |= syscall.SOCK_NONBLOCK
+	}
+
+	if cfg.netnsPath != "" {
+		nsfd, err := s.Open(cfg.netnsPath, syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to open network namespace %s: %w", cfg.netnsPath, err)
+		}
+		defer s.Close(nsfd) // nolint
+		cfg.netns = nsfd
+	}
+
+	var fd int
+	var err error
+	if cfg.netns >= 0 {
+		fd, err = socketInNetNS(s, cfg.netns, typ)
+	} else {
+		fd, err = s.Socket(syscall.AF_NETLINK, typ, syscall.NETLINK_NETFILTER)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sh.fd = fd
+
+	if err = s.Bind(fd, &sh.lsa); err != nil {
+		sh.Close() // nolint
+		return nil, err
+	}
+
+	// The kernel assigns the port id on bind, replies to our requests carry it
+	sa, err := s.Getsockname(fd)
+	if err != nil {
+		sh.Close() // nolint
+		return nil, err
+	}
+	if nlsa, ok := sa.(*syscall.SockaddrNetlink); ok {
+		sh.lsa.Pid = nlsa.Pid
+	}
+	EnableExtAck(s, fd)
+
+	if cfg.noENOBUFS {
+		s.SetsockoptInt(fd, SolNetlink, syscall.NETLINK_NO_ENOBUFS, 1) // nolint
+	}
+	if cfg.rcvBufSize > 0 {
+		setBufSize(s, fd, syscall.SO_RCVBUF, syscall.SO_RCVBUFFORCE, cfg.rcvBufSize, cfg.rcvBufForce)
+	}
+	if cfg.sndBufSize > 0 {
+		setBufSize(s, fd, syscall.SO_SNDBUF, syscall.SO_SNDBUFFORCE, cfg.sndBufSize, cfg.sndBufForce)
+	}
+	for _, group := range cfg.groups {
+		if err := s.SetsockoptInt(fd, SolNetlink, syscall.NETLINK_ADD_MEMBERSHIP, int(group)); err != nil {
+			sh.Close() // nolint
+			return nil, fmt.Errorf("Unable to join multicast group %d: %w", group, err)
+		}
+	}
+
+	return sh, nil
+}
+
+//setBufSize -- Set a socket buffer size, the force option needs CAP_NET_ADMIN so fall back to the plain one
+//Failing to size the buffer is not fatal, the kernel default is kept
+func setBufSize(s syscallwrappers.Syscalls, fd int, opt int, forceOpt int, size int, force bool) {
+	if force && s.SetsockoptInt(fd, syscall.SOL_SOCKET, forceOpt, size) == nil {
+		return
+	}
+	s.SetsockoptInt(fd, syscall.SOL_SOCKET, opt, size) // nolint
+}
+
+//socketInNetNS -- Open a netlink socket in the network namespace netns
+//The namespace is switched on a dedicated locked OS thread which returns to its original
+//namespace. If it cannot, the thread is never unlocked so the runtime terminates it.
+func socketInNetNS(s syscallwrappers.Syscalls, netns int, typ int) (int, error) {
+	type result struct {
+		fd  int
+		err error
+	}
+	done := make(chan result, 1)
+
+	go func() {
+		runtime.LockOSThread()
+
+		origPath := fmt.Sprintf("/proc/%d/task/%d/ns/net", os.Getpid(), syscall.Gettid())
+		orig, err := s.Open(origPath, syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			runtime.UnlockOSThread()
+			done <- result{-1, fmt.Errorf("Unable to open the current network namespace: %w", err)}
+			return
+		}
+		defer s.Close(orig) // nolint
+
+		if err = s.Setns(netns, syscall.CLONE_NEWNET); err != nil {
+			runtime.UnlockOSThread()
+			done <- result{-1, fmt.Errorf("Unable to enter the network namespace: %w", err)}
+			return
+		}
+
+		fd, err := s.Socket(syscall.AF_NETLINK, typ, syscall.NETLINK_NETFILTER)
+
+		if nsErr := s.Setns(orig, syscall.CLONE_NEWNET); nsErr != nil {
+			if err == nil {
+				s.Close(fd) // nolint
+			}
+			done <- result{-1, fmt.Errorf("Unable to return to the original network namespace: %w", nsErr)}
+			return
+		}
+		runtime.UnlockOSThread()
+
+		done <- result{fd, err}
+	}()
+
+	r := <-done
+	return r.fd, r.err
+}
+
+//Query -- Send the request with the next sequence number and wait for the matching ACK or error
+//Replies left over from earlier requests are discarded
+func (sh *Socket) Query(msg *syscall.NetlinkMessage) error {
+	msg.Header.Seq = sh.NextSeq()
+	msg.Header.Pid = sh.lsa.Pid
+
+	if err := sh.Send(msg); err != nil {
+		return err
+	}
+	return ReceiveReply(sh.Syscalls, sh.fd, sh.buf, msg.Header.Seq, sh.lsa.Pid, nil)
+}
+
+//NextSeq -- Returns the next sequence number of the socket, for a request whose reply is read by another reader
+func (sh *Socket) NextSeq() uint32 {
+	return atomic.AddUint32(&sh.seq, 1)
+}
+
+//Send -- Send a message to the kernel
+func (sh *Socket) Send(msg *syscall.NetlinkMessage) error {
+	buf := make([]byte, syscall.SizeofNlMsghdr+len(msg.Data))
+	SerializeNlMsgHdrBuf(&msg.Header, buf)
+	copy(buf[syscall.SizeofNlMsghdr:], msg.Data)
+
+	if err := sh.Syscalls.Sendto(sh.fd, buf, 0, kernelAddress); err != nil {
+		return &SendError{Err: err}
+	}
+	return nil
+}
+
+//Recv -- Read a datagram in the socket buffer
+//The returned slice is only valid until the next read
+func (sh *Socket) Recv(flags int) ([]byte, error) {
+	n, _, err := sh.Syscalls.Recvfrom(sh.fd, sh.buf, flags)
+	if err != nil {
+		return nil, err
+	}
+	return sh.buf[:n], nil
+}
+
+//Fd -- Returns the fd of the socket
+func (sh *Socket) Fd() int {
+	return sh.fd
+}
+
+//RcvBufSize -- Returns the size of the buffer messages are read in
+func (sh *Socket) RcvBufSize() uint32 {
+	return sh.rcvbufSize
+}
+
+//LocalAddress -- Returns the address the socket is bound to
+func (sh *Socket) LocalAddress() syscall.SockaddrNetlink {
+	return sh.lsa
+}
+
+//Close -- Close the socket
+func (sh *Socket) Close() error {
+	if sh.fd < 0 {
+		return nil
+	}
+	err := sh.Syscalls.Close(sh.fd)
+	sh.fd = -1
+	return err
+}
@@ -0,0 +1,40 @@
+// +build linux
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+//errNoIovecs -- Sendmsg was called without buffer to send
+var errNoIovecs = errors.New("Sendmsg called without buffers")
+
+//Sendmsg -- Send a message made of several buffers to the kernel without copying them
+//The message header is reused, it does not allocate
+func (sh *Socket) Sendmsg(iovecs []syscall.Iovec) error {
+	if len(iovecs) == 0 {
+		return errNoIovecs
+	}
+
+	sh.sendLock.Lock()
+	defer sh.sendLock.Unlock()
+
+	sh.sendAddr = syscall.RawSockaddrNetlink{Family: syscall.AF_NETLINK}
+	sh.sendHdr = msghdr{syscall.Msghdr{
+		Name:    (*byte)(unsafe.Pointer(&sh.sendAddr)),
+		Namelen: syscall.SizeofSockaddrNetlink,
+		Iov:     &iovecs[0],
+	}}
+	sh.sendHdr.SetIovlen(len(iovecs))
+
+	_, _, errno := sh.Syscalls.Syscall(unix.SYS_SENDMSG, uintptr(sh.fd), uintptr(unsafe.Pointer(&sh.sendHdr.Msghdr)), uintptr(0))
+	if errno != 0 {
+		return fmt.Errorf("Sendmsg returned error %w", errno)
+	}
+	return nil
+}
@@ -1,12 +1,13 @@
-// Automatically generated by MockGen. DO NOT EDIT!
+// Code generated by MockGen. DO NOT EDIT.
 // Source: syscall_interfaces.go
 
+// Package syscallwrappers is a generated GoMock package.
 package syscallwrappers
 
 import (
-	syscall "syscall"
-
 	gomock "github.com/golang/mock/gomock"
+	reflect "reflect"
+	syscall "syscall"
 )
 
 // MockSyscalls is a mock of Syscalls interface
@@ -28,63 +29,86 @@ func NewMockSyscalls(ctrl *gomock.Controller) *MockSyscalls {
 }
 
 // EXPECT returns an object that allows the caller to indicate expected use
-func (_m *MockSyscalls) EXPECT() *MockSyscallsMockRecorder {
-	return _m.recorder
+func (m *MockSyscalls) EXPECT() *MockSyscallsMockRecorder {
+	return m.recorder
 }
 
 // Bind mocks base method
-func (_m *MockSyscalls) Bind(fd int, sa syscall.Sockaddr) error {
-	ret := _m.ctrl.Call(_m, "Bind", fd, sa)
+func (m *MockSyscalls) Bind(fd int, sa syscall.Sockaddr) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Bind", fd, sa)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Bind indicates an expected call of Bind
-func (_mr *MockSyscallsMockRecorder) Bind(arg0, arg1 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "Bind", arg0, arg1)
+func (mr *MockSyscallsMockRecorder) Bind(fd, sa interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Bind", reflect.TypeOf((*MockSyscalls)(nil).Bind), fd, sa)
 }
 
-// Socket mocks base method
-func (_m *MockSyscalls) Socket(domain int, typ int, proto int) (int, error) {
+// Getsockname mocks base method
+func (m *MockSyscalls) Getsockname(fd int) (syscall.Sockaddr, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Getsockname", fd)
+	ret0, _ := ret[0].(syscall.Sockaddr)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
 
-	ret := _m.ctrl.Call(_m, "Socket", domain, typ, proto)
+// Getsockname indicates an expected call of Getsockname
+func (mr *MockSyscallsMockRecorder) Getsockname(fd interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Getsockname", reflect.TypeOf((*MockSyscalls)(nil).Getsockname), fd)
+}
+
+// Socket mocks base method
+func (m *MockSyscalls) Socket(domain, typ, proto int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Socket", domain, typ, proto)
 	ret0, _ := ret[0].(int)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Socket indicates an expected call of Socket
-func (_mr *MockSyscallsMockRecorder) Socket(arg0, arg1, arg2 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "Socket", arg0, arg1, arg2)
+func (mr *MockSyscallsMockRecorder) Socket(domain, typ, proto interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Socket", reflect.TypeOf((*MockSyscalls)(nil).Socket), domain, typ, proto)
 }
 
 // SetsockoptInt mocks base method
-func (_m *MockSyscalls) SetsockoptInt(fd int, level int, opt int, value int) error {
-	ret := _m.ctrl.Call(_m, "SetsockoptInt", fd, level, opt, value)
+func (m *MockSyscalls) SetsockoptInt(fd, level, opt, value int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetsockoptInt", fd, level, opt, value)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // SetsockoptInt indicates an expected call of SetsockoptInt
-func (_mr *MockSyscallsMockRecorder) SetsockoptInt(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetsockoptInt", arg0, arg1, arg2, arg3)
+func (mr *MockSyscallsMockRecorder) SetsockoptInt(fd, level, opt, value interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetsockoptInt", reflect.TypeOf((*MockSyscalls)(nil).SetsockoptInt), fd, level, opt, value)
 }
 
 // Close mocks base method
-func (_m *MockSyscalls) Close(fd int) error {
-	ret := _m.ctrl.Call(_m, "Close", fd)
+func (m *MockSyscalls) Close(fd int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Close", fd)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Close indicates an expected call of Close
-func (_mr *MockSyscallsMockRecorder) Close(arg0 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "Close", arg0)
+func (mr *MockSyscallsMockRecorder) Close(fd interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockSyscalls)(nil).Close), fd)
 }
 
 // Recvfrom mocks base method
-func (_m *MockSyscalls) Recvfrom(fd int, p []byte, flags int) (int, syscall.Sockaddr, error) {
-	ret := _m.ctrl.Call(_m, "Recvfrom", fd, p, flags)
+func (m *MockSyscalls) Recvfrom(fd int, p []byte, flags int) (int, syscall.Sockaddr, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Recvfrom", fd, p, flags)
 	ret0, _ := ret[0].(int)
 	ret1, _ := ret[1].(syscall.Sockaddr)
 	ret2, _ := ret[2].(error)
@@ -92,25 +116,29 @@ func (_m *MockSyscalls) Recvfrom(fd int, p []byte, flags int) (int, syscall.Sock
 }
 
 // Recvfrom indicates an expected call of Recvfrom
-func (_mr *MockSyscallsMockRecorder) Recvfrom(arg0, arg1, arg2 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "Recvfrom", arg0, arg1, arg2)
+func (mr *MockSyscallsMockRecorder) Recvfrom(fd, p, flags interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Recvfrom", reflect.TypeOf((*MockSyscalls)(nil).Recvfrom), fd, p, flags)
 }
 
 // Sendto mocks base method
-func (_m *MockSyscalls) Sendto(fd int, p []byte, flags int, to syscall.Sockaddr) error {
-	ret := _m.ctrl.Call(_m, "Sendto", fd, p, flags, to)
+func (m *MockSyscalls) Sendto(fd int, p []byte, flags int, to syscall.Sockaddr) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Sendto", fd, p, flags, to)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Sendto indicates an expected call of Sendto
-func (_mr *MockSyscallsMockRecorder) Sendto(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "Sendto", arg0, arg1, arg2, arg3)
+func (mr *MockSyscallsMockRecorder) Sendto(fd, p, flags, to interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Sendto", reflect.TypeOf((*MockSyscalls)(nil).Sendto), fd, p, flags, to)
 }
 
 // Syscall mocks base method
-func (_m *MockSyscalls) Syscall(trap uintptr, a1 uintptr, a2 uintptr, a3 uintptr) (uintptr, uintptr, syscall.Errno) {
-	ret := _m.ctrl.Call(_m, "Syscall", trap, a1, a2, a3)
+func (m *MockSyscalls) Syscall(trap, a1, a2, a3 uintptr) (uintptr, uintptr, syscall.Errno) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Syscall", trap, a1, a2, a3)
 	ret0, _ := ret[0].(uintptr)
 	ret1, _ := ret[1].(uintptr)
 	ret2, _ := ret[2].(syscall.Errno)
@@ -118,6 +146,51 @@ func (_m *MockSyscalls) Syscall(trap uintptr, a1 uintptr, a2 uintptr, a3 uintptr
 }
 
 // Syscall indicates an expected call of Syscall
-func (_mr *MockSyscallsMockRecorder) Syscall(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "Syscall", arg0, arg1, arg2, arg3)
+func (mr *MockSyscallsMockRecorder) Syscall(trap, a1, a2, a3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Syscall", reflect.TypeOf((*MockSyscalls)(nil).Syscall), trap, a1, a2, a3)
+}
+
+// Open mocks base method
+func (m *MockSyscalls) Open(path string, mode int, perm uint32) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Open", path, mode, perm)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Open indicates an expected call of Open
+func (mr *MockSyscallsMockRecorder) Open(path, mode, perm interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Open", reflect.TypeOf((*MockSyscalls)(nil).Open), path, mode, perm)
+}
+
+// Setns mocks base method
+func (m *MockSyscalls) Setns(fd, nstype int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Setns", fd, nstype)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Setns indicates an expected call of Setns
+func (mr *MockSyscallsMockRecorder) Setns(fd, nstype interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Setns", reflect.TypeOf((*MockSyscalls)(nil).Setns), fd, nstype)
+}
+
+// Recvmmsg mocks base method
+func (m *MockSyscalls) Recvmmsg(fd int, msgs []Mmsghdr, flags int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Recvmmsg", fd, msgs, flags)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Recvmmsg indicates an expected call of Recvmmsg
+func (mr *MockSyscallsMockRecorder) Recvmmsg(fd, msgs, flags interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Recvmmsg", reflect.TypeOf((*MockSyscalls)(nil).Recvmmsg), fd, msgs, flags)
 }
@@ -4,10 +4,14 @@ package syscallwrappers
 
 import "syscall"
 
+//go:generate mockgen -source=syscall_interfaces.go -destination=mocksyscalls.go -package=syscallwrappers
+
 // Syscalls interface will have the methods for syscall system functions used in nfqueue
 type Syscalls interface {
 	// Bind will bind to a PF family
 	Bind(fd int, sa syscall.Sockaddr) error
+	// Getsockname will return the local address the socket is bound to
+	Getsockname(fd int) (syscall.Sockaddr, error)
 	// Socket will open a new socket
 	Socket(domain, typ, proto int) (int, error)
 	// SetsockoptInt will be used to set socket options
@@ -20,4 +24,10 @@ type Syscalls interface {
 	Sendto(fd int, p []byte, flags int, to syscall.Sockaddr) error
 	// Syscall is used as wrapper for syscall.SYS_SENDMSG
 	Syscall(trap, a1, a2, a3 uintptr) (uintptr, uintptr, syscall.Errno)
+	// Open is used to open a namespace file
+	Open(path string, mode int, perm uint32) (int, error)
+	// Setns will move the calling thread to the namespace referred to by fd
+	Setns(fd int, nstype int) error
+	// Recvmmsg is used to receive several messages from Socket in one call, returns the number received
+	Recvmmsg(fd int, msgs []Mmsghdr, flags int) (int, error)
 }
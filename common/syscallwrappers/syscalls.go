@@ -1,6 +1,8 @@
 package syscallwrappers
 
-import "syscall"
+import (
+	"syscall"
+)
 
 type syscalltypes struct {
 }
@@ -17,6 +19,10 @@ func (p *syscalltypes) Bind(fd int, sa syscall.Sockaddr) error {
 	return nil
 }
 
+func (p *syscalltypes) Getsockname(fd int) (syscall.Sockaddr, error) {
+	return syscall.Getsockname(fd)
+}
+
 func (p *syscalltypes) Socket(domain, typ, proto int) (int, error) {
 	fd, err := syscall.Socket(domain, typ, proto)
 	if err != nil {
@@ -61,3 +67,7 @@ func (p *syscalltypes) Syscall(trap, a1, a2, a3 uintptr) (uintptr, uintptr, sysc
 	}
 	return r1, r2, 0
 }
+
+func (p *syscalltypes) Open(path string, mode int, perm uint32) (int, error) {
+	return syscall.Open(path, mode, perm)
+}
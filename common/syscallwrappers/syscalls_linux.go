@@ -0,0 +1,29 @@
+// +build linux !darwin
+
+package syscallwrappers
+
+import (
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func (p *syscalltypes) Setns(fd int, nstype int) error {
+	_, _, err := syscall.RawSyscall(unix.SYS_SETNS, uintptr(fd), uintptr(nstype), 0)
+	if err != 0 {
+		return err
+	}
+	return nil
+}
+
+func (p *syscalltypes) Recvmmsg(fd int, msgs []Mmsghdr, flags int) (int, error) {
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+	n, _, err := syscall.Syscall6(unix.SYS_RECVMMSG, uintptr(fd), uintptr(unsafe.Pointer(&msgs[0])), uintptr(len(msgs)), uintptr(flags), 0, 0)
+	if err != 0 {
+		return -1, err
+	}
+	return int(n), nil
+}
@@ -0,0 +1,13 @@
+// +build darwin !linux
+
+package syscallwrappers
+
+import "syscall"
+
+func (p *syscalltypes) Setns(fd int, nstype int) error {
+	return syscall.ENOSYS
+}
+
+func (p *syscalltypes) Recvmmsg(fd int, msgs []Mmsghdr, flags int) (int, error) {
+	return -1, syscall.ENOSYS
+}
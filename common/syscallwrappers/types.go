@@ -0,0 +1,9 @@
+package syscallwrappers
+
+import "syscall"
+
+// Mmsghdr is the header of one message received by Recvmmsg, Len is set to the bytes received
+type Mmsghdr struct {
+	Hdr syscall.Msghdr
+	Len uint32
+}
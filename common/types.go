@@ -5,8 +5,6 @@ package common
 
 import (
 	"syscall"
-
-	"go.aporeto.io/netlink-go/common/syscallwrappers"
 )
 
 //Types for various enums needed by the nfqueue subsys in linux
@@ -83,16 +81,8 @@ type NfqMsgConfigQueueLen struct {
 }
 
 //SockHandles -- Sock handle of netlink socket
-//fd -- fd of socket
-//rcvbufSize -- rcv buffer Size
-//lsa -- local address
-type SockHandles struct {
-	Syscalls   syscallwrappers.Syscalls
-	fd         int
-	rcvbufSize uint32
-	buf        []byte
-	lsa        syscall.SockaddrNetlink
-}
+//Deprecated: use Socket
+type SockHandles = Socket
 
 //NfqGenMsg -- the nfgen msg structure
 //nfGenFamily -- Family
@@ -3,6 +3,7 @@
 package conntrack
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"syscall"
@@ -11,18 +12,29 @@ import (
 	"go.aporeto.io/netlink-go/common/syscallwrappers"
 
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
 )
 
 // NewHandle which returns interface which implements Conntrack table get/set/flush
-func NewHandle() Conntrack {
-	return &Handles{Syscalls: syscallwrappers.NewSyscalls()}
+// opts -- options applied to the handle, e.g. WithNetNS to manipulate the table of another network namespace
+func NewHandle(opts ...Option) Conntrack {
+	h := &Handles{Syscalls: syscallwrappers.NewSyscalls()}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
 }
 
 // ConntrackTableList retrieves entries from Conntract table and parse it in the conntrack flow struct
 // Using vishvananda/netlink and nl packages for parsing
 // returns an array of ConntrackFlow with 4 tuples, protocol and mark
 func (h *Handles) ConntrackTableList(table netlink.ConntrackTableType) ([]*netlink.ConntrackFlow, error) {
-	result, err := netlink.ConntrackTableList(table, syscall.AF_INET)
+	nlHandle, err := h.netlinkHandle()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := nlHandle.ConntrackTableList(table, syscall.AF_INET)
 	if result == nil || err != nil {
 		return nil, fmt.Errorf("Empty table")
 	}
@@ -32,12 +44,42 @@ func (h *Handles) ConntrackTableList(table netlink.ConntrackTableType) ([]*netli
 // ConntrackTableFlush will flush the Conntrack table entries
 // Using vishvananda/netlink and nl packages for flushing entries
 func (h *Handles) ConntrackTableFlush(table netlink.ConntrackTableType) error {
+	nlHandle, err := h.netlinkHandle()
+	if err != nil {
+		return err
+	}
 
-	return netlink.ConntrackTableFlush(table)
+	return nlHandle.ConntrackTableFlush(table)
+}
+
+// netlinkHandle returns the vishvananda/netlink handle for the namespace of the handle
+// The zero handle works in the current namespace
+func (h *Handles) netlinkHandle() (*netlink.Handle, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.netnsPath == "" {
+		return &netlink.Handle{}, nil
+	}
+	if h.nl != nil {
+		return h.nl, nil
+	}
+
+	ns, err := netns.GetFromPath(h.netnsPath)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open network namespace %s: %w", h.netnsPath, err)
+	}
+	defer ns.Close() // nolint
+
+	if h.nl, err = netlink.NewHandleAt(ns, syscall.NETLINK_NETFILTER); err != nil {
+		h.nl = nil
+		return nil, fmt.Errorf("Unable to open netlink handle in %s: %w", h.netnsPath, err)
+	}
+	return h.nl, nil
 }
 
 // ConntrackTableUpdateMarkForAvailableFlow will update conntrack table mark attribute only if the flow is present
-// Also returns number of entries updated
+// Also returns number of entries updated. Flows removed by the kernel since they were listed are skipped
 func (h *Handles) ConntrackTableUpdateMarkForAvailableFlow(flows []*netlink.ConntrackFlow, ipSrc, ipDst string, protonum uint8, srcport, dstport uint16, newmark uint32) (int, error) {
 
 	var entriesUpdated int
@@ -47,8 +89,12 @@ func (h *Handles) ConntrackTableUpdateMarkForAvailableFlow(flows []*netlink.Conn
 
 		if isEntryPresent && newmark != 0 {
 			err := h.ConntrackTableUpdateMark(ipSrc, ipDst, protonum, srcport, dstport, newmark)
+			if errors.Is(err, syscall.ENOENT) {
+				// The flow expired after it was listed, nothing to update
+				continue
+			}
 			if err != nil {
-				return 0, fmt.Errorf("Error %v", err)
+				return 0, fmt.Errorf("Error %w", err)
 			}
 			entriesUpdated++
 		}
@@ -62,16 +108,13 @@ func (h *Handles) ConntrackTableUpdateMarkForAvailableFlow(flows []*netlink.Conn
 }
 
 // ConntrackTableUpdateMark will update conntrack table mark attribute
+// If the kernel rejects the update a *common.NetlinkError is returned, use errors.Is(err, syscall.ENOENT) to detect a missing flow
 func (h *Handles) ConntrackTableUpdateMark(ipSrc, ipDst string, protonum uint8, srcport, dstport uint16, newmark uint32) error {
 
-	hdr, data := buildConntrackUpdateRequest(ipSrc, ipDst, protonum, srcport, dstport)
-
-	mark := common.NfValue32{}
-	mark.Set32Value(newmark)
-
-	data = append(data, appendMark(mark, hdr)...)
+	req := buildConntrackUpdateRequest(ipSrc, ipDst, protonum, srcport, dstport)
+	appendMark(req, newmark)
 
-	return h.sendMessage(hdr, data)
+	return h.sendMessage(req.Message())
 }
 
 // ConntrackTableUpdateLabel will update conntrack table label attribute
@@ -80,22 +123,24 @@ func (h *Handles) ConntrackTableUpdateMark(ipSrc, ipDst string, protonum uint8,
 func (h *Handles) ConntrackTableUpdateLabel(table netlink.ConntrackTableType, flows []*netlink.ConntrackFlow, ipSrc, ipDst string, protonum uint8, srcport, dstport uint16, newlabels uint32) (int, error) {
 
 	var entriesUpdated int
-	var labels common.NfValue32
 
 	for i := range flows {
 		isEntryPresent := checkTuplesInFlow(flows[i], ipSrc, ipDst, protonum, srcport, dstport)
 
 		if isEntryPresent {
-			hdr, data := buildConntrackUpdateRequest(ipSrc, ipDst, protonum, srcport, dstport)
+			req := buildConntrackUpdateRequest(ipSrc, ipDst, protonum, srcport, dstport)
 
 			if protonum == common.TCP_PROTO {
-				data = append(data, appendProtoInfo(hdr)...)
+				appendProtoInfo(req)
 			}
 
-			labels.Set32Value(newlabels)
-			data = append(data, appendLabel(labels, hdr)...)
+			appendLabel(req, newlabels)
 
-			err := h.sendMessage(hdr, data)
+			err := h.sendMessage(req.Message())
+			if errors.Is(err, syscall.ENOENT) {
+				// The flow expired after it was listed, nothing to update
+				continue
+			}
 			if err != nil {
 				return 0, err
 			}
@@ -154,108 +199,117 @@ func checkTuplesInFlow(flow *netlink.ConntrackFlow, ipSrc, ipDst string, protonu
 }
 
 // buildConntrackUpdateRequest is generic for all conntrack attribute updates
-// returns bytes till dstport from the table, if the flow is present
-// to update other attributes, it is highly recommended to check the length of the NESTED attributes
-func buildConntrackUpdateRequest(ipSrc, ipDst string, protonum uint8, srcport, dstport uint16) (*syscall.NlMsghdr, []byte) {
-
-	var ipv4ValueSrc, ipv4ValueDst common.NfValue32
-	var protoNum common.NfValue8
-	var srcPort, dstPort common.NfValue16
-
-	ipv4ValueSrc.Set32Value(common.IP2int(net.ParseIP(ipSrc)))
-	ipv4ValueDst.Set32Value(common.IP2int(net.ParseIP(ipDst)))
-	protoNum.Set8Value(protonum)
-	srcPort.Set16Value(srcport)
-	dstPort.Set16Value(dstport)
-
-	hdr := common.BuildNlMsgHeader(common.NfnlConntrackTable, common.NlmFRequest|common.NlmFAck, 0)
-	nfgen := common.BuildNfgenMsg(syscall.AF_INET, common.NFNetlinkV0, 0, hdr)
-	nfgenTupleOrigAttr := common.BuildNfAttrMsg(NLA_F_NESTED|CTA_TUPLE_ORIG, hdr, SizeOfNestedTupleOrig)
-	nfgenTupleIPAttr := common.BuildNfNestedAttrMsg(NLA_F_NESTED|CTA_TUPLE_IP, int(SizeOfNestedTupleIP))
-	nfgenTupleIPV4SrcAttr := common.BuildNfNestedAttrMsg(CTA_IP_V4_SRC, int(ipv4ValueSrc.Length()))
-	nfgenTupleIPV4DstAttr := common.BuildNfNestedAttrMsg(CTA_IP_V4_DST, int(ipv4ValueDst.Length()))
-	nfgenTupleProto := common.BuildNfNestedAttrMsg(NLA_F_NESTED|CTA_TUPLE_PROTO, int(SizeOfNestedTupleProto))
-	nfgenTupleProtoNum := common.BuildNfAttrWithPaddingMsg(CTA_PROTO_NUM, PROTO_NUM_LEN)
-	nfgenTupleSrcPort := common.BuildNfAttrWithPaddingMsg(CTA_PROTO_SRC_PORT, PROTO_SRC_PORT_LEN)
-	nfgenTupleDstPort := common.BuildNfAttrWithPaddingMsg(CTA_PROTO_DST_PORT, PROTO_DST_PORT_LEN)
-
-	buf := make([]byte, 3*int(common.SizeofNfAttr)+int(common.SizeofNfGenMsg)+2*int(common.NfaLength(uint16(common.SizeOfValue32)))+2*int(common.NfaLength(uint16(common.SizeOfValue16)))+int(common.NfaLength(uint16(common.SizeOfValue8))))
-	copyIndex := nfgen.ToWireFormatBuf(buf)
-	copyIndex += nfgenTupleOrigAttr.ToWireFormatBuf(buf[copyIndex:])
-	copyIndex += nfgenTupleIPAttr.ToWireFormatBuf(buf[copyIndex:])
-	copyIndex += nfgenTupleIPV4SrcAttr.ToWireFormatBuf(buf[copyIndex:])
-	copyIndex += ipv4ValueSrc.ToWireFormatBuf(buf[copyIndex:])
-	copyIndex += nfgenTupleIPV4DstAttr.ToWireFormatBuf(buf[copyIndex:])
-	copyIndex += ipv4ValueDst.ToWireFormatBuf(buf[copyIndex:])
-	copyIndex += nfgenTupleProto.ToWireFormatBuf(buf[copyIndex:])
-	copyIndex += nfgenTupleProtoNum.ToWireFormatBuf(buf[copyIndex:])
-	copyIndex += protoNum.ToWireFormatBuf(buf[copyIndex:])
-	copyIndex += nfgenTupleSrcPort.ToWireFormatBuf(buf[copyIndex:])
-	copyIndex += srcPort.ToWireFormatBuf(buf[copyIndex:])
-	copyIndex += nfgenTupleDstPort.ToWireFormatBuf(buf[copyIndex:])
-	dstPort.ToWireFormatBuf(buf[copyIndex:])
-
-	return hdr, buf
+// returns a request holding the original tuple of the flow, the attributes to update are added to it
+func buildConntrackUpdateRequest(ipSrc, ipDst string, protonum uint8, srcport, dstport uint16) *common.NfnlRequest {
+
+	req := common.NewNfnlRequest(nil, common.NfnlConntrackTable, common.NlmFRequest|common.NlmFAck, syscall.AF_INET, 0)
+
+	req.BeginNested(CTA_TUPLE_ORIG)
+	req.BeginNested(CTA_TUPLE_IP)
+	req.PutUint32(CTA_IP_V4_SRC, common.IP2int(net.ParseIP(ipSrc)))
+	req.PutUint32(CTA_IP_V4_DST, common.IP2int(net.ParseIP(ipDst)))
+	req.EndNested()
+	req.BeginNested(CTA_TUPLE_PROTO)
+	req.PutUint8(CTA_PROTO_NUM, protonum)
+	req.PutUint16(CTA_PROTO_SRC_PORT, srcport)
+	req.PutUint16(CTA_PROTO_DST_PORT, dstport)
+	req.EndNested()
+	req.EndNested()
+
+	return req
 }
 
 // appendMark will add the given mark to the flows
-func appendMark(mark common.NfValue32, hdr *syscall.NlMsghdr) []byte {
-	nfgenMark := common.BuildNfAttrMsg(CTA_MARK, hdr, mark.Length())
-
-	markData := nfgenMark.ToWireFormat()
-	markData = append(markData, mark.ToWireFormat()...)
-
-	return markData
+func appendMark(req *common.NfnlRequest, mark uint32) {
+	req.PutUint32(CTA_MARK, mark)
 }
 
 // appendLabel will add the given label to the flows
-func appendLabel(label common.NfValue32, hdr *syscall.NlMsghdr) []byte {
-	nfgenLabel := common.BuildNfAttrMsg(CTA_LABELS, hdr, label.Length())
-
-	buf := make([]byte, int(common.SizeOfValue32))
-	common.NativeEndian().PutUint32(buf, label.Get32Value())
-
-	labelData := nfgenLabel.ToWireFormat()
-	labelData = append(labelData, buf...)
-
-	return labelData
+func appendLabel(req *common.NfnlRequest, label uint32) {
+	req.PutNativeUint32(CTA_LABELS, label)
 }
 
 // appendProtoInfo will add protocolinfo to the bytes
 // only if the protocol is TCP
-func appendProtoInfo(hdr *syscall.NlMsghdr) []byte {
-	var flagsOrig, flagsReply common.NfValue16
-	var data []byte
-
-	flagsOrig.Set16Value(uint16(2570))
-	flagsReply.Set16Value(uint16(2570))
-
-	protoData := common.BuildNfAttrMsg(NLA_F_NESTED|CTA_PROTOINFO, hdr, SizeofNestedProtoInfo)
-	protoTCPData := common.BuildNfNestedAttrMsg(NLA_F_NESTED|CTA_PROTOINFO_TCP, int(SizeofNestedProtoInfoTCP))
-	protoOriginal := common.BuildNfAttrWithPaddingMsg(CTA_PROTOINFO_TCP_FLAGS_ORIGINAL, int(flagsOrig.Length()))
-	protoReply := common.BuildNfAttrWithPaddingMsg(CTA_PROTOINFO_TCP_FLAGS_REPLY, int(flagsReply.Length()))
-
-	data = append(data, protoData.ToWireFormat()...)
-	data = append(data, protoTCPData.ToWireFormat()...)
-	data = append(data, protoOriginal.ToWireFormat()...)
-	data = append(data, flagsOrig.ToWireFormat()...)
-	data = append(data, protoReply.ToWireFormat()...)
-	data = append(data, flagsReply.ToWireFormat()...)
-
-	return data
+func appendProtoInfo(req *common.NfnlRequest) {
+	req.BeginNested(CTA_PROTOINFO)
+	req.BeginNested(CTA_PROTOINFO_TCP)
+	req.PutUint16(CTA_PROTOINFO_TCP_FLAGS_ORIGINAL, uint16(2570))
+	req.PutUint16(CTA_PROTOINFO_TCP_FLAGS_REPLY, uint16(2570))
+	req.EndNested()
+	req.EndNested()
 }
 
-func (h *Handles) sendMessage(hdr *syscall.NlMsghdr, data []byte) error {
-	sh, err := h.open()
-	defer sh.close()
-	if err != nil {
-		return err
+// ENOBUFSCount returns the number of replies lost to ENOBUFS, counted by the common.ENOBUFSCount
+// and common.ENOBUFSResync policies
+func (h *Handles) ENOBUFSCount() uint64 {
+	return h.enobufs.Count()
+}
+
+// Close will close the netlink socket held by the handle
+// A later request on the handle opens a new socket
+func (h *Handles) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.nl != nil {
+		h.nl.Delete()
+		h.nl = nil
 	}
+	if h.sock == nil {
+		return nil
+	}
+	err := h.sock.Close()
+	h.sock = nil
+	return err
+}
 
-	netlinkMsg := &syscall.NetlinkMessage{
-		Header: *hdr,
-		Data:   data,
+// sendMessage sends the request on the handle's socket and waits for the kernel ACK
+// The socket is opened on first use. If the request could not be sent the socket is reopened
+// and the request sent again once. Once sent it is never sent again: a reply lost to ENOBUFS
+// or a failed read does not tell if the kernel applied it, the error is returned. The socket
+// is kept after ENOBUFS and reopened by the next request after any other read error.
+func (h *Handles) sendMessage(netlinkMsg *syscall.NetlinkMessage) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if h.sock == nil {
+			sock, err := common.NewSocket(h.Syscalls, h.sockOpts...)
+			if err != nil {
+				return err
+			}
+			h.sock = sock
+		}
+
+		err = h.sock.Query(netlinkMsg)
+		if err == nil {
+			return nil
+		}
+
+		// The kernel rejecting the request is final
+		var nlErr *common.NetlinkError
+		if errors.As(err, &nlErr) {
+			return err
+		}
+
+		// The request was not sent, it is safe to send it again on a new socket
+		var sendErr *common.SendError
+		if errors.As(err, &sendErr) {
+			h.sock.Close() // nolint
+			h.sock = nil
+			continue
+		}
+
+		// The reply was lost, sending the request again could apply it twice
+		h.enobufs.Handle(err)
+		if !errors.Is(err, syscall.ENOBUFS) {
+			h.sock.Close() // nolint
+			h.sock = nil
+		}
+		return err
 	}
 
-	return sh.query(netlinkMsg)
+	return err
 }
@@ -1,13 +1,155 @@
 package conntrack
 
 import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
 	"testing"
 
+	"github.com/golang/mock/gomock"
+	"github.com/vishvananda/netlink"
 	"go.aporeto.io/netlink-go/common"
+	"go.aporeto.io/netlink-go/common/nltest"
+	"go.aporeto.io/netlink-go/common/syscallwrappers"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
 
+func TestSendMessage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given I create a new handle with a mocked socket", t, func() {
+		mockSyscalls := syscallwrappers.NewMockSyscalls(ctrl)
+		handle := &Handles{Syscalls: mockSyscalls}
+
+		nltest.ExpectSocket(mockSyscalls, 5, 100)
+
+		Convey("When I update a mark twice", func() {
+			mockSyscalls.EXPECT().Sendto(5, gomock.Any(), 0, gomock.Any()).Times(2).Return(nil)
+			gomock.InOrder(
+				mockSyscalls.EXPECT().Recvfrom(5, gomock.Any(), 0).DoAndReturn(nltest.RecvMessage(nltest.AckMessage(1, 100, 0))),
+				mockSyscalls.EXPECT().Recvfrom(5, gomock.Any(), 0).DoAndReturn(nltest.RecvMessage(nltest.AckMessage(1, 100, 0))),
+				mockSyscalls.EXPECT().Recvfrom(5, gomock.Any(), 0).DoAndReturn(nltest.RecvMessage(nltest.AckMessage(2, 100, 0))),
+			)
+			err1 := handle.ConntrackTableUpdateMark("127.0.0.1", "127.0.0.10", 17, 2000, 3000, 23)
+			err2 := handle.ConntrackTableUpdateMark("127.0.0.1", "127.0.0.10", 17, 2000, 3000, 24)
+
+			Convey("Then the socket should be reused and stale replies skipped", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+			})
+		})
+
+		Convey("When the kernel rejects the request", func() {
+			mockSyscalls.EXPECT().Sendto(5, gomock.Any(), 0, gomock.Any()).Times(1).Return(nil)
+			mockSyscalls.EXPECT().Recvfrom(5, gomock.Any(), 0).DoAndReturn(nltest.RecvMessage(nltest.AckMessage(1, 100, -int32(syscall.ENOENT))))
+			err := handle.ConntrackTableUpdateMark("127.0.0.1", "127.0.0.10", 17, 2000, 3000, 23)
+
+			Convey("Then I should get an error and keep the socket", func() {
+				So(err, ShouldNotBeNil)
+				So(errors.Is(err, syscall.ENOENT), ShouldBeTrue)
+				So(handle.sock, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the flow disappears before its mark is updated", func() {
+			flow := &netlink.ConntrackFlow{}
+			flow.Forward.SrcIP, flow.Forward.DstIP = net.ParseIP("127.0.0.1"), net.ParseIP("127.0.0.10")
+			flow.Reverse.SrcIP, flow.Reverse.DstIP = net.ParseIP("127.0.0.10"), net.ParseIP("127.0.0.1")
+			flow.Forward.Protocol, flow.Reverse.Protocol = 17, 17
+			flow.Forward.SrcPort, flow.Forward.DstPort = 2000, 3000
+			flow.Reverse.SrcPort, flow.Reverse.DstPort = 3000, 2000
+
+			mockSyscalls.EXPECT().Sendto(5, gomock.Any(), 0, gomock.Any()).Times(1).Return(nil)
+			mockSyscalls.EXPECT().Recvfrom(5, gomock.Any(), 0).DoAndReturn(nltest.RecvMessage(nltest.AckMessage(1, 100, -int32(syscall.ENOENT))))
+			entries, err := handle.ConntrackTableUpdateMarkForAvailableFlow([]*netlink.ConntrackFlow{flow}, "127.0.0.1", "127.0.0.10", 17, 2000, 3000, 23)
+
+			Convey("Then the flow should be skipped without error", func() {
+				So(err, ShouldBeNil)
+				So(entries, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the socket fails", func() {
+			mockSyscalls.EXPECT().Sendto(5, gomock.Any(), 0, gomock.Any()).Times(1).Return(fmt.Errorf("send failed"))
+			mockSyscalls.EXPECT().Close(5).Times(1)
+			nltest.ExpectSocket(mockSyscalls, 6, 101)
+			mockSyscalls.EXPECT().Sendto(6, gomock.Any(), 0, gomock.Any()).Times(1).Return(nil)
+			mockSyscalls.EXPECT().Recvfrom(6, gomock.Any(), 0).DoAndReturn(nltest.RecvMessage(nltest.AckMessage(1, 101, 0)))
+			err := handle.ConntrackTableUpdateMark("127.0.0.1", "127.0.0.10", 17, 2000, 3000, 23)
+
+			Convey("Then the socket should be reopened and the request retried", func() {
+				So(err, ShouldBeNil)
+
+				mockSyscalls.EXPECT().Close(6).Times(1)
+				So(handle.Close(), ShouldBeNil)
+				So(handle.sock, ShouldBeNil)
+			})
+		})
+
+		Convey("When the reply is lost to ENOBUFS", func() {
+			handle.enobufs.Policy = common.ENOBUFSCount
+			mockSyscalls.EXPECT().Sendto(5, gomock.Any(), 0, gomock.Any()).Times(1).Return(nil)
+			mockSyscalls.EXPECT().Recvfrom(5, gomock.Any(), 0).Times(1).Return(0, nil, syscall.ENOBUFS)
+			err := handle.ConntrackTableUpdateMark("127.0.0.1", "127.0.0.10", 17, 2000, 3000, 23)
+
+			Convey("Then I should get the error without the request being sent again", func() {
+				So(errors.Is(err, syscall.ENOBUFS), ShouldBeTrue)
+				So(handle.ENOBUFSCount(), ShouldEqual, 1)
+				So(handle.sock, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When reading the reply fails", func() {
+			mockSyscalls.EXPECT().Sendto(5, gomock.Any(), 0, gomock.Any()).Times(1).Return(nil)
+			mockSyscalls.EXPECT().Recvfrom(5, gomock.Any(), 0).Times(1).Return(0, nil, syscall.EBADF)
+			mockSyscalls.EXPECT().Close(5).Times(1)
+			err := handle.ConntrackTableUpdateMark("127.0.0.1", "127.0.0.10", 17, 2000, 3000, 23)
+
+			Convey("Then I should get the error and the socket should be dropped", func() {
+				So(errors.Is(err, syscall.EBADF), ShouldBeTrue)
+				So(handle.sock, ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestNetNS(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given I create a new handle for another network namespace", t, func() {
+		mockSyscalls := syscallwrappers.NewMockSyscalls(ctrl)
+		handle := NewHandle(WithNetNSPath("/var/run/netns/container")).(*Handles)
+		handle.Syscalls = mockSyscalls
+
+		Convey("When I update a mark", func() {
+			gomock.InOrder(
+				mockSyscalls.EXPECT().Open("/var/run/netns/container", syscall.O_RDONLY|syscall.O_CLOEXEC, uint32(0)).Times(1).Return(8, nil),
+				mockSyscalls.EXPECT().Open(gomock.Any(), syscall.O_RDONLY|syscall.O_CLOEXEC, uint32(0)).Times(1).Return(7, nil),
+				mockSyscalls.EXPECT().Setns(8, syscall.CLONE_NEWNET).Times(1).Return(nil),
+				mockSyscalls.EXPECT().Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_NETFILTER).Times(1).Return(5, nil),
+				mockSyscalls.EXPECT().Setns(7, syscall.CLONE_NEWNET).Times(1).Return(nil),
+				mockSyscalls.EXPECT().Close(7).Times(1),
+				mockSyscalls.EXPECT().Bind(5, gomock.Any()).Times(1).Return(nil),
+				mockSyscalls.EXPECT().Getsockname(5).Times(1).Return(&syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Pid: 100}, nil),
+				mockSyscalls.EXPECT().SetsockoptInt(5, common.SolNetlink, common.NetlinkExtAck, 1).Times(1),
+				mockSyscalls.EXPECT().SetsockoptInt(5, common.SolNetlink, common.NetlinkCapAck, 1).Times(1),
+				mockSyscalls.EXPECT().Close(8).Times(1),
+				mockSyscalls.EXPECT().Sendto(5, gomock.Any(), 0, gomock.Any()).Times(1).Return(nil),
+				mockSyscalls.EXPECT().Recvfrom(5, gomock.Any(), 0).DoAndReturn(nltest.RecvMessage(nltest.AckMessage(1, 100, 0))),
+			)
+			err := handle.ConntrackTableUpdateMark("127.0.0.1", "127.0.0.10", 17, 2000, 3000, 23)
+
+			Convey("Then the socket should be opened in the namespace", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+}
+
 func TestMark(t *testing.T) {
 
 	var mark int
@@ -159,3 +301,68 @@ func TestFlush(t *testing.T) {
 // 		})
 // 	})
 // }
+
+// putFlow appends the attributes of a TCP flow 10.0.0.1:40000 -> 192.0.2.10:80 DNATed to 172.17.0.2:8080
+func putFlow(b *common.AttrBuilder) {
+	putTuple := func(attrType uint16, src, dst net.IP, sport, dport uint16) {
+		b.BeginNested(attrType)
+		b.BeginNested(CTA_TUPLE_IP)
+		b.PutBytes(CTA_IP_V4_SRC, src.To4())
+		b.PutBytes(CTA_IP_V4_DST, dst.To4())
+		b.EndNested()
+		b.BeginNested(CTA_TUPLE_PROTO)
+		b.PutUint8(CTA_PROTO_NUM, syscall.IPPROTO_TCP)
+		b.PutUint16(CTA_PROTO_SRC_PORT, sport)
+		b.PutUint16(CTA_PROTO_DST_PORT, dport)
+		b.EndNested()
+		b.EndNested()
+	}
+	putTuple(CTA_TUPLE_ORIG, net.ParseIP("10.0.0.1"), net.ParseIP("192.0.2.10"), 40000, 80)
+	putTuple(CTA_TUPLE_REPLY, net.ParseIP("172.17.0.2"), net.ParseIP("10.0.0.1"), 8080, 40000)
+	b.PutUint32(CTA_ID, 1234)
+	b.PutUint32(CTA_STATUS, IPS_SEEN_REPLY|IPS_ASSURED|IPS_CONFIRMED|IPS_DST_NAT)
+	b.PutUint32(CTA_TIMEOUT, 431999)
+	b.PutUint32(CTA_MARK, 0x42)
+	b.PutUint16(CTA_ZONE, 7)
+	b.PutBytes(CTA_LABELS, []byte{0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+}
+
+func TestDecodeFlow(t *testing.T) {
+	Convey("Given the attributes of a DNATed flow", t, func() {
+		b := common.NewAttrBuilder(nil)
+		putFlow(b)
+
+		Convey("When I decode them", func() {
+			flow, err := DecodeFlow(b.Bytes())
+
+			Convey("Then I should get both tuples and the flow attributes", func() {
+				So(err, ShouldBeNil)
+				So(flow.Orig.SrcIP.String(), ShouldEqual, "10.0.0.1")
+				So(flow.Orig.DstIP.String(), ShouldEqual, "192.0.2.10")
+				So(flow.Orig.Protocol, ShouldEqual, syscall.IPPROTO_TCP)
+				So(flow.Orig.SrcPort, ShouldEqual, 40000)
+				So(flow.Orig.DstPort, ShouldEqual, 80)
+				So(flow.Reply.SrcIP.String(), ShouldEqual, "172.17.0.2")
+				So(flow.Reply.SrcPort, ShouldEqual, 8080)
+				So(flow.ID, ShouldEqual, 1234)
+				So(flow.Status&IPS_DST_NAT, ShouldNotBeZeroValue)
+				So(flow.Timeout, ShouldEqual, 431999)
+				So(flow.Mark, ShouldEqual, 0x42)
+				So(flow.Zone, ShouldEqual, 7)
+				So(flow.Labels, ShouldHaveLength, 16)
+				So(flow.Labels[0], ShouldEqual, 0x02)
+			})
+		})
+
+		Convey("When a nested address is too short", func() {
+			buf := b.Bytes()
+			// CTA_TUPLE_ORIG, CTA_TUPLE_IP, CTA_IP_V4_SRC: cut the address to 2 bytes
+			common.NativeEndian().PutUint16(buf[8:], 6)
+			_, err := DecodeFlow(buf)
+
+			Convey("Then I should get the error of the nested attribute", func() {
+				So(errors.Is(err, common.ErrAttrValueTooShort), ShouldBeTrue)
+			})
+		})
+	})
+}
@@ -43,6 +43,8 @@ const (
 	CTA_TIMEOUT     = 7
 	CTA_MARK        = 8
 	CTA_PROTOINFO   = 4
+	CTA_ID          = 12
+	CTA_ZONE        = 18
 	CTA_LABELS      = 22
 )
 
@@ -57,6 +59,7 @@ const (
 const (
 	CTA_TUPLE_IP    = 1
 	CTA_TUPLE_PROTO = 2
+	CTA_TUPLE_ZONE  = 3
 )
 
 // enum ctattr_ip {
@@ -90,9 +93,27 @@ const (
 // };
 // #define CTA_PROTO_MAX (__CTA_PROTO_MAX - 1)
 const (
-	CTA_PROTO_NUM      = 1
-	CTA_PROTO_SRC_PORT = 2
-	CTA_PROTO_DST_PORT = 3
+	CTA_PROTO_NUM         = 1
+	CTA_PROTO_SRC_PORT    = 2
+	CTA_PROTO_DST_PORT    = 3
+	CTA_PROTO_ICMP_ID     = 4
+	CTA_PROTO_ICMP_TYPE   = 5
+	CTA_PROTO_ICMP_CODE   = 6
+	CTA_PROTO_ICMPV6_ID   = 7
+	CTA_PROTO_ICMPV6_TYPE = 8
+	CTA_PROTO_ICMPV6_CODE = 9
+)
+
+// enum ip_conntrack_status, bits of CTA_STATUS
+const (
+	IPS_EXPECTED   = 1 << 0
+	IPS_SEEN_REPLY = 1 << 1
+	IPS_ASSURED    = 1 << 2
+	IPS_CONFIRMED  = 1 << 3
+	IPS_SRC_NAT    = 1 << 4
+	IPS_DST_NAT    = 1 << 5
+	IPS_DYING      = 1 << 9
+	IPS_OFFLOAD    = 1 << 14
 )
 
 // enum ctattr_protoinfo {
@@ -125,6 +146,7 @@ const (
 	CTA_PROTOINFO_TCP_FLAGS_REPLY     = 5
 )
 
+// Deprecated: nested attribute lengths are computed by common.AttrBuilder
 const (
 
 	//NOTE: THE BELOW VALUES ARE JUST FOR CHANGING MARK. IF NEEDED, THE SIZE HAS TO BE CHANGED WHEN ADDING NEW ATTRIBUTES
@@ -147,6 +169,7 @@ const (
 )
 
 // Padded attribute lengths
+// Deprecated: attribute lengths are computed by common.AttrBuilder
 const (
 	PROTO_NUM_LEN      = 5
 	PROTO_SRC_PORT_LEN = 6
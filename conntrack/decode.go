@@ -0,0 +1,135 @@
+// +build linux !darwin
+
+package conntrack
+
+import (
+	"net"
+
+	"go.aporeto.io/netlink-go/common"
+)
+
+// Flow is a conntrack entry decoded from its CTA_ attributes, as attached by nflog and nfqueue to packets
+// Orig -- tuple of the original direction, the addresses before NAT
+// Reply -- tuple of the reply direction, the addresses after NAT swapped
+// Status -- IPS_ bits
+// Labels -- connlabel bitmap
+type Flow struct {
+	Orig    Tuple
+	Reply   Tuple
+	ID      uint32
+	Status  uint32
+	Timeout uint32
+	Mark    uint32
+	Zone    uint16
+	Labels  []byte
+}
+
+// Tuple is one direction of a conntrack entry
+// ICMPID, ICMPType, ICMPCode -- set instead of the ports for ICMP and ICMPv6
+// Zone -- zone of the direction, 0 unless the zone is directional
+type Tuple struct {
+	SrcIP    net.IP
+	DstIP    net.IP
+	Protocol uint8
+	SrcPort  uint16
+	DstPort  uint16
+	ICMPID   uint16
+	ICMPType uint8
+	ICMPCode uint8
+	Zone     uint16
+}
+
+// DecodeFlow decodes the CTA_ attributes of a conntrack entry
+// Attributes which are not known are skipped, a malformed attribute returns the error of common.AttrDecoder
+func DecodeFlow(attrs []byte) (*Flow, error) {
+	flow := &Flow{}
+
+	d := common.NewAttrDecoder(attrs)
+	for d.Next() {
+		switch d.Type() {
+		case CTA_TUPLE_ORIG:
+			decodeTuple(d.Nested(), &flow.Orig, &d)
+		case CTA_TUPLE_REPLY:
+			decodeTuple(d.Nested(), &flow.Reply, &d)
+		case CTA_ID:
+			flow.ID = d.Uint32()
+		case CTA_STATUS:
+			flow.Status = d.Uint32()
+		case CTA_TIMEOUT:
+			flow.Timeout = d.Uint32()
+		case CTA_MARK:
+			flow.Mark = d.Uint32()
+		case CTA_ZONE:
+			flow.Zone = d.Uint16()
+		case CTA_LABELS:
+			flow.Labels = append([]byte(nil), d.Bytes()...)
+		}
+	}
+	if err := d.Err(); err != nil {
+		return nil, err
+	}
+
+	return flow, nil
+}
+
+// decodeTuple decodes a CTA_TUPLE_ORIG or CTA_TUPLE_REPLY attribute
+// parent -- decoder of the tuple attribute, the error of the nested attributes is reported on it
+func decodeTuple(d common.AttrDecoder, t *Tuple, parent *common.AttrDecoder) {
+	for d.Next() {
+		switch d.Type() {
+		case CTA_TUPLE_IP:
+			decodeTupleIP(d.Nested(), t, &d)
+		case CTA_TUPLE_PROTO:
+			decodeTupleProto(d.Nested(), t, &d)
+		case CTA_TUPLE_ZONE:
+			t.Zone = d.Uint16()
+		}
+	}
+	parent.SetErr(d.Err())
+}
+
+// decodeTupleIP decodes a CTA_TUPLE_IP attribute
+func decodeTupleIP(d common.AttrDecoder, t *Tuple, parent *common.AttrDecoder) {
+	for d.Next() {
+		switch d.Type() {
+		case CTA_IP_V4_SRC:
+			t.SrcIP = ipAttr(&d, net.IPv4len)
+		case CTA_IP_V4_DST:
+			t.DstIP = ipAttr(&d, net.IPv4len)
+		case CTA_IP_V6_SRC:
+			t.SrcIP = ipAttr(&d, net.IPv6len)
+		case CTA_IP_V6_DST:
+			t.DstIP = ipAttr(&d, net.IPv6len)
+		}
+	}
+	parent.SetErr(d.Err())
+}
+
+// decodeTupleProto decodes a CTA_TUPLE_PROTO attribute
+func decodeTupleProto(d common.AttrDecoder, t *Tuple, parent *common.AttrDecoder) {
+	for d.Next() {
+		switch d.Type() {
+		case CTA_PROTO_NUM:
+			t.Protocol = d.Uint8()
+		case CTA_PROTO_SRC_PORT:
+			t.SrcPort = d.Uint16()
+		case CTA_PROTO_DST_PORT:
+			t.DstPort = d.Uint16()
+		case CTA_PROTO_ICMP_ID, CTA_PROTO_ICMPV6_ID:
+			t.ICMPID = d.Uint16()
+		case CTA_PROTO_ICMP_TYPE, CTA_PROTO_ICMPV6_TYPE:
+			t.ICMPType = d.Uint8()
+		case CTA_PROTO_ICMP_CODE, CTA_PROTO_ICMPV6_CODE:
+			t.ICMPCode = d.Uint8()
+		}
+	}
+	parent.SetErr(d.Err())
+}
+
+// ipAttr returns a copy of the address in the current attribute, nil if it is too short
+func ipAttr(d *common.AttrDecoder, size int) net.IP {
+	if b := d.Struct(size); b != nil {
+		return append(net.IP(nil), b[:size]...)
+	}
+	return nil
+}
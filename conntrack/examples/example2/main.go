@@ -13,6 +13,7 @@ func main() {
 	runtime.LockOSThread()
 
 	handle := conntrack.NewHandle()
+	defer handle.Close() // nolint
 
 	if err := conntrack.UDPFlowCreate(5, 2000, "127.0.0.10", 3000); err != nil {
 		log.Println(err)
@@ -3,9 +3,8 @@
 package conntrack
 
 import (
-	"syscall"
-
 	"github.com/vishvananda/netlink"
+	"go.aporeto.io/netlink-go/common"
 )
 
 // Conntrack interface has Conntrack manipulations (get/set/flush)
@@ -20,15 +19,9 @@ type Conntrack interface {
 	ConntrackTableUpdateMark(ipSrc, ipDst string, protonum uint8, srcport, dstport uint16, newmark uint32) error
 	// ConntrackTableUpdateLabel is used to update conntrack label attribute in the kernel
 	ConntrackTableUpdateLabel(table netlink.ConntrackTableType, flows []*netlink.ConntrackFlow, ipSrc, ipDst string, protonum uint8, srcport, dstport uint16, newlabels uint32) (int, error)
+	// Close is used to close the netlink socket held by the handle
+	Close() error
 }
 
-// SockHandle Opaque interface with unexported functions
-type SockHandle interface {
-	query(msg *syscall.NetlinkMessage) error
-	recv() error
-	send(msg *syscall.NetlinkMessage) error
-	getFd() int
-	getRcvBufSize() uint32
-	getLocalAddress() syscall.SockaddrNetlink
-	close()
-}
+// SockHandle -- Netlink socket used by the handle, shared with the other subsystems
+type SockHandle = common.SockHandle
@@ -9,9 +9,13 @@ type Conntrack interface {
 	ConntrackTableUpdateMarkForAvailableFlow(flows []*interface{}, ipSrc, ipDst string, protonum uint8, srcport, dstport uint16, newmark uint32) (int, error)
 	ConntrackTableUpdateMark(ipSrc, ipDst string, protonum uint8, srcport, dstport uint16, newmark uint32) error
 	ConntrackTableUpdateLabel(table interface{}, flows []*interface{}, ipSrc, ipDst string, protonum uint8, srcport, dstport uint16, newlabels uint32) (int, error)
+	Close() error
 }
 
+// Option -- Option of a conntrack handle
+type Option func(interface{})
+
 // NewHandle which returns interface which implements Conntrack table get/set/flush
-func NewHandle() Conntrack {
+func NewHandle(opts ...Option) Conntrack {
 	return nil
 }
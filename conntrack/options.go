@@ -0,0 +1,56 @@
+// +build linux !darwin
+
+package conntrack
+
+import (
+	"fmt"
+
+	"go.aporeto.io/netlink-go/common"
+)
+
+// Option -- Option of a conntrack handle, passed to NewHandle
+type Option func(*Handles)
+
+// WithNetNS -- Run the requests of the handle in the network namespace referred to by fd
+// The caller keeps ownership of the fd, it must stay open as long as the handle is used
+func WithNetNS(fd int) Option {
+	return func(h *Handles) {
+		h.sockOpts = append(h.sockOpts, common.WithNetNS(fd))
+		h.netnsPath = fmt.Sprintf("/proc/self/fd/%d", fd)
+	}
+}
+
+// WithNetNSPath -- Run the requests of the handle in the network namespace bound at path,
+// as /var/run/netns/name or /proc/pid/ns/net
+func WithNetNSPath(path string) Option {
+	return func(h *Handles) {
+		h.sockOpts = append(h.sockOpts, common.WithNetNSPath(path))
+		h.netnsPath = path
+	}
+}
+
+// WithRcvBufSize -- Size of the socket receive buffer, the kernel default otherwise
+// force -- use SO_RCVBUFFORCE to go over rmem_max, needs CAP_NET_ADMIN
+func WithRcvBufSize(size int, force bool) Option {
+	return func(h *Handles) {
+		h.sockOpts = append(h.sockOpts, common.WithRcvBufSize(size, force))
+	}
+}
+
+// WithNoENOBUFS -- Set or clear NETLINK_NO_ENOBUFS, cleared by default
+func WithNoENOBUFS(enable bool) Option {
+	return func(h *Handles) {
+		h.sockOpts = append(h.sockOpts, common.WithNoENOBUFS(enable))
+	}
+}
+
+// WithENOBUFSPolicy -- What the handle does when a reply is lost to ENOBUFS. Whatever the policy
+// the request is not sent again, the kernel may have applied it, and the error is returned.
+// The policy decides if the overflow is counted or resynced
+// resync -- called with the common.ENOBUFSResync policy, may be nil
+func WithENOBUFSPolicy(policy common.ENOBUFSPolicy, resync func()) Option {
+	return func(h *Handles) {
+		h.enobufs.Policy = policy
+		h.enobufs.Resync = resync
+	}
+}
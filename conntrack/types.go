@@ -3,26 +3,32 @@
 package conntrack
 
 import (
-	"syscall"
+	"sync"
 
+	"github.com/vishvananda/netlink"
+	"go.aporeto.io/netlink-go/common"
 	"go.aporeto.io/netlink-go/common/syscallwrappers"
 )
 
 //SockHandles -- Sock handle of netlink socket
-//fd -- fd of socket
-//rcvbufSize -- rcv buffer Size
-//lsa -- local address
-type SockHandles struct {
-	Syscalls   syscallwrappers.Syscalls
-	fd         int
-	rcvbufSize uint32
-	buf        []byte
-	lsa        syscall.SockaddrNetlink
-}
+//Deprecated: the handle uses the netlink socket shared by all the subsystems, common.Socket
+type SockHandles = common.Socket
 
 //Handles -- Handle for Conntrack table manipulations (get/set)
-//SockHandles --  Sock handle of netlink socket
+//Syscalls -- syscall wrappers used to open the netlink socket
+//sock -- long lived netlink socket, opened on first use and reopened on failure
+//sockOpts -- options the socket is opened with
+//netnsPath -- network namespace the requests run in, empty for the current one
+//nl -- handle used to list and flush the table in that namespace, created on first use
+//enobufs -- policy applied when a reply is lost to ENOBUFS
+//mu -- serializes the requests on the handle
+//Requests on the handle are serialized, so it is safe for concurrent use
 type Handles struct {
-	Syscalls syscallwrappers.Syscalls
-	SockHandles
+	Syscalls  syscallwrappers.Syscalls
+	sock      SockHandle
+	sockOpts  []common.SocketOption
+	netnsPath string
+	nl        *netlink.Handle
+	enobufs   common.ENOBUFSHandler
+	mu        sync.Mutex
 }
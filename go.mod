@@ -7,8 +7,10 @@ require (
 	github.com/smartystreets/goconvey v1.6.4
 	github.com/stretchr/testify v1.5.1
 	github.com/vishvananda/netlink v1.1.0
+	github.com/vishvananda/netns v0.0.0-20191106174202-0a2b9b5464df
 	go.uber.org/zap v1.14.1
 	golang.org/x/net v0.0.0-20190620200207-3b0461eec859
+	golang.org/x/sys v0.0.0-20190606203320-7fc4e5ec1444
 )
 
 replace github.com/vulcand/oxy => github.com/aporeto-inc/oxy v1.3.1-0.20200314064302-4c2778768cee
@@ -21,6 +21,13 @@ const (
 	NFULNL_CFG_CMD_PF_UNBIND
 )
 
+// nfulnl config flags
+const (
+	NFULNL_CFG_F_SEQ        = 0x0001
+	NFULNL_CFG_F_SEQ_GLOBAL = 0x0002
+	NFULNL_CFG_F_CONNTRACK  = 0x0004
+)
+
 const (
 	NFULNL_COPY_NONE = iota
 	NFULNL_COPY_META
@@ -64,9 +71,33 @@ const (
 const (
 	SizeofMsgConfigCommand = 0x4
 
+	// nfulnl_msg_packet_hdr: __be16 hw_protocol, __u8 hook, __u8 _pad
+	sizeofPacketHdr = 0x4
+	// nfulnl_msg_packet_timestamp: __aligned_be64 sec, __aligned_be64 usec
+	sizeofPacketTimestamp = 0x10
+	// nfulnl_msg_packet_hw: __be16 hw_addrlen, __u16 _pad, __u8 hw_addr[8]
+	sizeofPacketHw = 0xc
+
 	SizeofMsgConfigMode uint32 = uint32(unsafe.Sizeof(NflMsgConfigMode{}))
 )
 
 const (
-	IPVersion = 4
+	IPVersion   = 4
+	IPv6Version = 6
+)
+
+// ethertypes of the NFULA_PACKET_HDR of bridged packets
+const (
+	ethPIP   = 0x0800
+	ethPIPv6 = 0x86dd
+)
+
+// enum ip_conntrack_info, values of NFULA_CT_INFO
+const (
+	IP_CT_ESTABLISHED       = 0
+	IP_CT_RELATED           = 1
+	IP_CT_NEW               = 2
+	IP_CT_IS_REPLY          = 3
+	IP_CT_ESTABLISHED_REPLY = IP_CT_ESTABLISHED + IP_CT_IS_REPLY
+	IP_CT_RELATED_REPLY     = IP_CT_RELATED + IP_CT_IS_REPLY
 )
@@ -0,0 +1,138 @@
+// +build linux !darwin
+
+package nflog
+
+import (
+	"errors"
+	"syscall"
+)
+
+// errReaderStopped -- returned to the requests whose ACK was still expected when ReadLogs stopped
+var errReaderStopped = errors.New("nflog reader stopped")
+
+// AddGroup -- Bind group on an open handle, ReadLogs may be running
+// While the reader reconnects the group is added once the socket is back.
+// The copy mode of NFlogSetMode and the configuration of the options are applied to the group.
+// callback -- called for the packets of the group, the callback of NFlogBindGroup if nil
+func (nl *NfLog) AddGroup(group uint16, callback func(*NfPacket, interface{})) error {
+	nl.configLock.Lock()
+	defer nl.configLock.Unlock()
+
+	if err := nl.bindGroup(group); err != nil {
+		return err
+	}
+
+	nl.groupsLock.Lock()
+	copyRange, modeSet := nl.copyRange, nl.modeSet
+	nl.groupsLock.Unlock()
+
+	var err error
+	if modeSet {
+		err = nl.NFlogSetMode([]uint16{group}, copyRange)
+	}
+	if err == nil {
+		err = nl.setConfig([]uint16{group})
+	}
+	if err != nil {
+		nl.unbindGroup(group) // nolint
+		return err
+	}
+
+	nl.setGroupCallback(group, callback)
+	return nil
+}
+
+// RemoveGroup -- Unbind group, the kernel stops logging its packets to the handle
+func (nl *NfLog) RemoveGroup(group uint16) error {
+	nl.configLock.Lock()
+	defer nl.configLock.Unlock()
+
+	if err := nl.unbindGroup(group); err != nil {
+		return err
+	}
+
+	nl.groupsLock.Lock()
+	delete(nl.groups, group)
+	nl.groupsLock.Unlock()
+
+	return nil
+}
+
+// BoundGroups -- Groups bound by NFlogBindGroup and AddGroup and not removed
+func (nl *NfLog) BoundGroups() []uint16 {
+	nl.groupsLock.RLock()
+	defer nl.groupsLock.RUnlock()
+
+	groups := make([]uint16, 0, len(nl.groups))
+	for g := range nl.groups {
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+// unbindGroup -- Send NFULNL_CFG_CMD_UNBIND for group
+func (nl *NfLog) unbindGroup(group uint16) error {
+	config := &NflMsgConfigCommand{
+		command: NFULNL_CFG_CMD_UNBIND,
+	}
+
+	return nl.sendConfig(syscall.AF_UNSPEC, group, NFULA_CFG_CMD, config.ToWireFormat())
+}
+
+// setGroupCallback -- Record group as bound with its callback, nil for the callback of the handle
+func (nl *NfLog) setGroupCallback(group uint16, callback func(*NfPacket, interface{})) {
+	nl.groupsLock.Lock()
+	defer nl.groupsLock.Unlock()
+
+	if nl.groups == nil {
+		nl.groups = map[uint16]func(*NfPacket, interface{}){}
+	}
+	nl.groups[group] = callback
+}
+
+// groupCallback -- Callback of the packets of group
+func (nl *NfLog) groupCallback(group uint16) func(*NfPacket, interface{}) {
+	nl.groupsLock.RLock()
+	defer nl.groupsLock.RUnlock()
+
+	if callback := nl.groups[group]; callback != nil {
+		return callback
+	}
+	return nl.callback
+}
+
+// startReading -- From now on the ACKs of the requests are read by ReadLogs
+func (nl *NfLog) startReading() {
+	nl.groupsLock.Lock()
+	defer nl.groupsLock.Unlock()
+
+	nl.reading = true
+	nl.pending = map[uint32]chan error{}
+}
+
+// stopReading -- Fail the requests still waiting for their ACK, the next ones read it themselves
+func (nl *NfLog) stopReading() {
+	nl.groupsLock.Lock()
+	defer nl.groupsLock.Unlock()
+
+	nl.reading = false
+	for seq, ack := range nl.pending {
+		ack <- errReaderStopped
+		delete(nl.pending, seq)
+	}
+}
+
+// ackRequest -- Hand the reply of a request sent while ReadLogs runs to its sender
+// Returns false if no request with this sequence number waits for a reply
+func (nl *NfLog) ackRequest(seq uint32, err error) bool {
+	nl.groupsLock.Lock()
+	defer nl.groupsLock.Unlock()
+
+	ack, ok := nl.pending[seq]
+	if !ok {
+		return false
+	}
+	delete(nl.pending, seq)
+	ack <- err
+	return true
+}
@@ -2,7 +2,12 @@
 
 package nflog
 
-import "syscall"
+import (
+	"context"
+	"time"
+
+	"go.aporeto.io/netlink-go/common"
+)
 
 // NFLog -- This is the interface which has all the necessary functions to read logs from kernel
 // This is needed if we don't want to call BindAndListenForLogs()
@@ -13,19 +18,21 @@ type NFLog interface {
 	NFlogBind() error
 	NFlogBindGroup(group []uint16, data func(packet *NfPacket, callback interface{}), errorCallback func(err error)) error
 	NFlogSetMode(groups []uint16, copyrange uint32) error
+	NFlogSetNlBufSiz(groups []uint16, size uint32) error
+	NFlogSetTimeout(groups []uint16, timeout time.Duration) error
+	NFlogSetQThresh(groups []uint16, qthresh uint32) error
+	NFlogSetFlags(groups []uint16, flags uint16) error
+	AddGroup(group uint16, callback func(*NfPacket, interface{})) error
+	RemoveGroup(group uint16) error
+	BoundGroups() []uint16
 	ReadLogs()
+	Run(ctx context.Context) error
+	Wait() error
 	NFlogClose()
+	ENOBUFSCount() uint64
 	parseLog(buf []byte) error
 	parsePacket(buffer []byte) error
 }
 
-// SockHandle Opaque interface with unexported functions
-type SockHandle interface {
-	query(msg *syscall.NetlinkMessage) error
-	recv() error
-	send(msg *syscall.NetlinkMessage) error
-	getFd() int
-	getRcvBufSize() uint32
-	getLocalAddress() syscall.SockaddrNetlink
-	close()
-}
+// SockHandle -- Netlink socket used by the log, shared with the other subsystems
+type SockHandle = common.SockHandle
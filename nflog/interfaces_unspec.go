@@ -2,6 +2,11 @@
 
 package nflog
 
+import (
+	"context"
+	"time"
+)
+
 // NFLog -- This is the interface which has all the necessary functions to read logs from kernel
 // This is needed if we don't want to call BindAndListenForLogs()
 // Useful for testing and debugging
@@ -11,13 +16,25 @@ type NFLog interface {
 	NFlogBind() error
 	NFlogBindGroup(group []uint16, data func(packet *NfPacket, callback interface{}), errorCallback func(err error)) error
 	NFlogSetMode(groups []uint16, copyrange uint32) error
+	NFlogSetNlBufSiz(groups []uint16, size uint32) error
+	NFlogSetTimeout(groups []uint16, timeout time.Duration) error
+	NFlogSetQThresh(groups []uint16, qthresh uint32) error
+	NFlogSetFlags(groups []uint16, flags uint16) error
+	AddGroup(group uint16, callback func(*NfPacket, interface{})) error
+	RemoveGroup(group uint16) error
+	BoundGroups() []uint16
 	ReadLogs()
+	Run(ctx context.Context) error
+	Wait() error
 	NFlogClose()
 	parseLog(buf []byte) error
 	parsePacket(buffer []byte) error
 }
 
+// Option -- Option of a nflog handle
+type Option func(interface{})
+
 // NewNFLog -- Create a new Nflog handle
-func NewNFLog() NFLog {
+func NewNFLog(opts ...Option) NFLog {
 	return nil
 }
@@ -3,45 +3,110 @@
 package nflog
 
 import (
-	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
-	"io"
+	"net"
+	"strconv"
 	"syscall"
+	"time"
 
 	"go.aporeto.io/netlink-go/common"
 	"go.aporeto.io/netlink-go/common/packet"
 	"go.aporeto.io/netlink-go/common/syscallwrappers"
+	"go.aporeto.io/netlink-go/conntrack"
+)
+
+var (
+	// ErrPayloadTruncated is returned when a logged packet is shorter than its IP header
+	// Netlink messages and attributes which are cut short return common.ErrMsgTruncated,
+	// common.ErrAttrTruncated or common.ErrAttrValueTooShort
+	ErrPayloadTruncated = packet.ErrIPTruncated
+	// ErrPayloadMalformed is returned when the IP header of a logged packet is invalid
+	ErrPayloadMalformed = packet.ErrIPMalformed
+	// ErrNlBufSizTooLarge is returned by NFlogSetNlBufSiz when the datagrams would not fit in the read
+	// buffers, the handle must be created with a WithNlBufSiz at least as large
+	ErrNlBufSizTooLarge = errors.New("nflog nlbufsiz larger than the read buffers")
 )
 
 // NewNFLog -- Create a new Nflog handle
-func NewNFLog() NFLog {
+// opts -- options applied to the handle, e.g. WithNetNS to open the socket in another network namespace
+func NewNFLog(opts ...Option) NFLog {
+	return newNFLog(opts...)
+}
+
+// newNFLog -- Create a new Nflog handle
+func newNFLog(opts ...Option) *NfLog {
 	n := &NfLog{Syscalls: syscallwrappers.NewSyscalls()}
+	for _, opt := range opts {
+		opt(n)
+	}
+
+	// A datagram holds up to nlbufsiz bytes of logs, it must fit in the read buffers
+	if n.nlbufsiz > common.NfnlBuffSize {
+		n.sockOpts = append(n.sockOpts, common.WithReadBuffer(make([]byte, n.nlbufsiz)))
+		if n.batch != nil {
+			n.batch = common.NewRecvBatch(n.batch.Cap(), int(n.nlbufsiz))
+		}
+	}
 	return n
 }
 
+// readBufSize -- Size of the buffers the datagrams are read in, grown by WithNlBufSiz
+func (nl *NfLog) readBufSize() uint32 {
+	if nl.nlbufsiz > common.NfnlBuffSize {
+		return nl.nlbufsiz
+	}
+	return common.NfnlBuffSize
+}
+
 // BindAndListenForLogs -- a complete set to open/unbind/bind/bindgroup and listen for logs
 // group -- group to bind with and listen
 // packetSize -- max expected packetSize (0:unlimited)
-func BindAndListenForLogs(groups []uint16, packetSize uint32, callback func(*NfPacket, interface{}), errorCallback func(err error)) (NFLog, error) {
-	nflHandle := NewNFLog()
+// opts -- options applied to the handle
+func BindAndListenForLogs(groups []uint16, packetSize uint32, callback func(*NfPacket, interface{}), errorCallback func(err error), opts ...Option) (NFLog, error) {
+	return BindAndListenForLogsWithContext(context.Background(), groups, packetSize, callback, errorCallback, opts...)
+}
 
-	nflog, err := nflHandle.NFlogOpen()
+// BindAndListenForLogsWithContext -- BindAndListenForLogs reading the logs until ctx is done
+// Wait on the returned handle returns once the reader stopped, with the error which stopped it.
+// With WithReconnect the reader reopens the socket and binds the groups again after a socket error.
+// With WithFamilies every family is bound before the groups.
+func BindAndListenForLogsWithContext(ctx context.Context, groups []uint16, packetSize uint32, callback func(*NfPacket, interface{}), errorCallback func(err error), opts ...Option) (NFLog, error) {
+	return newNFLog(opts...).bindAndListen(ctx, groups, packetSize, callback, errorCallback)
+}
+
+// bindAndListen -- BindAndListenForLogsWithContext on a handle created with the options
+func (nl *NfLog) bindAndListen(ctx context.Context, groups []uint16, packetSize uint32, callback func(*NfPacket, interface{}), errorCallback func(err error)) (NFLog, error) {
+	nflog, err := nl.NFlogOpen()
 	if err != nil {
-		return nil, fmt.Errorf("Error opening NFLog handle: %v ", err)
+		return nil, fmt.Errorf("Error opening NFLog handle: %w ", err)
+	}
+
+	if len(nl.families) > 0 {
+		if err := nl.NFlogBind(); err != nil {
+			nl.NFlogClose()
+			return nil, fmt.Errorf("Error binding to the protocol families: %w ", err)
+		}
+	}
+
+	if err := nl.NFlogBindGroup(groups, callback, errorCallback); err != nil {
+		nl.NFlogClose()
+		return nil, fmt.Errorf("Error binding to nflog group: %w ", err)
 	}
 
-	if err := nflHandle.NFlogBindGroup(groups, callback, errorCallback); err != nil {
-		nflHandle.NFlogClose()
-		return nil, fmt.Errorf("Error binding to nflog group: %v ", err)
+	if err := nl.NFlogSetMode(groups, packetSize); err != nil {
+		nl.NFlogClose()
+		return nil, fmt.Errorf("Unable to set copy packet mode: %w ", err)
 	}
 
-	if err := nflHandle.NFlogSetMode(groups, packetSize); err != nil {
-		nflHandle.NFlogClose()
-		return nil, fmt.Errorf("Unable to set copy packet mode: %v ", err)
+	if err := nl.setConfig(groups); err != nil {
+		nl.NFlogClose()
+		return nil, fmt.Errorf("Unable to configure nflog groups: %w ", err)
 	}
 
-	go nflHandle.ReadLogs()
+	nl.start(ctx)
 	return nflog, nil
 }
 
@@ -50,281 +115,414 @@ func BindAndListenForLogs(groups []uint16, packetSize uint32, callback func(*NfP
 // Open a new socket and return it in the NflogHandle.
 // The fd for the socket is stored in an unexported handle
 func (nl *NfLog) NFlogOpen() (NFLog, error) {
-	sh := &SockHandles{Syscalls: nl.Syscalls}
-	fd, err := nl.Syscalls.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_NETFILTER)
-	if err != nil {
-		return nil, err
-	}
-	sh.fd = fd
-	sh.rcvbufSize = common.NfnlBuffSize
-	sh.lsa.Family = syscall.AF_NETLINK
-
-	err = nl.Syscalls.Bind(fd, &sh.lsa)
-	if err != nil {
-		return nil, err
-	}
 	// when the traffic is high its easy to get the ENOBUFS as the socket buffer size max is 200kb.
 	// ignore them unless we want to increase the buffer size for logging.
 	// the only down side is we are ignoring logs but with this error anyway we don't log.
-	opt := 1
-	nl.Syscalls.SetsockoptInt(fd, common.SolNetlink, syscall.NETLINK_NO_ENOBUFS, opt)
+	opts := append([]common.SocketOption{common.WithNoENOBUFS(true)}, nl.sockOpts...)
+	sh, err := common.NewSocket(nl.Syscalls, opts...)
+	if err != nil {
+		return nil, err
+	}
 
+	nl.groupsLock.Lock()
 	nl.Socket = sh
+	nl.groupsLock.Unlock()
 	nl.NflogHandle = nl
 
 	return nl.NflogHandle, nil
 }
 
-// NFlogUnbind -- passes an unbind command to nfnetlink for AF_INET.
+// NFlogUnbind -- passes an unbind command to nfnetlink for every family of the handle, AF_INET by default.
 func (nl *NfLog) NFlogUnbind() error {
 
 	config := &NflMsgConfigCommand{
 		command: NFULNL_CFG_CMD_PF_UNBIND,
 	}
 
-	hdr := common.BuildNlMsgHeader(common.NfnlNFLog,
-		common.NlmFRequest|common.NlmFAck,
-		0,
-	)
-
-	nfgen := common.BuildNfgenMsg(syscall.AF_INET, common.NFNetlinkV0, 0, hdr)
-	attr := common.BuildNfAttrMsg(NFULA_CFG_CMD, hdr, config.Length())
-	data := nfgen.ToWireFormat()
-	data = append(data, attr.ToWireFormat()...)
-	data = append(data, config.ToWireFormat()...)
-
-	netlinkMsg := &syscall.NetlinkMessage{
-		Header: *hdr,
-		Data:   data,
-	}
-
-	if nl.Socket != nil {
-		return nl.Socket.query(netlinkMsg)
+	for _, family := range nl.getFamilies() {
+		if err := nl.sendConfig(family, 0, NFULA_CFG_CMD, config.ToWireFormat()); err != nil {
+			return err
+		}
 	}
 
-	return fmt.Errorf("NFlogOpen was not called. No Socket open")
+	return nil
 }
 
-// NFlogBind -- Bind to a PF family
+// NFlogBind -- Bind to every PF family of the handle, AF_INET by default
 func (nl *NfLog) NFlogBind() error {
 
 	config := &NflMsgConfigCommand{
 		command: NFULNL_CFG_CMD_PF_BIND,
 	}
 
-	hdr := common.BuildNlMsgHeader(common.NfnlNFLog,
-		common.NlmFRequest|common.NlmFAck,
-		0,
-	)
-
-	nfgen := common.BuildNfgenMsg(syscall.AF_INET, common.NFNetlinkV0, 0, hdr)
-	attr := common.BuildNfAttrMsg(NFULA_CFG_CMD, hdr, config.Length())
-	data := nfgen.ToWireFormat()
-	data = append(data, attr.ToWireFormat()...)
-	data = append(data, config.ToWireFormat()...)
-
-	netlinkMsg := &syscall.NetlinkMessage{
-		Header: *hdr,
-		Data:   data,
-	}
-
-	if nl.Socket != nil {
-		return nl.Socket.query(netlinkMsg)
+	for _, family := range nl.getFamilies() {
+		if err := nl.sendConfig(family, 0, NFULA_CFG_CMD, config.ToWireFormat()); err != nil {
+			return err
+		}
 	}
 
-	return fmt.Errorf("NFlogOpen was not called. No Socket open")
+	nl.pfBound = true
+	return nil
 }
 
 // NFlogBindGroup -- Bind to a group
 // group -- group to bind with
+// A group is bound once whatever the families logging to it, the bind is sent with the first family
+// of the handle so the kernel loads its logger.
 func (nl *NfLog) NFlogBindGroup(groups []uint16, callback func(*NfPacket, interface{}), errorCallback func(err error)) error {
 
 	nl.callback = callback
 	nl.errorCallback = errorCallback
 
 	for _, g := range groups {
-		config := &NflMsgConfigCommand{
-			command: NFULNL_CFG_CMD_BIND,
+		if err := nl.bindGroup(g); err != nil {
+			return err
 		}
+		nl.setGroupCallback(g, nil)
+	}
 
-		hdr := common.BuildNlMsgHeader(common.NfnlNFLog,
-			common.NlmFRequest|common.NlmFAck,
-			0,
-		)
+	return nil
+}
 
-		nfgen := common.BuildNfgenMsg(syscall.AF_INET, common.NFNetlinkV0, g, hdr)
-		attr := common.BuildNfAttrMsg(NFULA_CFG_CMD, hdr, config.Length())
-		data := nfgen.ToWireFormat()
-		data = append(data, attr.ToWireFormat()...)
-		data = append(data, config.ToWireFormat()...)
+// bindGroup -- Send NFULNL_CFG_CMD_BIND for group
+func (nl *NfLog) bindGroup(group uint16) error {
+	config := &NflMsgConfigCommand{
+		command: NFULNL_CFG_CMD_BIND,
+	}
 
-		netlinkMsg := &syscall.NetlinkMessage{
-			Header: *hdr,
-			Data:   data,
-		}
+	return nl.sendConfig(nl.getFamilies()[0], group, NFULA_CFG_CMD, config.ToWireFormat())
+}
 
-		if nl.Socket != nil {
-			err := nl.Socket.query(netlinkMsg)
-			if err != nil {
-				return err
-			}
-		}
+// getFamilies -- families the handle binds for
+func (nl *NfLog) getFamilies() []uint8 {
+	if len(nl.families) == 0 {
+		return []uint8{syscall.AF_INET}
 	}
-
-	return nil
+	return nl.families
 }
 
 // NFlogSetMode -- Set queue mode CopyMeta
 // packetSize -- The range of bytes from packets to copy
 func (nl *NfLog) NFlogSetMode(groups []uint16, packetSize uint32) error {
 
+	nl.groupsLock.Lock()
+	nl.copyRange = packetSize
+	nl.modeSet = true
+	nl.groupsLock.Unlock()
+
 	for _, g := range groups {
 		config := &NflMsgConfigMode{
 			copyMode:  NFULNL_COPY_PACKET,
 			copyRange: packetSize,
 		}
 
-		hdr := common.BuildNlMsgHeader(common.NfnlNFLog,
-			common.NlmFRequest|common.NlmFAck,
-			0,
-		)
+		if err := nl.sendConfig(syscall.AF_UNSPEC, g, NFULA_CFG_MODE, config.ToWireFormat()); err != nil {
+			return err
+		}
+	}
 
-		nfgen := common.BuildNfgenMsg(syscall.AF_UNSPEC, common.NFNetlinkV0, g, hdr)
-		attr := common.BuildNfAttrMsg(NFULA_CFG_MODE, hdr, config.Length())
-		data := nfgen.ToWireFormat()
-		data = append(data, attr.ToWireFormat()...)
-		data = append(data, config.ToWireFormat()...)
+	return nil
+}
 
-		netlinkMsg := &syscall.NetlinkMessage{
-			Header: *hdr,
-			Data:   data,
-		}
+// NFlogSetNlBufSiz -- Size of the datagrams the kernel batches logs in, 4096 bytes by default
+// Sizes over 131072 are capped by the kernel. The read buffers are not grown: sizes larger than
+// common.NfnlBuffSize return ErrNlBufSizTooLarge unless the handle was created with WithNlBufSiz
+func (nl *NfLog) NFlogSetNlBufSiz(groups []uint16, size uint32) error {
+	if size > nl.readBufSize() {
+		return fmt.Errorf("%w: %d bytes, read buffers of %d", ErrNlBufSizTooLarge, size, nl.readBufSize())
+	}
+	return nl.sendGroupsUint32(groups, NFULA_CFG_NLBUFSIZ, size)
+}
 
-		if nl.Socket != nil {
-			err := nl.Socket.query(netlinkMsg)
-			if err != nil {
-				return err
-			}
+// NFlogSetTimeout -- Longest time a log is held in the kernel before the datagram is sent, 1s by default
+// timeout -- rounded down to the 1/100 s the kernel counts in
+func (nl *NfLog) NFlogSetTimeout(groups []uint16, timeout time.Duration) error {
+	return nl.sendGroupsUint32(groups, NFULA_CFG_TIMEOUT, uint32(timeout/(10*time.Millisecond)))
+}
+
+// NFlogSetQThresh -- Number of logs batched in a datagram before it is sent, 100 by default
+func (nl *NfLog) NFlogSetQThresh(groups []uint16, qthresh uint32) error {
+	return nl.sendGroupsUint32(groups, NFULA_CFG_QTHRESH, qthresh)
+}
+
+// NFlogSetFlags -- Set the NFULNL_CFG_F flags of the groups
+// flags -- NFULNL_CFG_F_SEQ, NFULNL_CFG_F_SEQ_GLOBAL and NFULNL_CFG_F_CONNTRACK, 0 clears them
+func (nl *NfLog) NFlogSetFlags(groups []uint16, flags uint16) error {
+	data := make([]byte, 2)
+	binary.BigEndian.PutUint16(data, flags)
+
+	for _, g := range groups {
+		if err := nl.sendConfig(syscall.AF_UNSPEC, g, NFULA_CFG_FLAGS, data); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-// ReadLogs -- Listen for logs on the current socket
-func (nl *NfLog) ReadLogs() {
+// setConfig -- Send the configuration set by the options, the kernel defaults are kept otherwise
+func (nl *NfLog) setConfig(groups []uint16) error {
+	if nl.nlbufsiz != 0 {
+		if err := nl.NFlogSetNlBufSiz(groups, nl.nlbufsiz); err != nil {
+			return err
+		}
+	}
+	if nl.timeout != 0 {
+		if err := nl.NFlogSetTimeout(groups, nl.timeout); err != nil {
+			return err
+		}
+	}
+	if nl.qthresh != 0 {
+		if err := nl.NFlogSetQThresh(groups, nl.qthresh); err != nil {
+			return err
+		}
+	}
+	if nl.cfgFlags != 0 {
+		if err := nl.NFlogSetFlags(groups, nl.cfgFlags); err != nil {
+			return err
+		}
+	}
 
-	defer nl.NFlogClose()
-	buffer := make([]byte, 65536)
+	return nil
+}
 
-	for {
-		s, _, err := nl.Syscalls.Recvfrom(nl.Socket.getFd(), buffer, 0)
-		if err != nil {
-			if nl.errorCallback != nil {
-				nl.errorCallback(fmt.Errorf("Netlink error %v", err))
-			}
-			if err == syscall.ENOBUFS {
-				continue
-			}
-			return
-		}
-		err = nl.parseLog(buffer[:s])
-		if err != nil {
-			if nl.errorCallback != nil {
-				nl.errorCallback(fmt.Errorf("Parse error %v", err))
-			}
+// sendGroupsUint32 -- Send a __be32 configuration attribute for every group
+func (nl *NfLog) sendGroupsUint32(groups []uint16, attrType uint16, v uint32) error {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, v)
+
+	for _, g := range groups {
+		if err := nl.sendConfig(syscall.AF_UNSPEC, g, attrType, data); err != nil {
+			return err
 		}
 	}
+
+	return nil
 }
 
-// parseLog -- parse the log and call parsePacket
-func (nl *NfLog) parseLog(buffer []byte) error {
+// sendConfig -- Send a NFULNL_MSG_CONFIG request with one attribute and wait for the ACK
+// family -- nfgen family of the request
+// group -- group the request applies to, 0 for the PF commands
+func (nl *NfLog) sendConfig(family uint8, group uint16, attrType uint16, data []byte) error {
+	req := common.NewNfnlRequest(nil, common.NfnlNFLog, common.NlmFRequest|common.NlmFAck, family, group)
+	req.PutBytes(attrType, data)
+	if err := req.Err(); err != nil {
+		return err
+	}
 
-	for len(buffer) > 0 {
-		reader := bytes.NewReader(buffer)
+	if nl.Socket == nil {
+		return fmt.Errorf("NFlogOpen was not called. No Socket open")
+	}
 
-		var header syscall.NlMsghdr
-		binary.Read(reader, binary.LittleEndian, &header)
+	// Once ReadLogs runs it reads every message of the socket, the ACK is handed over by parseLog
+	msg := req.Message()
+	nl.groupsLock.Lock()
+	sock := nl.Socket
+	if !nl.reading {
+		nl.groupsLock.Unlock()
+		return sock.Query(msg)
+	}
+	msg.Header.Seq = sock.NextSeq()
+	msg.Header.Pid = sock.LocalAddress().Pid
+	ack := make(chan error, 1)
+	nl.pending[msg.Header.Seq] = ack
+	nl.groupsLock.Unlock()
+
+	if err := sock.Send(msg); err != nil {
+		nl.groupsLock.Lock()
+		delete(nl.pending, msg.Header.Seq)
+		nl.groupsLock.Unlock()
+		return err
+	}
 
-		msgLen := header.Len
+	return <-ack
+}
 
-		if msgLen > uint32(len(buffer)) {
-			return fmt.Errorf("Message was truncated")
+// ReadLogs -- Listen for logs on the current socket until it fails, the error is passed to the error callback
+func (nl *NfLog) ReadLogs() {
+	nl.Run(context.Background()) // nolint
+}
+
+// handleLog -- parse a datagram and report the error which stopped the parsing
+func (nl *NfLog) handleLog(buffer []byte) {
+	if err := nl.parseLog(buffer); err != nil {
+		nl.reportParseError(err)
+	}
+}
+
+// reportParseError -- pass an error parsing the logs to the error callback
+func (nl *NfLog) reportParseError(err error) {
+	if nl.errorCallback != nil {
+		nl.errorCallback(fmt.Errorf("Parse error %w", err))
+	}
+}
+
+// parseLog -- parse every message in the datagram and call parsePacket for the packets
+// The error of a message is reported and the next messages are still parsed, only the framing
+// error which stops the iteration is returned
+func (nl *NfLog) parseLog(buffer []byte) error {
+
+	msgs := common.NewMessageIterator(buffer)
+	for msgs.Next() {
+		if nl.ackRequest(msgs.Header().Seq, msgs.MessageErr()) {
+			continue
+		}
+		if err := msgs.MessageErr(); err != nil {
+			nl.reportParseError(err)
+			continue
 		}
 
-		if header.Type == ((common.NFNL_SUBSYS_ULOG << 8) | NFULNL_MSG_PACKET) {
-			err := nl.parsePacket(buffer[16 : msgLen-1])
-			if err != nil {
-				return fmt.Errorf("Failed to parse NFPacket: %v", err)
+		if msgs.Header().Type == ((common.NFNL_SUBSYS_ULOG << 8) | NFULNL_MSG_PACKET) {
+			if err := nl.parsePacket(msgs.Data()); err != nil {
+				nl.reportParseError(fmt.Errorf("Failed to parse NFPacket: %w", err))
 			}
 		}
-		buffer = buffer[msgLen:]
 	}
 
-	return nil
+	return msgs.Err()
 }
 
 // parsePacket -- parse packet and set callback for any further processing
 func (nl *NfLog) parsePacket(buffer []byte) error {
-	reader := bytes.NewReader(buffer)
-
-	var header nflogHeader
-	binary.Read(reader, binary.LittleEndian, &header)
+	if len(buffer) < int(common.SizeofNfGenMsg) {
+		return fmt.Errorf("%w: %d bytes of nfgen header", common.ErrMsgTruncated, len(buffer))
+	}
 
 	var m NfPacket
-
-	var tlvHeader nflogTlv
-	for reader.Len() != 0 {
-		err := binary.Read(reader, binary.LittleEndian, &tlvHeader)
-		if err != nil {
-			return err
+	var hasPayload bool
+
+	m.Family = buffer[0]
+	m.Group = binary.BigEndian.Uint16(buffer[2:])
+
+	attrs := common.NewAttrDecoder(buffer[common.SizeofNfGenMsg:])
+	for attrs.Next() {
+		switch attrs.Type() {
+		case NFULA_PACKET_HDR:
+			if hdr := attrs.Struct(sizeofPacketHdr); hdr != nil {
+				m.HwProtocol = binary.BigEndian.Uint16(hdr)
+				m.Hook = hdr[2]
+			}
+		case NFULA_MARK:
+			m.Mark = attrs.Uint32()
+		case NFULA_TIMESTAMP:
+			if ts := attrs.Struct(sizeofPacketTimestamp); ts != nil {
+				m.Timestamp = time.Unix(int64(binary.BigEndian.Uint64(ts)), int64(binary.BigEndian.Uint64(ts[8:]))*int64(time.Microsecond))
+			}
+		case NFULA_IFINDEX_INDEV:
+			m.InDev = attrs.Uint32()
+		case NFULA_IFINDEX_OUTDEV:
+			m.OutDev = attrs.Uint32()
+		case NFULA_IFINDEX_PHYSINDEV:
+			m.PhysInDev = attrs.Uint32()
+		case NFULA_IFINDEX_PHYSOUTDEV:
+			m.PhysOutDev = attrs.Uint32()
+		case NFULA_HWADDR:
+			if hw := attrs.Struct(sizeofPacketHw); hw != nil {
+				addrLen := int(binary.BigEndian.Uint16(hw))
+				if addrLen > sizeofPacketHw-4 {
+					addrLen = sizeofPacketHw - 4
+				}
+				m.HwAddr = append(net.HardwareAddr(nil), hw[4:4+addrLen]...)
+			}
+		case NFULA_UID:
+			m.UID = attrs.Uint32()
+			m.HasUID = true
+		case NFULA_GID:
+			m.GID = attrs.Uint32()
+			m.HasGID = true
+		case NFULA_SEQ:
+			m.Seq = attrs.Uint32()
+			m.HasSeq = true
+		case NFULA_SEQ_GLOBAL:
+			m.SeqGlobal = attrs.Uint32()
+			m.HasSeqGlobal = true
+		case NFULA_HWTYPE:
+			m.HwType = attrs.Uint16()
+		case NFULA_HWHEADER:
+			m.HwHeader = append([]byte(nil), attrs.Bytes()...)
+		case NFULA_CT:
+			// The packet is still logged with its raw entry if the entry cannot be decoded
+			m.CT = append([]byte(nil), attrs.Bytes()...)
+			flow, err := conntrack.DecodeFlow(m.CT)
+			if err != nil {
+				nl.reportParseError(fmt.Errorf("NFULA_CT: %w", err))
+			}
+			m.Conntrack = flow
+		case NFULA_CT_INFO:
+			m.CTInfo = attrs.Uint32()
+		case NFULA_PREFIX:
+			m.Prefix = attrs.String()
+		case NFULA_PAYLOAD:
+			payload := attrs.Bytes()
+			// The read buffer is reused, the packet handed to the callback owns its payload
+			m.Payload = append([]byte(nil), payload...)
+			hasPayload = true
 		}
+	}
+	if err := attrs.Err(); err != nil {
+		return err
+	}
 
-		payloadLen := tlvHeader.Len - 4
+	// Attributes can come in any order, the layer 3 protocol of a bridged packet is only known
+	// once its NFULA_PACKET_HDR was read
+	if !hasPayload {
+		return nil
+	}
 
-		switch tlvHeader.Type {
-		case NFULA_PREFIX:
-			payload := make([]byte, NfaAlign16(payloadLen))
-			reader.Read(payload)
-			m.Prefix = string(payload[:payloadLen-1])
-		case NFULA_PAYLOAD:
-			payload := make([]byte, NfaAlign16(payloadLen))
-			reader.Read(payload)
-			ipPacket, err := packet.New(packet.PacketTypeNetwork, payload, "", false)
-			if err != nil {
-				return err
-			}
+	if err := m.decodeIPLayer(); err != nil {
+		return err
+	}
 
-			if ipPacket != nil {
-				m.SrcIP = ipPacket.SourceAddress()
-				m.DstIP = ipPacket.DestinationAddress()
-				m.Version = IPVersion
-				m.Protocol = ipPacket.IPProto()
-				m.Length = ipPacket.IPTotalLen()
-				m.SrcPort = ipPacket.SourcePort()
-				m.DstPort = ipPacket.DestPort()
-				m.ID = ipPacket.ID()
-			}
+	if callback := nl.groupCallback(m.Group); callback != nil {
+		callback(&NfPacket{
+			Payload:       m.Payload,
+			IPLayer:       m.IPLayer,
+			Ports:         m.Ports,
+			Prefix:        m.Prefix,
+			PacketPayload: m.PacketPayload,
+			PacketMeta:    m.PacketMeta,
+			NflogHandle:   nl,
+		}, nil)
+	}
+
+	return nil
+}
 
-			m.Payload = payload[:payloadLen]
+// decodeIPLayer -- fill the IP layer and ports from the payload
+// The payload of an AF_BRIDGE packet which is not IP (ARP...) is left undecoded. The payload is cut
+// at the copy range: the ports are only decoded if the transport header was copied.
+func (m *NfPacket) decodeIPLayer() error {
+	if m.Family == syscall.AF_BRIDGE && m.HwProtocol != ethPIP && m.HwProtocol != ethPIPv6 {
+		return nil
+	}
 
-			nl.callback(&NfPacket{
-				Payload:       m.Payload,
-				IPLayer:       m.IPLayer,
-				Ports:         m.Ports,
-				Prefix:        m.Prefix,
-				PacketPayload: m.PacketPayload,
-				NflogHandle:   nl,
-			}, nil)
+	h, err := packet.DecodeIP(m.Payload)
+	if errors.Is(err, packet.ErrNotIP) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
 
-		default:
-			reader.Seek(int64(NfaAlign16(payloadLen)), io.SeekCurrent)
-		}
+	m.Version = h.Version
+	m.Length = h.Length
+	if h.Version == IPVersion {
+		m.ID = strconv.Itoa(int(h.ID))
 	}
+	m.Protocol = h.Protocol
+	m.SrcIP = h.SrcIP
+	m.DstIP = h.DstIP
+	m.SrcPort = h.SrcPort
+	m.DstPort = h.DstPort
 
 	return nil
 }
 
+// ENOBUFSCount -- Number of ENOBUFS counted by the common.ENOBUFSCount and common.ENOBUFSResync policies
+func (nl *NfLog) ENOBUFSCount() uint64 {
+	return nl.enobufs.Count()
+}
+
 // GetNFloghandle -- Get the nflog handle created
 func (nl *NfLog) GetNFloghandle() NFLog {
 
@@ -334,6 +532,6 @@ func (nl *NfLog) GetNFloghandle() NFLog {
 // NFlogClose -- close the current socket
 func (nl *NfLog) NFlogClose() {
 	if nl.Socket != nil {
-		nl.Socket.close()
+		nl.Socket.Close() // nolint
 	}
 }
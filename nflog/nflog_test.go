@@ -1,15 +1,39 @@
 package nflog
 
 import (
+	"context"
+	"errors"
+	"net"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	. "github.com/smartystreets/goconvey/convey"
 	"go.aporeto.io/netlink-go/common"
+	"go.aporeto.io/netlink-go/common/nltest"
 	"go.aporeto.io/netlink-go/common/syscallwrappers"
+	"go.aporeto.io/netlink-go/conntrack"
 )
 
+// tcpSyn is an IPv4 TCP SYN from 10.1.10.76:57761 to 164.67.228.152:80
+var tcpSyn = []byte{0x45, 0x00, 0x00, 0x40, 0xf4, 0x1f, 0x40, 0x00, 0x40, 0x06, 0xa9, 0x6f, 0x0a, 0x01, 0x0a, 0x4c, 0xa4, 0x43, 0xe4, 0x98, 0xe1, 0xa1, 0x00, 0x50, 0x4d, 0xa6, 0xac, 0x48, 0x00, 0x00, 0x00, 0x00, 0xb0, 0x02, 0xff, 0xff, 0x6b, 0x6c, 0x00, 0x00, 0x02, 0x04, 0x05, 0xb4, 0x01, 0x03, 0x03, 0x05, 0x01, 0x01, 0x08, 0x0a, 0x1b, 0x4f, 0x37, 0x38, 0x00, 0x00, 0x00, 0x00, 0x04, 0x02, 0x00, 0x00}
+
+// udp6 is an IPv6 UDP datagram from [2001:db8::1]:5353 to [2001:db8::2]:53
+var udp6 = []byte{
+	0x60, 0x00, 0x00, 0x00, 0x00, 0x0c, 0x11, 0x40,
+	0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+	0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02,
+	0x14, 0xe9, 0x00, 0x35, 0x00, 0x0c, 0x00, 0x00, 0x61, 0x62, 0x63, 0x64,
+}
+
+// logMessage returns a NFULNL_MSG_PACKET message of group with the attributes put by attrs
+func logMessage(family uint8, group uint16, attrs func(req *common.NfnlRequest)) []byte {
+	req := common.NewNfnlRequest(nil, (common.NFNL_SUBSYS_ULOG<<8)|NFULNL_MSG_PACKET, 0, family, group)
+	attrs(req)
+	return req.Bytes()
+}
+
 func TestNFlogOpen(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -24,9 +48,7 @@ func TestNFlogOpen(t *testing.T) {
 
 			newNflog.(*NfLog).Syscalls = mockSyscalls
 
-			mockSyscalls.EXPECT().Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_NETFILTER).Times(1).Return(5, nil)
-			mockSyscalls.EXPECT().Bind(5, gomock.Any()).Times(1).Return(nil)
-			mockSyscalls.EXPECT().SetsockoptInt(5, common.SolNetlink, syscall.NETLINK_NO_ENOBUFS, 1).Times(1)
+			nltest.ExpectSocket(mockSyscalls, 5, 100, nltest.NoENOBUFS)
 			nfSockHandle, err := newNflog.NFlogOpen()
 
 			Convey("Then I should not see any error", func() {
@@ -50,12 +72,10 @@ func TestNFlogUnbind(t *testing.T) {
 		Convey("When I try to Unbind a socket ", func() {
 
 			newNflog.(*NfLog).Syscalls = mockSyscalls
-			buf := []byte{0x1C, 0x00, 0x00, 0x00, 0x01, 0x04, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x08, 0x00, 0x01, 0x00, 0x04, 0x00, 0x00, 0x00}
+			buf := []byte{0x1C, 0x00, 0x00, 0x00, 0x01, 0x04, 0x05, 0x00, 0x01, 0x00, 0x00, 0x00, 0x64, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x08, 0x00, 0x01, 0x00, 0x04, 0x00, 0x00, 0x00}
 
 			Convey("When I try to open a socket", func() {
-				mockSyscalls.EXPECT().Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_NETFILTER).Times(1).Return(5, nil)
-				mockSyscalls.EXPECT().Bind(5, gomock.Any()).Times(1).Return(nil)
-				mockSyscalls.EXPECT().SetsockoptInt(5, common.SolNetlink, syscall.NETLINK_NO_ENOBUFS, 1).Times(1)
+				nltest.ExpectSocket(mockSyscalls, 5, 100, nltest.NoENOBUFS)
 				nfSockHandle, err := newNflog.NFlogOpen()
 
 				Convey("Then I should not get any error", func() {
@@ -63,10 +83,10 @@ func TestNFlogUnbind(t *testing.T) {
 					So(err, ShouldBeNil)
 				})
 
-				Convey("When I try to unbind a socket, then I expect the buffer to be populated", func() {
-					mockSyscalls.EXPECT().Sendto(5, gomock.Any(), 0, gomock.Any()).Times(1)
-					mockSyscalls.EXPECT().Recvfrom(5, buf, 0).Times(1).Return(15, nil, nil)
-					newNflog.NFlogUnbind()
+				Convey("When I try to unbind a socket, then I expect the request to be sent", func() {
+					mockSyscalls.EXPECT().Sendto(5, buf, 0, gomock.Any()).Times(1)
+					mockSyscalls.EXPECT().Recvfrom(5, gomock.Any(), 0).Times(1).DoAndReturn(nltest.RecvMessage(nltest.AckMessage(1, 100, 0)))
+					So(newNflog.NFlogUnbind(), ShouldBeNil)
 				})
 			})
 		})
@@ -85,15 +105,13 @@ func TestNFlogBind(t *testing.T) {
 
 		Convey("When I try to bind a socket ", func() {
 
-			mockSyscalls.EXPECT().Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_NETFILTER).Times(1).Return(5, nil)
 
 			newNflog.(*NfLog).Syscalls = mockSyscalls
-			unbindbuf := []byte{0x1C, 0x00, 0x00, 0x00, 0x01, 0x04, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x08, 0x00, 0x01, 0x00, 0x04, 0x00, 0x00, 0x00}
-			bindbuf := []byte{0x1C, 0x00, 0x00, 0x00, 0x01, 0x04, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x08, 0x00, 0x01, 0x00, 0x03, 0x00, 0x00, 0x00}
+			unbindbuf := []byte{0x1C, 0x00, 0x00, 0x00, 0x01, 0x04, 0x05, 0x00, 0x01, 0x00, 0x00, 0x00, 0x64, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x08, 0x00, 0x01, 0x00, 0x04, 0x00, 0x00, 0x00}
+			bindbuf := []byte{0x1C, 0x00, 0x00, 0x00, 0x01, 0x04, 0x05, 0x00, 0x02, 0x00, 0x00, 0x00, 0x64, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x08, 0x00, 0x01, 0x00, 0x03, 0x00, 0x00, 0x00}
 
 			Convey("When I try to open a socket", func() {
-				mockSyscalls.EXPECT().Bind(5, gomock.Any()).Times(1).Return(nil)
-				mockSyscalls.EXPECT().SetsockoptInt(5, common.SolNetlink, syscall.NETLINK_NO_ENOBUFS, 1).Times(1)
+				nltest.ExpectSocket(mockSyscalls, 5, 100, nltest.NoENOBUFS)
 				nfSockHandle, err := newNflog.NFlogOpen()
 
 				Convey("Then I should not get any error", func() {
@@ -101,18 +119,648 @@ func TestNFlogBind(t *testing.T) {
 					So(err, ShouldBeNil)
 				})
 
-				Convey("When I try to unbind a socket, then I should the buffer to be populated", func() {
-					mockSyscalls.EXPECT().Sendto(5, gomock.Any(), 0, gomock.Any()).Times(1)
-					mockSyscalls.EXPECT().Recvfrom(5, unbindbuf, 0).Times(1).Return(15, nil, nil)
-					newNflog.NFlogUnbind()
+				Convey("When I try to unbind a socket, then I expect the request to be sent", func() {
+					mockSyscalls.EXPECT().Sendto(5, unbindbuf, 0, gomock.Any()).Times(1)
+					mockSyscalls.EXPECT().Recvfrom(5, gomock.Any(), 0).Times(1).DoAndReturn(nltest.RecvMessage(nltest.AckMessage(1, 100, 0)))
+					So(newNflog.NFlogUnbind(), ShouldBeNil)
 
-					Convey("When I try to bind a socket, then I should the buffer to be populated", func() {
-						mockSyscalls.EXPECT().Sendto(5, gomock.Any(), 0, gomock.Any()).Times(1)
-						mockSyscalls.EXPECT().Recvfrom(5, bindbuf, 0).Times(1).Return(15, nil, nil)
-						newNflog.NFlogBind()
+					Convey("When I try to bind a socket, then I expect the request to be sent", func() {
+						mockSyscalls.EXPECT().Sendto(5, bindbuf, 0, gomock.Any()).Times(1)
+						mockSyscalls.EXPECT().Recvfrom(5, gomock.Any(), 0).Times(1).DoAndReturn(nltest.RecvMessage(nltest.AckMessage(2, 100, 0)))
+						So(newNflog.NFlogBind(), ShouldBeNil)
 					})
 				})
 			})
 		})
 	})
 }
+
+func TestNFlogBindFamilies(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given I open a nflog handle for IPv4, IPv6 and bridged packets", t, func() {
+		mockSyscalls := syscallwrappers.NewMockSyscalls(ctrl)
+		newNflog := NewNFLog(WithFamilies(syscall.AF_INET, syscall.AF_INET6, syscall.AF_BRIDGE)).(*NfLog)
+		newNflog.Syscalls = mockSyscalls
+
+		nltest.ExpectSocket(mockSyscalls, 5, 100, nltest.NoENOBUFS)
+		_, err := newNflog.NFlogOpen()
+		So(err, ShouldBeNil)
+
+		Convey("When I bind the families and a group", func() {
+			var families []byte
+			mockSyscalls.EXPECT().Sendto(5, gomock.Any(), 0, gomock.Any()).Times(4).Do(func(fd int, p []byte, flags int, to syscall.Sockaddr) {
+				families = append(families, p[syscall.SizeofNlMsghdr])
+			})
+			for seq := uint32(1); seq <= 4; seq++ {
+				mockSyscalls.EXPECT().Recvfrom(5, gomock.Any(), 0).Times(1).DoAndReturn(nltest.RecvMessage(nltest.AckMessage(seq, 100, 0)))
+			}
+			So(newNflog.NFlogBind(), ShouldBeNil)
+			So(newNflog.NFlogBindGroup([]uint16{10}, func(*NfPacket, interface{}) {}, nil), ShouldBeNil)
+
+			Convey("Then a PF bind should be sent per family and the group bound once", func() {
+				So(families, ShouldResemble, []byte{syscall.AF_INET, syscall.AF_INET6, syscall.AF_BRIDGE, syscall.AF_INET})
+			})
+		})
+	})
+}
+
+func TestBindAndListenFamilies(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given I listen for the logs of a group for IPv4 and IPv6 packets", t, func() {
+		mockSyscalls := syscallwrappers.NewMockSyscalls(ctrl)
+		kernel := newKernelStub()
+		newNflog := newNFLog(WithFamilies(syscall.AF_INET, syscall.AF_INET6))
+		newNflog.Syscalls = mockSyscalls
+
+		nltest.ExpectSocket(mockSyscalls, 5, 100, nltest.NoENOBUFS)
+		mockSyscalls.EXPECT().Sendto(5, gomock.Any(), 0, gomock.Any()).AnyTimes().DoAndReturn(kernel.sendto)
+		mockSyscalls.EXPECT().Recvfrom(5, gomock.Any(), 0).AnyTimes().DoAndReturn(kernel.recvfrom)
+		mockSyscalls.EXPECT().Close(5).Times(1)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		_, err := newNflog.bindAndListen(ctx, []uint16{10}, 64, func(*NfPacket, interface{}) {}, nil)
+		cancel()
+		So(err, ShouldBeNil)
+		So(newNflog.Wait(), ShouldBeNil)
+
+		Convey("Then every family should be bound before the group", func() {
+			So(len(kernel.sent), ShouldBeGreaterThanOrEqualTo, 3)
+			for _, family := range []uint8{syscall.AF_INET, syscall.AF_INET6} {
+				bind := <-kernel.sent
+				So(bind[syscall.SizeofNlMsghdr], ShouldEqual, family)
+				So(bind[syscall.SizeofNlMsghdr+2:syscall.SizeofNlMsghdr+4], ShouldResemble, []byte{0x00, 0x00})
+				So(bind[syscall.SizeofNlMsghdr+8], ShouldEqual, NFULNL_CFG_CMD_PF_BIND)
+			}
+			group := <-kernel.sent
+			So(group[syscall.SizeofNlMsghdr+2:syscall.SizeofNlMsghdr+4], ShouldResemble, []byte{0x00, 10})
+			So(group[syscall.SizeofNlMsghdr+8], ShouldEqual, NFULNL_CFG_CMD_BIND)
+		})
+	})
+}
+
+func TestNFlogConfig(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given I open a nflog handle", t, func() {
+		mockSyscalls := syscallwrappers.NewMockSyscalls(ctrl)
+		newNflog := newNFLog(WithNlBufSiz(128*1024), WithFlushTimeout(50*time.Millisecond), WithQThresh(500), WithLogFlags(NFULNL_CFG_F_SEQ|NFULNL_CFG_F_CONNTRACK))
+		newNflog.Syscalls = mockSyscalls
+
+		nltest.ExpectSocket(mockSyscalls, 5, 100, nltest.NoENOBUFS)
+		_, err := newNflog.NFlogOpen()
+		So(err, ShouldBeNil)
+
+		Convey("When I send the configuration of the options", func() {
+			var attrs [][]byte
+			mockSyscalls.EXPECT().Sendto(5, gomock.Any(), 0, gomock.Any()).Times(4).Do(func(fd int, p []byte, flags int, to syscall.Sockaddr) {
+				attrs = append(attrs, append([]byte(nil), p[syscall.SizeofNlMsghdr+common.SizeofNfGenMsg:]...))
+			})
+			for seq := uint32(1); seq <= 4; seq++ {
+				mockSyscalls.EXPECT().Recvfrom(5, gomock.Any(), 0).Times(1).DoAndReturn(nltest.RecvMessage(nltest.AckMessage(seq, 100, 0)))
+			}
+			err := newNflog.setConfig([]uint16{10})
+
+			Convey("Then every attribute should be sent big endian", func() {
+				So(err, ShouldBeNil)
+				So(attrs, ShouldResemble, [][]byte{
+					{0x08, 0x00, NFULA_CFG_NLBUFSIZ, 0x00, 0x00, 0x02, 0x00, 0x00},
+					{0x08, 0x00, NFULA_CFG_TIMEOUT, 0x00, 0x00, 0x00, 0x00, 0x05},
+					{0x08, 0x00, NFULA_CFG_QTHRESH, 0x00, 0x00, 0x00, 0x01, 0xf4},
+					{0x06, 0x00, NFULA_CFG_FLAGS, 0x00, 0x00, 0x05, 0x00, 0x00},
+				})
+			})
+
+			Convey("Then the read buffer should hold a whole kernel datagram", func() {
+				So(newNflog.Socket.(*common.Socket).RcvBufSize(), ShouldBeGreaterThanOrEqualTo, 128*1024)
+			})
+		})
+
+		Convey("When I set datagrams larger than the read buffers", func() {
+			err := newNflog.NFlogSetNlBufSiz([]uint16{10}, 256*1024)
+
+			Convey("Then I should get an error without sending the request", func() {
+				So(errors.Is(err, ErrNlBufSizTooLarge), ShouldBeTrue)
+			})
+		})
+	})
+
+	Convey("Given I open a nflog handle with the default read buffers", t, func() {
+		mockSyscalls := syscallwrappers.NewMockSyscalls(ctrl)
+		newNflog := newNFLog(WithBatchRecv(4))
+		newNflog.Syscalls = mockSyscalls
+
+		nltest.ExpectSocket(mockSyscalls, 5, 100, nltest.NoENOBUFS)
+		_, err := newNflog.NFlogOpen()
+		So(err, ShouldBeNil)
+
+		Convey("When I set datagrams larger than common.NfnlBuffSize", func() {
+			err := newNflog.NFlogSetNlBufSiz([]uint16{10}, common.NfnlBuffSize+1)
+
+			Convey("Then I should get an error without sending the request", func() {
+				So(errors.Is(err, ErrNlBufSizTooLarge), ShouldBeTrue)
+			})
+		})
+
+		Convey("When I set datagrams fitting in the read buffers", func() {
+			mockSyscalls.EXPECT().Sendto(5, gomock.Any(), 0, gomock.Any()).Times(1)
+			mockSyscalls.EXPECT().Recvfrom(5, gomock.Any(), 0).Times(1).DoAndReturn(nltest.RecvMessage(nltest.AckMessage(1, 100, 0)))
+			err := newNflog.NFlogSetNlBufSiz([]uint16{10}, common.NfnlBuffSize)
+
+			Convey("Then the size should be sent", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+}
+
+// kernelStub serves the reads of a nflog socket: the ACK of every request sent and the messages pushed
+// A nil message fails the read with EBADF
+type kernelStub struct {
+	incoming chan []byte
+	sent     chan []byte
+}
+
+func newKernelStub() *kernelStub {
+	return &kernelStub{incoming: make(chan []byte, 16), sent: make(chan []byte, 16)}
+}
+
+func (k *kernelStub) sendto(fd int, p []byte, flags int, to syscall.Sockaddr) error {
+	k.sent <- append([]byte(nil), p...)
+	k.incoming <- nltest.AckMessage(common.NativeEndian().Uint32(p[8:]), 100, 0)
+	return nil
+}
+
+func (k *kernelStub) recvfrom(fd int, p []byte, flags int) (int, syscall.Sockaddr, error) {
+	msg := <-k.incoming
+	if msg == nil {
+		return 0, nil, syscall.EBADF
+	}
+	return copy(p, msg), nil, nil
+}
+
+func TestGroups(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given I bind a nflog group and read its logs", t, func() {
+		mockSyscalls := syscallwrappers.NewMockSyscalls(ctrl)
+		kernel := newKernelStub()
+		newNflog := newNFLog()
+		newNflog.Syscalls = mockSyscalls
+
+		nltest.ExpectSocket(mockSyscalls, 5, 100, nltest.NoENOBUFS)
+		mockSyscalls.EXPECT().Sendto(5, gomock.Any(), 0, gomock.Any()).AnyTimes().DoAndReturn(kernel.sendto)
+		mockSyscalls.EXPECT().Recvfrom(5, gomock.Any(), 0).AnyTimes().DoAndReturn(kernel.recvfrom)
+		mockSyscalls.EXPECT().Close(5).Times(1)
+
+		defaultLogs := make(chan *NfPacket, 4)
+		_, err := newNflog.NFlogOpen()
+		So(err, ShouldBeNil)
+		So(newNflog.NFlogBindGroup([]uint16{10}, func(p *NfPacket, _ interface{}) { defaultLogs <- p }, nil), ShouldBeNil)
+		So(newNflog.NFlogSetMode([]uint16{10}, 64), ShouldBeNil)
+		for len(kernel.sent) > 0 {
+			<-kernel.sent
+		}
+
+		done := make(chan struct{})
+		go func() {
+			newNflog.ReadLogs()
+			close(done)
+		}()
+		for reading := false; !reading; {
+			newNflog.groupsLock.RLock()
+			reading = newNflog.reading
+			newNflog.groupsLock.RUnlock()
+		}
+
+		Convey("When I add a group with its own callback while the logs are read", func() {
+			groupLogs := make(chan *NfPacket, 4)
+			err := newNflog.AddGroup(20, func(p *NfPacket, _ interface{}) { groupLogs <- p })
+			bind, mode := <-kernel.sent, <-kernel.sent
+
+			kernel.incoming <- logMessage(syscall.AF_INET, 20, func(req *common.NfnlRequest) { req.PutBytes(NFULA_PAYLOAD, tcpSyn) })
+			kernel.incoming <- logMessage(syscall.AF_INET, 10, func(req *common.NfnlRequest) { req.PutBytes(NFULA_PAYLOAD, tcpSyn) })
+			p20, p10 := <-groupLogs, <-defaultLogs
+
+			Convey("Then the group should be bound with the copy mode of the handle", func() {
+				So(err, ShouldBeNil)
+				So(common.NativeEndian().Uint16(bind[4:]), ShouldEqual, common.NfnlNFLog)
+				So(bind[syscall.SizeofNlMsghdr+2:syscall.SizeofNlMsghdr+4], ShouldResemble, []byte{0x00, 20})
+				So(bind[syscall.SizeofNlMsghdr+8], ShouldEqual, NFULNL_CFG_CMD_BIND)
+				So(mode[syscall.SizeofNlMsghdr+6], ShouldEqual, NFULA_CFG_MODE)
+				So(newNflog.BoundGroups(), ShouldHaveLength, 2)
+			})
+
+			Convey("Then each group's packets should go to its callback", func() {
+				So(p20.Group, ShouldEqual, 20)
+				So(p10.Group, ShouldEqual, 10)
+			})
+
+			Convey("When I remove the group", func() {
+				err := newNflog.RemoveGroup(20)
+				unbind := <-kernel.sent
+
+				Convey("Then the group should be unbound", func() {
+					So(err, ShouldBeNil)
+					So(unbind[syscall.SizeofNlMsghdr], ShouldEqual, syscall.AF_UNSPEC)
+					So(unbind[syscall.SizeofNlMsghdr+8], ShouldEqual, NFULNL_CFG_CMD_UNBIND)
+					So(newNflog.BoundGroups(), ShouldResemble, []uint16{10})
+				})
+			})
+		})
+
+		Reset(func() {
+			kernel.incoming <- nil
+			<-done
+		})
+	})
+}
+
+func TestRun(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given I bind a nflog group with a handle reconnecting after errors", t, func() {
+		mockSyscalls := syscallwrappers.NewMockSyscalls(ctrl)
+		kernel := newKernelStub()
+		newNflog := newNFLog(WithReconnect(time.Millisecond, 4*time.Millisecond))
+		newNflog.Syscalls = mockSyscalls
+
+		mockSyscalls.EXPECT().Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_NETFILTER).AnyTimes().Return(5, nil)
+		mockSyscalls.EXPECT().Bind(5, gomock.Any()).AnyTimes().Return(nil)
+		mockSyscalls.EXPECT().Getsockname(5).AnyTimes().Return(&syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Pid: 100}, nil)
+		mockSyscalls.EXPECT().SetsockoptInt(5, gomock.Any(), gomock.Any(), 1).AnyTimes()
+		mockSyscalls.EXPECT().Sendto(5, gomock.Any(), 0, gomock.Any()).AnyTimes().DoAndReturn(kernel.sendto)
+		mockSyscalls.EXPECT().Recvfrom(5, gomock.Any(), 0).AnyTimes().DoAndReturn(kernel.recvfrom)
+
+		logs := make(chan *NfPacket, 4)
+		errs := make(chan error, 4)
+		_, err := newNflog.NFlogOpen()
+		So(err, ShouldBeNil)
+		So(newNflog.NFlogBindGroup([]uint16{10}, func(p *NfPacket, _ interface{}) { logs <- p }, func(err error) { errs <- err }), ShouldBeNil)
+		So(newNflog.NFlogSetMode([]uint16{10}, 64), ShouldBeNil)
+		for len(kernel.sent) > 0 {
+			<-kernel.sent
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		newNflog.start(ctx)
+
+		Convey("When I cancel the context", func() {
+			mockSyscalls.EXPECT().Close(5).Times(1)
+			kernel.incoming <- logMessage(syscall.AF_INET, 10, func(req *common.NfnlRequest) { req.PutBytes(NFULA_PAYLOAD, tcpSyn) })
+			<-logs
+			cancel()
+
+			Convey("Then the blocked read should be woken up and the reader stop without error", func() {
+				So(newNflog.Wait(), ShouldBeNil)
+				So(errs, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When the socket fails", func() {
+			mockSyscalls.EXPECT().Close(5).Times(2)
+			kernel.incoming <- nil
+			readErr, gapErr := <-errs, <-errs
+			bind, mode := <-kernel.sent, <-kernel.sent
+			kernel.incoming <- logMessage(syscall.AF_INET, 10, func(req *common.NfnlRequest) { req.PutBytes(NFULA_PAYLOAD, tcpSyn) })
+			p := <-logs
+			cancel()
+
+			Convey("Then the reader should reconnect, bind the group again and report the gap", func() {
+				So(errors.Is(readErr, syscall.EBADF), ShouldBeTrue)
+				var gap *GapError
+				So(errors.As(gapErr, &gap), ShouldBeTrue)
+				So(gap.Attempts, ShouldEqual, 1)
+				So(gap.End.After(gap.Start), ShouldBeTrue)
+				So(errors.Is(gap, syscall.EBADF), ShouldBeTrue)
+				So(bind[syscall.SizeofNlMsghdr+8], ShouldEqual, NFULNL_CFG_CMD_BIND)
+				So(mode[syscall.SizeofNlMsghdr+6], ShouldEqual, NFULA_CFG_MODE)
+				So(p.Group, ShouldEqual, 10)
+				So(newNflog.Wait(), ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestReadLogsENOBUFS(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given I open a nflog handle reporting and counting ENOBUFS", t, func() {
+		mockSyscalls := syscallwrappers.NewMockSyscalls(ctrl)
+
+		var reported []error
+		newNflog := NewNFLog(
+			WithNoENOBUFS(false),
+			WithRcvBufSize(1<<20, false),
+			WithENOBUFSPolicy(common.ENOBUFSCount, nil),
+		).(*NfLog)
+		newNflog.Syscalls = mockSyscalls
+		newNflog.errorCallback = func(err error) {
+			reported = append(reported, err)
+		}
+
+		nltest.ExpectSocket(mockSyscalls, 5, 100, nltest.Sockopt{Level: syscall.SOL_SOCKET, Opt: syscall.SO_RCVBUF, Value: 1 << 20})
+		_, err := newNflog.NFlogOpen()
+		So(err, ShouldBeNil)
+
+		Convey("When reads overflow before the socket fails", func() {
+			gomock.InOrder(
+				mockSyscalls.EXPECT().Recvfrom(5, gomock.Any(), 0).Times(2).Return(0, nil, syscall.ENOBUFS),
+				mockSyscalls.EXPECT().Recvfrom(5, gomock.Any(), 0).Times(1).Return(0, nil, syscall.EBADF),
+			)
+			mockSyscalls.EXPECT().Close(5).Times(1)
+			newNflog.ReadLogs()
+
+			Convey("Then the overflows should be counted and only the failure reported", func() {
+				So(newNflog.ENOBUFSCount(), ShouldEqual, 2)
+				So(reported, ShouldHaveLength, 1)
+				So(errors.Is(reported[0], syscall.EBADF), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestParseLog(t *testing.T) {
+	Convey("Given I have a nflog handle", t, func() {
+		var logged []*NfPacket
+		var reported []error
+		newNflog := NewNFLog().(*NfLog)
+		newNflog.callback = func(p *NfPacket, _ interface{}) {
+			logged = append(logged, p)
+		}
+		newNflog.errorCallback = func(err error) {
+			reported = append(reported, err)
+		}
+
+		Convey("When I parse a packet with every attribute", func() {
+			msg := logMessage(syscall.AF_INET, 10, func(req *common.NfnlRequest) {
+				copy(req.Reserve(NFULA_PACKET_HDR, 4), []byte{0x08, 0x00, 0x01, 0x00})
+				req.PutUint32(NFULA_MARK, 0x10203)
+				ts := req.Reserve(NFULA_TIMESTAMP, 16)
+				copy(ts, []byte{0, 0, 0, 0, 0x5f, 0x5e, 0x10, 0x00, 0, 0, 0, 0, 0, 0x01, 0xe2, 0x40})
+				req.PutUint32(NFULA_IFINDEX_INDEV, 2)
+				req.PutUint32(NFULA_IFINDEX_OUTDEV, 3)
+				req.PutUint32(NFULA_IFINDEX_PHYSINDEV, 4)
+				req.PutUint32(NFULA_IFINDEX_PHYSOUTDEV, 5)
+				copy(req.Reserve(NFULA_HWADDR, 12), []byte{0x00, 0x06, 0x00, 0x00, 0x02, 0x42, 0xac, 0x11, 0x00, 0x02, 0x00, 0x00})
+				req.PutUint16(NFULA_HWTYPE, 1)
+				req.PutBytes(NFULA_HWHEADER, []byte{0x02, 0x42, 0xac, 0x11, 0x00, 0x03, 0x02, 0x42, 0xac, 0x11, 0x00, 0x02, 0x08, 0x00})
+				req.PutUint16(NFULA_HWLEN, 14)
+				req.PutUint32(NFULA_UID, 1000)
+				req.PutUint32(NFULA_GID, 100)
+				req.PutUint32(NFULA_SEQ, 7)
+				req.PutUint32(NFULA_SEQ_GLOBAL, 70000)
+				req.PutUint32(NFULA_CT_INFO, 2)
+				req.PutString(NFULA_PREFIX, "audit")
+				req.PutBytes(NFULA_PAYLOAD, tcpSyn)
+			})
+			err := newNflog.parseLog(msg)
+
+			Convey("Then I should get every field decoded", func() {
+				So(err, ShouldBeNil)
+				So(logged, ShouldHaveLength, 1)
+				p := logged[0]
+				So(p.Prefix, ShouldEqual, "audit")
+				So(p.HwProtocol, ShouldEqual, 0x0800)
+				So(p.Hook, ShouldEqual, 1)
+				So(p.Mark, ShouldEqual, 0x10203)
+				So(p.Timestamp.Equal(time.Unix(1600000000, 123456000)), ShouldBeTrue)
+				So(p.InDev, ShouldEqual, 2)
+				So(p.OutDev, ShouldEqual, 3)
+				So(p.PhysInDev, ShouldEqual, 4)
+				So(p.PhysOutDev, ShouldEqual, 5)
+				So(p.HwAddr.String(), ShouldEqual, "02:42:ac:11:00:02")
+				So(p.HwType, ShouldEqual, 1)
+				So(p.HwHeader, ShouldHaveLength, 14)
+				So(p.UID, ShouldEqual, 1000)
+				So(p.HasUID, ShouldBeTrue)
+				So(p.GID, ShouldEqual, 100)
+				So(p.HasGID, ShouldBeTrue)
+				So(p.Seq, ShouldEqual, 7)
+				So(p.HasSeq, ShouldBeTrue)
+				So(p.SeqGlobal, ShouldEqual, 70000)
+				So(p.HasSeqGlobal, ShouldBeTrue)
+				So(p.CTInfo, ShouldEqual, 2)
+				So(p.SrcIP.Equal(net.IPv4(10, 1, 10, 76)), ShouldBeTrue)
+				So(p.DstPort, ShouldEqual, 80)
+				So(p.Payload, ShouldResemble, tcpSyn)
+			})
+
+			Convey("Then the packet should not share the read buffer", func() {
+				for i := range msg {
+					msg[i] = 0
+				}
+				So(logged[0].Payload, ShouldResemble, tcpSyn)
+				So(logged[0].HwAddr.String(), ShouldEqual, "02:42:ac:11:00:02")
+			})
+		})
+
+		Convey("When I parse a packet logged with its conntrack entry", func() {
+			err := newNflog.parseLog(logMessage(syscall.AF_INET, 10, func(req *common.NfnlRequest) {
+				req.BeginNested(NFULA_CT)
+				req.BeginNested(conntrack.CTA_TUPLE_ORIG)
+				req.BeginNested(conntrack.CTA_TUPLE_IP)
+				req.PutBytes(conntrack.CTA_IP_V4_SRC, []byte{10, 1, 10, 76})
+				req.PutBytes(conntrack.CTA_IP_V4_DST, []byte{192, 0, 2, 10})
+				req.EndNested()
+				req.BeginNested(conntrack.CTA_TUPLE_PROTO)
+				req.PutUint8(conntrack.CTA_PROTO_NUM, syscall.IPPROTO_TCP)
+				req.PutUint16(conntrack.CTA_PROTO_SRC_PORT, 57761)
+				req.PutUint16(conntrack.CTA_PROTO_DST_PORT, 443)
+				req.EndNested()
+				req.EndNested()
+				req.PutUint32(conntrack.CTA_STATUS, conntrack.IPS_CONFIRMED|conntrack.IPS_DST_NAT)
+				req.PutUint32(conntrack.CTA_MARK, 0x42)
+				req.PutUint16(conntrack.CTA_ZONE, 3)
+				req.PutBytes(conntrack.CTA_LABELS, make([]byte, 16))
+				req.EndNested()
+				req.PutUint32(NFULA_CT_INFO, IP_CT_NEW)
+				req.PutBytes(NFULA_PAYLOAD, tcpSyn)
+			}))
+
+			Convey("Then the conntrack entry should be decoded with the pre-NAT addresses", func() {
+				So(err, ShouldBeNil)
+				So(logged, ShouldHaveLength, 1)
+				ct := logged[0].Conntrack
+				So(ct, ShouldNotBeNil)
+				So(ct.Orig.DstIP.String(), ShouldEqual, "192.0.2.10")
+				So(ct.Orig.DstPort, ShouldEqual, 443)
+				So(logged[0].DstIP.String(), ShouldEqual, "164.67.228.152")
+				So(ct.Status, ShouldEqual, conntrack.IPS_CONFIRMED|conntrack.IPS_DST_NAT)
+				So(ct.Mark, ShouldEqual, 0x42)
+				So(ct.Zone, ShouldEqual, 3)
+				So(ct.Labels, ShouldHaveLength, 16)
+				So(logged[0].CTInfo, ShouldEqual, IP_CT_NEW)
+			})
+		})
+
+		Convey("When I parse a packet logged with a truncated conntrack entry", func() {
+			truncatedCT := []byte{0x08, 0x00, conntrack.CTA_MARK, 0x00, 0x00}
+			err := newNflog.parseLog(logMessage(syscall.AF_INET, 10, func(req *common.NfnlRequest) {
+				req.PutBytes(NFULA_CT, truncatedCT)
+				req.PutBytes(NFULA_PAYLOAD, tcpSyn)
+			}))
+
+			Convey("Then the packet should be logged with the raw entry and the error reported", func() {
+				So(err, ShouldBeNil)
+				So(logged, ShouldHaveLength, 1)
+				So(logged[0].Conntrack, ShouldBeNil)
+				So(logged[0].CT, ShouldResemble, truncatedCT)
+				So(logged[0].DstPort, ShouldEqual, 80)
+				So(reported, ShouldHaveLength, 1)
+				So(errors.Is(reported[0], common.ErrAttrTruncated), ShouldBeTrue)
+			})
+		})
+
+		Convey("When I parse a forwarded packet without socket owner", func() {
+			err := newNflog.parseLog(logMessage(syscall.AF_INET, 10, func(req *common.NfnlRequest) {
+				req.PutUint32(NFULA_IFINDEX_INDEV, 2)
+				req.PutBytes(NFULA_PAYLOAD, tcpSyn)
+			}))
+
+			Convey("Then the owner should be reported missing", func() {
+				So(err, ShouldBeNil)
+				So(logged, ShouldHaveLength, 1)
+				So(logged[0].HasUID, ShouldBeFalse)
+				So(logged[0].HasGID, ShouldBeFalse)
+				So(logged[0].HasSeq, ShouldBeFalse)
+				So(logged[0].Timestamp.IsZero(), ShouldBeTrue)
+			})
+		})
+
+		Convey("When I parse an IPv6 packet logged by ip6tables", func() {
+			err := newNflog.parseLog(logMessage(syscall.AF_INET6, 10, func(req *common.NfnlRequest) {
+				copy(req.Reserve(NFULA_PACKET_HDR, 4), []byte{0x86, 0xdd, 0x01, 0x00})
+				req.PutBytes(NFULA_PAYLOAD, udp6)
+			}))
+
+			Convey("Then the packet should be decoded as IPv6", func() {
+				So(err, ShouldBeNil)
+				So(logged, ShouldHaveLength, 1)
+				So(logged[0].Family, ShouldEqual, syscall.AF_INET6)
+				So(logged[0].Version, ShouldEqual, IPv6Version)
+				So(logged[0].SrcIP.String(), ShouldEqual, "2001:db8::1")
+				So(logged[0].DstIP.String(), ShouldEqual, "2001:db8::2")
+				So(logged[0].Protocol, ShouldEqual, syscall.IPPROTO_UDP)
+				So(logged[0].SrcPort, ShouldEqual, 5353)
+				So(logged[0].DstPort, ShouldEqual, 53)
+			})
+		})
+
+		Convey("When I parse a bridged ARP packet", func() {
+			arp := []byte{0x00, 0x01, 0x08, 0x00, 0x06, 0x04, 0x00, 0x01, 0x02, 0x42, 0xac, 0x11, 0x00, 0x02, 0xac, 0x11, 0x00, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xac, 0x11, 0x00, 0x01}
+			err := newNflog.parseLog(logMessage(syscall.AF_BRIDGE, 10, func(req *common.NfnlRequest) {
+				req.PutBytes(NFULA_PAYLOAD, arp)
+				copy(req.Reserve(NFULA_PACKET_HDR, 4), []byte{0x08, 0x06, 0x01, 0x00})
+			}))
+
+			Convey("Then the packet should be logged without IP layer", func() {
+				So(err, ShouldBeNil)
+				So(logged, ShouldHaveLength, 1)
+				So(logged[0].Family, ShouldEqual, syscall.AF_BRIDGE)
+				So(logged[0].HwProtocol, ShouldEqual, 0x0806)
+				So(logged[0].Version, ShouldEqual, 0)
+				So(logged[0].SrcIP, ShouldBeNil)
+				So(logged[0].Payload, ShouldResemble, arp)
+			})
+		})
+
+		Convey("When I parse a packet with a short timestamp", func() {
+			err := newNflog.parseLog(logMessage(syscall.AF_INET, 10, func(req *common.NfnlRequest) {
+				req.PutUint32(NFULA_TIMESTAMP, 1)
+				req.PutBytes(NFULA_PAYLOAD, tcpSyn)
+			}))
+
+			Convey("Then the error should be reported and no packet logged", func() {
+				So(err, ShouldBeNil)
+				So(reported, ShouldHaveLength, 1)
+				So(errors.Is(reported[0], common.ErrAttrValueTooShort), ShouldBeTrue)
+				So(logged, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I parse a packet cut after its IP header by the copy range", func() {
+			err := newNflog.parseLog(logMessage(syscall.AF_INET, 10, func(req *common.NfnlRequest) {
+				req.PutBytes(NFULA_PAYLOAD, tcpSyn[:22])
+			}))
+
+			Convey("Then the IP layer should be decoded without ports", func() {
+				So(err, ShouldBeNil)
+				So(logged, ShouldHaveLength, 1)
+				So(logged[0].DstIP.String(), ShouldEqual, "164.67.228.152")
+				So(logged[0].Protocol, ShouldEqual, syscall.IPPROTO_TCP)
+				So(logged[0].Length, ShouldEqual, 64)
+				So(logged[0].DstPort, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When I parse a packet with IP options", func() {
+			withOptions := append([]byte{0x46}, tcpSyn[1:20]...)
+			withOptions = append(withOptions, 0x01, 0x01, 0x01, 0x00)
+			withOptions = append(withOptions, tcpSyn[20:]...)
+			err := newNflog.parseLog(logMessage(syscall.AF_INET, 10, func(req *common.NfnlRequest) {
+				req.PutBytes(NFULA_PAYLOAD, withOptions)
+			}))
+
+			Convey("Then the ports should be read after the options", func() {
+				So(err, ShouldBeNil)
+				So(logged, ShouldHaveLength, 1)
+				So(logged[0].SrcPort, ShouldEqual, 57761)
+				So(logged[0].DstPort, ShouldEqual, 80)
+			})
+		})
+
+		Convey("When I parse malformed messages", func() {
+			truncatedMsg := logMessage(syscall.AF_INET, 10, func(req *common.NfnlRequest) {
+				req.PutBytes(NFULA_PAYLOAD, tcpSyn)
+			})
+			badAttr := logMessage(syscall.AF_INET, 10, func(req *common.NfnlRequest) {
+				req.PutUint32(NFULA_MARK, 1)
+			})
+			common.NativeEndian().PutUint16(badAttr[syscall.SizeofNlMsghdr+common.SizeofNfGenMsg:], 2)
+			noNfgen := logMessage(syscall.AF_INET, 10, func(req *common.NfnlRequest) {})[:syscall.SizeofNlMsghdr+2]
+			common.NativeEndian().PutUint32(noNfgen, uint32(len(noNfgen)))
+			shortIP := logMessage(syscall.AF_INET, 10, func(req *common.NfnlRequest) {
+				req.PutBytes(NFULA_PAYLOAD, tcpSyn[:12])
+			})
+			badIHL := logMessage(syscall.AF_INET, 10, func(req *common.NfnlRequest) {
+				req.PutBytes(NFULA_PAYLOAD, append([]byte{0x42}, tcpSyn[1:]...))
+			})
+			shortIPv6 := logMessage(syscall.AF_INET6, 10, func(req *common.NfnlRequest) {
+				req.PutBytes(NFULA_PAYLOAD, udp6[:39])
+			})
+
+			Convey("Then I should get typed errors and no packet", func() {
+				So(errors.Is(newNflog.parseLog(truncatedMsg[:len(truncatedMsg)-8]), common.ErrMsgTruncated), ShouldBeTrue)
+				for _, msg := range [][]byte{badAttr, noNfgen, shortIP, badIHL, shortIPv6} {
+					So(newNflog.parseLog(msg), ShouldBeNil)
+				}
+				So(reported, ShouldHaveLength, 5)
+				So(errors.Is(reported[0], common.ErrAttrInvalidLength), ShouldBeTrue)
+				So(errors.Is(reported[1], common.ErrMsgTruncated), ShouldBeTrue)
+				So(errors.Is(reported[2], ErrPayloadTruncated), ShouldBeTrue)
+				So(errors.Is(reported[3], ErrPayloadMalformed), ShouldBeTrue)
+				So(errors.Is(reported[4], ErrPayloadTruncated), ShouldBeTrue)
+				So(logged, ShouldBeEmpty)
+			})
+
+			Convey("Then the logs following a bad message in the datagram should be delivered", func() {
+				valid := logMessage(syscall.AF_INET, 10, func(req *common.NfnlRequest) {
+					req.PutBytes(NFULA_PAYLOAD, tcpSyn)
+				})
+				datagram := append(append(append([]byte(nil), shortIP...), badIHL...), valid...)
+				So(newNflog.parseLog(datagram), ShouldBeNil)
+				So(reported, ShouldHaveLength, 2)
+				So(logged, ShouldHaveLength, 1)
+				So(logged[0].DstPort, ShouldEqual, 80)
+			})
+		})
+	})
+}
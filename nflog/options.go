@@ -0,0 +1,111 @@
+// +build linux !darwin
+
+package nflog
+
+import (
+	"time"
+
+	"go.aporeto.io/netlink-go/common"
+)
+
+// Option -- Option of a nflog handle, passed to NewNFLog or BindAndListenForLogs
+type Option func(*NfLog)
+
+// WithNetNS -- Open the nflog socket in the network namespace referred to by fd
+// The fd is only used while the socket is opened, the caller keeps ownership of it
+func WithNetNS(fd int) Option {
+	return func(nl *NfLog) {
+		nl.sockOpts = append(nl.sockOpts, common.WithNetNS(fd))
+	}
+}
+
+// WithNetNSPath -- Open the nflog socket in the network namespace bound at path,
+// as /var/run/netns/name or /proc/pid/ns/net
+func WithNetNSPath(path string) Option {
+	return func(nl *NfLog) {
+		nl.sockOpts = append(nl.sockOpts, common.WithNetNSPath(path))
+	}
+}
+
+// WithRcvBufSize -- Size of the socket receive buffer, the kernel default otherwise
+// force -- use SO_RCVBUFFORCE to go over rmem_max, needs CAP_NET_ADMIN
+func WithRcvBufSize(size int, force bool) Option {
+	return func(nl *NfLog) {
+		nl.sockOpts = append(nl.sockOpts, common.WithRcvBufSize(size, force))
+	}
+}
+
+// WithNoENOBUFS -- Set or clear NETLINK_NO_ENOBUFS, set by default. When cleared the kernel
+// reports ENOBUFS when it drops logs because the receive buffer is full
+func WithNoENOBUFS(enable bool) Option {
+	return func(nl *NfLog) {
+		nl.sockOpts = append(nl.sockOpts, common.WithNoENOBUFS(enable))
+	}
+}
+
+// WithENOBUFSPolicy -- What ReadLogs does on ENOBUFS, the error callback gets it by default
+// resync -- called with the common.ENOBUFSResync policy, may be nil
+func WithENOBUFSPolicy(policy common.ENOBUFSPolicy, resync func()) Option {
+	return func(nl *NfLog) {
+		nl.enobufs.Policy = policy
+		nl.enobufs.Resync = resync
+	}
+}
+
+// WithBatchRecv -- Read up to count datagrams per recvmmsg call instead of one per recvfrom
+// Each datagram gets its own buffer of NfnlBuffSize bytes
+func WithBatchRecv(count int) Option {
+	return func(nl *NfLog) {
+		nl.batch = common.NewRecvBatch(count, int(common.NfnlBuffSize))
+	}
+}
+
+// WithFamilies -- Families NFlogBind and NFlogUnbind are sent for, AF_INET by default
+// BindAndListenForLogs binds each of them before the groups, without it no family is bound
+// Pass syscall.AF_INET6 for ip6tables rules and syscall.AF_BRIDGE for ebtables/nftables bridge rules
+func WithFamilies(families ...uint8) Option {
+	return func(nl *NfLog) {
+		nl.families = families
+	}
+}
+
+// WithNlBufSiz -- Size of the datagrams the kernel batches logs in, set by BindAndListenForLogs
+// The read buffers are grown to hold them
+func WithNlBufSiz(size uint32) Option {
+	return func(nl *NfLog) {
+		nl.nlbufsiz = size
+	}
+}
+
+// WithFlushTimeout -- Longest time a log is held in the kernel, set by BindAndListenForLogs
+// A short timeout gets the logs out quickly at low traffic
+func WithFlushTimeout(timeout time.Duration) Option {
+	return func(nl *NfLog) {
+		nl.timeout = timeout
+	}
+}
+
+// WithQThresh -- Number of logs batched in a datagram, set by BindAndListenForLogs
+// A high threshold with a large WithNlBufSiz cuts the number of reads at high traffic
+func WithQThresh(qthresh uint32) Option {
+	return func(nl *NfLog) {
+		nl.qthresh = qthresh
+	}
+}
+
+// WithLogFlags -- NFULNL_CFG_F flags set by BindAndListenForLogs:
+// NFULNL_CFG_F_SEQ, NFULNL_CFG_F_SEQ_GLOBAL and NFULNL_CFG_F_CONNTRACK
+func WithLogFlags(flags uint16) Option {
+	return func(nl *NfLog) {
+		nl.cfgFlags = flags
+	}
+}
+
+// WithReconnect -- Reopen the socket and bind the families and groups again when reading logs fails,
+// waiting min before the first attempt and doubling the wait up to max between attempts.
+// The error callback gets the read error, then a *GapError once the logs are read again.
+func WithReconnect(min time.Duration, max time.Duration) Option {
+	return func(nl *NfLog) {
+		nl.reconnect = &backoff{min: min, max: max}
+	}
+}
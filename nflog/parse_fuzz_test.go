@@ -0,0 +1,53 @@
+// +build go1.18
+
+package nflog
+
+import (
+	"syscall"
+	"testing"
+
+	"go.aporeto.io/netlink-go/common"
+	"go.aporeto.io/netlink-go/common/nltest"
+	"go.aporeto.io/netlink-go/conntrack"
+)
+
+func FuzzParseLog(f *testing.F) {
+	f.Add(logMessage(syscall.AF_INET, 10, func(req *common.NfnlRequest) {
+		copy(req.Reserve(NFULA_PACKET_HDR, 4), []byte{0x08, 0x00, 0x01, 0x00})
+		req.PutUint32(NFULA_MARK, 1)
+		req.Reserve(NFULA_TIMESTAMP, 16)
+		req.Reserve(NFULA_HWADDR, 12)
+		req.PutUint32(NFULA_UID, 1000)
+		req.PutString(NFULA_PREFIX, "fuzz")
+		req.PutBytes(NFULA_PAYLOAD, tcpSyn)
+	}))
+	f.Add(logMessage(syscall.AF_INET6, 20, func(req *common.NfnlRequest) {
+		req.PutBytes(NFULA_PAYLOAD, udp6)
+	}))
+	f.Add(logMessage(syscall.AF_BRIDGE, 30, func(req *common.NfnlRequest) {
+		copy(req.Reserve(NFULA_PACKET_HDR, 4), []byte{0x86, 0xdd, 0x01, 0x00})
+		req.PutBytes(NFULA_PAYLOAD, udp6[:41])
+	}))
+	f.Add(logMessage(syscall.AF_INET, 40, func(req *common.NfnlRequest) {
+		req.BeginNested(NFULA_CT)
+		req.BeginNested(conntrack.CTA_TUPLE_ORIG)
+		req.BeginNested(conntrack.CTA_TUPLE_IP)
+		req.PutBytes(conntrack.CTA_IP_V4_SRC, []byte{10, 0, 0, 1})
+		req.EndNested()
+		req.EndNested()
+		req.PutUint32(conntrack.CTA_MARK, 1)
+		req.EndNested()
+		req.PutBytes(NFULA_PAYLOAD, tcpSyn)
+	}))
+	f.Add(nltest.AckMessage(1, 100, 0))
+
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		nl := newNFLog()
+		nl.callback = func(p *NfPacket, _ interface{}) {
+			if len(p.Payload) > len(buf) {
+				t.Fatalf("payload of %d bytes parsed from %d bytes", len(p.Payload), len(buf))
+			}
+		}
+		nl.parseLog(buf) // nolint
+	})
+}
@@ -0,0 +1,224 @@
+// +build linux !darwin
+
+package nflog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+
+	"go.aporeto.io/netlink-go/common"
+)
+
+// errNotStarted -- returned by Wait on a handle whose reader was not started by BindAndListenForLogs
+var errNotStarted = errors.New("nflog reader was not started")
+
+// GapError -- Passed to the error callback once the reader reconnected after a socket error
+// The logs of the packets logged between Start and End were lost.
+// Attempts -- number of attempts it took to reconnect
+// Err -- socket error which stopped the reader
+type GapError struct {
+	Start    time.Time
+	End      time.Time
+	Attempts int
+	Err      error
+}
+
+// Error -- error interface
+func (e *GapError) Error() string {
+	return fmt.Sprintf("nflog logs lost for %s after %v, reconnected after %d attempts", e.End.Sub(e.Start), e.Err, e.Attempts)
+}
+
+// Unwrap -- the socket error which stopped the reader
+func (e *GapError) Unwrap() error {
+	return e.Err
+}
+
+// backoff -- wait between reconnect attempts, doubled from min to max
+type backoff struct {
+	min time.Duration
+	max time.Duration
+}
+
+// Run -- Read logs until ctx is done or the socket fails. With WithReconnect the reader reconnects
+// instead and only stops once ctx is done. The socket is closed on return.
+// Returns nil once ctx is done, the socket error otherwise. Read errors also go to the error callback.
+func (nl *NfLog) Run(ctx context.Context) error {
+	nl.startReading()
+	defer nl.NFlogClose()
+	defer nl.stopReading()
+
+	// A blocked read only returns on a message: have the kernel ACK a NOOP to wake it up
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			nl.wakeReader()
+		case <-stop:
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+
+	for {
+		err := nl.readLogs(ctx)
+		if err == nil || ctx.Err() != nil {
+			return nil
+		}
+		if nl.errorCallback != nil {
+			nl.errorCallback(fmt.Errorf("Netlink error %w", err))
+		}
+		if nl.reconnect == nil {
+			return err
+		}
+		if nl.reconnectWithBackoff(ctx, err) != nil {
+			return nil
+		}
+	}
+}
+
+// Wait -- Wait for the reader started by BindAndListenForLogs to stop, returns the error which stopped it
+func (nl *NfLog) Wait() error {
+	if nl.done == nil {
+		return errNotStarted
+	}
+	<-nl.done
+	return nl.runErr
+}
+
+// start -- Run the reader in the background, Wait returns its result
+func (nl *NfLog) start(ctx context.Context) {
+	nl.done = make(chan struct{})
+	go func() {
+		nl.runErr = nl.Run(ctx)
+		close(nl.done)
+	}()
+}
+
+// readLogs -- Read and dispatch logs until the socket fails or ctx is done, nil once ctx is done
+func (nl *NfLog) readLogs(ctx context.Context) error {
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		var buffer []byte
+		var err error
+		if nl.batch != nil {
+			_, err = nl.Socket.RecvBatch(nl.batch, syscall.MSG_WAITFORONE)
+		} else {
+			buffer, err = nl.Socket.Recv(0)
+		}
+		if nl.enobufs.Handle(err) {
+			continue
+		}
+		if err == syscall.ENOBUFS {
+			if nl.errorCallback != nil {
+				nl.errorCallback(fmt.Errorf("Netlink error %w", err))
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if nl.batch == nil {
+			nl.handleLog(buffer)
+			continue
+		}
+		for buffer, ok := nl.batch.Next(); ok; buffer, ok = nl.batch.Next() {
+			nl.handleLog(buffer)
+		}
+	}
+}
+
+// wakeReader -- Send a NOOP request the kernel ACKs, errors are ignored as the socket may be closed
+func (nl *NfLog) wakeReader() {
+	nl.groupsLock.RLock()
+	defer nl.groupsLock.RUnlock()
+
+	sock := nl.Socket
+	sock.Send(&syscall.NetlinkMessage{ // nolint
+		Header: syscall.NlMsghdr{
+			Len:   syscall.SizeofNlMsghdr,
+			Type:  common.NlMsgNoop,
+			Flags: uint16(common.NlmFRequest | common.NlmFAck),
+			Seq:   sock.NextSeq(),
+			Pid:   sock.LocalAddress().Pid,
+		},
+	})
+}
+
+// reconnectWithBackoff -- Reopen the socket until it works or ctx is done, then report the gap
+// cause -- socket error which stopped the reader
+func (nl *NfLog) reconnectWithBackoff(ctx context.Context, cause error) error {
+	// Requests waiting for an ACK on the dead socket fail, the next ones wait for the reconnect
+	nl.stopReading()
+	nl.configLock.Lock()
+	defer nl.configLock.Unlock()
+
+	start := time.Now()
+	wait := nl.reconnect.min
+	for attempts := 1; ; attempts++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+
+		err := nl.reopen()
+		if err == nil {
+			nl.startReading()
+			if nl.errorCallback != nil {
+				nl.errorCallback(&GapError{Start: start, End: time.Now(), Attempts: attempts, Err: cause})
+			}
+			return nil
+		}
+		if nl.errorCallback != nil {
+			nl.errorCallback(fmt.Errorf("Reconnect failed: %w", err))
+		}
+
+		if wait *= 2; wait > nl.reconnect.max {
+			wait = nl.reconnect.max
+		}
+	}
+}
+
+// reopen -- Open a new socket and bind the families and groups of the handle again
+func (nl *NfLog) reopen() error {
+	nl.groupsLock.Lock()
+	nl.Socket.Close() // nolint
+	nl.groupsLock.Unlock()
+
+	if _, err := nl.NFlogOpen(); err != nil {
+		return err
+	}
+
+	if nl.pfBound {
+		if err := nl.NFlogBind(); err != nil {
+			return err
+		}
+	}
+
+	groups := nl.BoundGroups()
+	nl.groupsLock.RLock()
+	copyRange, modeSet := nl.copyRange, nl.modeSet
+	nl.groupsLock.RUnlock()
+
+	for _, g := range groups {
+		if err := nl.bindGroup(g); err != nil {
+			return err
+		}
+	}
+	if modeSet {
+		if err := nl.NFlogSetMode(groups, copyRange); err != nil {
+			return err
+		}
+	}
+	return nl.setConfig(groups)
+}
@@ -4,9 +4,12 @@ package nflog
 
 import (
 	"net"
-	"syscall"
+	"sync"
+	"time"
 
+	"go.aporeto.io/netlink-go/common"
 	"go.aporeto.io/netlink-go/common/syscallwrappers"
+	"go.aporeto.io/netlink-go/conntrack"
 )
 
 // NfLog -- Nflog struct
@@ -20,19 +23,25 @@ type NfLog struct {
 	Socket        SockHandle
 	NflogHandle   NFLog
 	Syscalls      syscallwrappers.Syscalls
-}
-
-// nflogHeader -- unexported header struct for parsing
-type nflogHeader struct {
-	Family  uint8
-	Version uint8
-	ResID   uint16 // BigEndian
-}
-
-// nflogTlv -- unexported attribute struct for parsing
-type nflogTlv struct {
-	Len  uint16
-	Type uint16
+	sockOpts      []common.SocketOption
+	enobufs       common.ENOBUFSHandler
+	batch         *common.RecvBatch
+	families      []uint8
+	nlbufsiz      uint32
+	timeout       time.Duration
+	qthresh       uint32
+	cfgFlags      uint16
+	groupsLock    sync.RWMutex
+	groups        map[uint16]func(*NfPacket, interface{})
+	copyRange     uint32
+	modeSet       bool
+	reading       bool
+	pending       map[uint32]chan error
+	configLock    sync.Mutex
+	pfBound       bool
+	reconnect     *backoff
+	done          chan struct{}
+	runErr        error
 }
 
 // NflMsgConfigCommand -- NflMsgConfigCommand struct for configs (ex: bind)
@@ -47,31 +56,69 @@ type NflMsgConfigMode struct {
 }
 
 //SockHandles -- Sock handle of netlink socket
-//fd -- fd of socket
-//rcvbufSize -- rcv buffer Size
-//lsa -- local address
-type SockHandles struct {
-	Syscalls   syscallwrappers.Syscalls
-	fd         int
-	rcvbufSize uint32
-	buf        []byte
-	lsa        syscall.SockaddrNetlink
-}
+//Deprecated: the log uses the netlink socket shared by all the subsystems, common.Socket
+type SockHandles = common.Socket
 
 // NfPacket -- NfPacket struct for parsing logs
 // Payload -- Complete packet with ethernet,tcp and ip
 // IPLayer -- Iplayer struct
 // TCPLayer -- Tcplayer struct
 // PacketPayload -- Tcp payload
+// PacketMeta -- Attributes the kernel logged with the packet
 type NfPacket struct {
 	Prefix  string
 	Payload []byte
 	IPLayer
 	Ports
 	PacketPayload
+	PacketMeta
 	NflogHandle *NfLog
 }
 
+// PacketMeta -- Attributes of a logged packet, the zero value when the kernel did not send them
+// Family -- nfgen family of the message: AF_INET, AF_INET6 or AF_BRIDGE
+// Group -- nflog group the packet was logged to, the nfgen resource id
+// HwProtocol -- ethertype of the packet
+// Hook -- netfilter hook the packet was logged at
+// Mark -- packet mark
+// Timestamp -- time the packet was received, only set for received packets
+// InDev, OutDev, PhysInDev, PhysOutDev -- interface indexes, the physical ones for bridged packets
+// HwAddr -- source hardware address
+// UID, GID -- owner of the socket of a local packet, HasUID and HasGID tell if they were logged
+// Seq, SeqGlobal -- local and global sequence numbers, logged with NFULNL_CFG_F_SEQ and NFULNL_CFG_F_SEQ_GLOBAL
+// HasSeq, HasSeqGlobal -- tell if the sequence numbers were logged
+// HwType -- ARPHRD type of the device
+// HwHeader -- link layer header of the packet
+// CT -- nested conntrack attributes of the packet, logged with NFULNL_CFG_F_CONNTRACK
+// Conntrack -- CT decoded: original and reply tuples, mark, status, zone and labels. nil without CT
+// CTInfo -- conntrack state of the packet, enum ip_conntrack_info
+type PacketMeta struct {
+	Family       uint8
+	Group        uint16
+	HwProtocol   uint16
+	Hook         uint8
+	Mark         uint32
+	Timestamp    time.Time
+	InDev        uint32
+	OutDev       uint32
+	PhysInDev    uint32
+	PhysOutDev   uint32
+	HwAddr       net.HardwareAddr
+	UID          uint32
+	GID          uint32
+	HasUID       bool
+	HasGID       bool
+	Seq          uint32
+	SeqGlobal    uint32
+	HasSeq       bool
+	HasSeqGlobal bool
+	HwType       uint16
+	HwHeader     []byte
+	CT           []byte
+	Conntrack    *conntrack.Flow
+	CTInfo       uint32
+}
+
 // IPLayer -- IPLayer struct
 type IPLayer struct {
 	SrcIP    net.IP
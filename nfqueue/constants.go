@@ -33,8 +33,16 @@ const (
 	NfqaHwaddr nfqaAttr = 0x9 /* nfqnl_msg_packet_hw */
 	//NfqaPayload -- Packet Payload
 	NfqaPayload nfqaAttr = 0xa /* opaque data payload */
+	//NfqaCt -- Conntrack entry of the packet
+	NfqaCt nfqaAttr = 0xb /* nfnetlink_conntrack.h */
+	//NfqaCtInfo -- Conntrack state of the packet
+	NfqaCtInfo nfqaAttr = 0xc /* enum ip_conntrack_info */
+	//NfqaCapLen -- Length of the packet when the payload was truncated to the copy range
+	NfqaCapLen nfqaAttr = 0xd /* __u32 length of captured packet */
+	//NfqaSkbInfo -- GSO and checksum state of the packet, NfqaSkb flags
+	NfqaSkbInfo nfqaAttr = 0xe /* __u32 skb meta information */
 	//unexported max
-	nfqaMax nfqaAttr = 0xb
+	nfqaMax nfqaAttr = 0xe
 
 	//NfqnlCfgCmdnone -- None
 	NfqnlCfgCmdnone nfqConfigCommands = 0x0
@@ -78,7 +86,50 @@ const (
 	NfqaCfgMask uint32 = 0x4 /* identify which flags to change */
 	//NfqaCfgFlags -- Config Flags
 	NfqaCfgFlags uint32 = 0x5 /* value of these flags (__u32) */
+
+	//NfqaCfgFFailOpen -- Accept packets instead of dropping them when the queue is full
+	NfqaCfgFFailOpen uint32 = 0x1
+	//NfqaCfgFConntrack -- Add the conntrack entry of the packets
+	NfqaCfgFConntrack uint32 = 0x2
+	//NfqaCfgFGSO -- Queue GSO packets whole instead of segmenting them, they may be larger than
+	//the copy range and be truncated, see NFPacket.Length
+	NfqaCfgFGSO uint32 = 0x4
+	//NfqaCfgFUIDGID -- Add the uid and gid of the socket owning the packets
+	NfqaCfgFUIDGID uint32 = 0x8
+	//NfqaCfgFSecctx -- Add the security context of the packets
+	NfqaCfgFSecctx uint32 = 0x10
+
+	//NfqaSkbCsumNotReady -- The checksum is not computed yet, it is done by the hardware
+	NfqaSkbCsumNotReady uint32 = 0x1
+	//NfqaSkbGSO -- The packet is a GSO packet, segmented later
+	NfqaSkbGSO uint32 = 0x2
+	//NfqaSkbCsumNotVerified -- The checksum of a received packet was not verified
+	NfqaSkbCsumNotVerified uint32 = 0x4
+
+	//NfqnlMaxCopyRange -- Largest payload of a packet or verdict message, its attribute length is 16 bits
+	NfqnlMaxCopyRange uint32 = 0xffff - 4
 	//nfqaCfgMax -- unexported max
 	//nfqaCfgMax uint32 = 0x6 //nodeadcode
 
 )
+
+//Verdicts, from linux/netfilter.h
+const (
+	//NF_DROP -- Drop the packet
+	NF_DROP NfVerdict = 0x0
+	//NF_ACCEPT -- Let the packet continue its way
+	NF_ACCEPT NfVerdict = 0x1
+	//NF_STOLEN -- Userspace took the packet, the kernel forgets it
+	NF_STOLEN NfVerdict = 0x2
+	//NF_QUEUE -- Queue the packet to another queue, see QueueVerdict
+	NF_QUEUE NfVerdict = 0x3
+	//NF_REPEAT -- Run the packet through the hook again
+	NF_REPEAT NfVerdict = 0x4
+
+	//NF_VERDICT_MASK -- Bits of the verdict itself, the others carry its parameters
+	NF_VERDICT_MASK NfVerdict = 0xff
+	//NF_VERDICT_FLAG_QUEUE_BYPASS -- With NF_QUEUE, accept the packet if no program listens on the queue
+	NF_VERDICT_FLAG_QUEUE_BYPASS NfVerdict = 0x8000
+	//NF_VERDICT_QBITS -- Shift of the queue number in a NF_QUEUE verdict
+	NF_VERDICT_QBITS = 16
+)
@@ -14,19 +14,50 @@ import (
 func GetPacketInfo(attr map[int]*common.NfAttrResponsePayload) (int, int, []byte) {
 	var packetID, mark int
 
-	if nfqaPacketHdr, ok := attr[int(NfqaPacketHdr)]; ok {
+	if nfqaPacketHdr, ok := attr[int(NfqaPacketHdr)]; ok && len(nfqaPacketHdr.GetNetlinkData()) >= 4 {
 		packetID = int(native.Uint32(nfqaPacketHdr.GetNetlinkData()))
 	}
-	if nfqaMark, ok := attr[int(NfqaMark)]; ok {
+	if nfqaMark, ok := attr[int(NfqaMark)]; ok && len(nfqaMark.GetNetlinkData()) >= 4 {
 		mark = int(binary.BigEndian.Uint32(nfqaMark.GetNetlinkData()))
 	}
-	if nfqaPayload, ok := attr[int(NfqaPayload)]; ok {
+	if nfqaPayload, ok := attr[int(NfqaPayload)]; ok && nfqaPayload.GetNetlinkData() != nil {
 		return packetID, mark, nfqaPayload.GetNetlinkData()
 	}
 
 	return packetID, mark, []byte{}
 }
 
+//GetPacketLength -- Length of the packet in the kernel
+//It is larger than the payload when only part of the packet was copied (NfqnlCopyMeta or a copy
+//range smaller than the packet), the payload length otherwise.
+func GetPacketLength(attr map[int]*common.NfAttrResponsePayload) int {
+	if nfqaCapLen, ok := attr[int(NfqaCapLen)]; ok && len(nfqaCapLen.GetNetlinkData()) >= 4 {
+		return int(binary.BigEndian.Uint32(nfqaCapLen.GetNetlinkData()))
+	}
+	if nfqaPayload, ok := attr[int(NfqaPayload)]; ok {
+		return len(nfqaPayload.GetNetlinkData())
+	}
+	return 0
+}
+
+//GetSkbInfo -- NfqaSkb flags of the packet, 0 if the kernel did not set any
+func GetSkbInfo(attr map[int]*common.NfAttrResponsePayload) uint32 {
+	if nfqaSkbInfo, ok := attr[int(NfqaSkbInfo)]; ok && len(nfqaSkbInfo.GetNetlinkData()) >= 4 {
+		return binary.BigEndian.Uint32(nfqaSkbInfo.GetNetlinkData())
+	}
+	return 0
+}
+
+//QueueVerdict -- NF_QUEUE verdict moving the packet to queue num
+//bypass -- accept the packet instead of dropping it if no program listens on queue num
+func QueueVerdict(num uint16, bypass bool) NfVerdict {
+	verdict := NF_QUEUE | NfVerdict(num)<<NF_VERDICT_QBITS
+	if bypass {
+		verdict |= NF_VERDICT_FLAG_QUEUE_BYPASS
+	}
+	return verdict
+}
+
 //ToWireFormat -- Convert NfqMsgVerdictHdr to byte slice
 func (r *NfqMsgVerdictHdr) ToWireFormat() []byte {
 	buf := make([]byte, SizeofNfqMsgVerdictHdr)
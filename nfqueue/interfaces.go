@@ -2,7 +2,6 @@ package nfqueue
 
 import (
 	"context"
-	"syscall"
 
 	"go.aporeto.io/netlink-go/common"
 )
@@ -11,6 +10,12 @@ import (
 type Verdict interface {
 	SetVerdict2(queueNum uint32, verdict uint32, mark uint32, packetLen uint32, packetID uint32, packet []byte)
 	SetVerdict(queueNum uint32, verdict uint32, packetLen uint32, packetID uint32, packet []byte)
+	SetVerdictByID(packetID uint32, verdict uint32)
+	SetVerdictMarkByID(packetID uint32, verdict uint32, mark uint32)
+	Accept(packetID uint32)
+	Drop(packetID uint32)
+	Requeue(packetID uint32, num uint16, bypass bool)
+	Repeat(packetID uint32, mark uint32)
 	GetNotificationChannel() chan *NFPacket
 	StopQueue() error
 }
@@ -24,21 +29,17 @@ type NFQueue interface {
 	CreateQueue(num uint16, data func(packet *NFPacket, callback interface{}), errorCallback func(err error, data interface{}), privateData interface{}) error
 	NfqSetMode(mode nfqConfigMode, packetSize uint32) error
 	NfqSetQueueMaxLen(queuelen uint32) error
+	NfqSetFlags(mask uint32, flags uint32) error
 	NfqClose()
 	NfqDestroyQueue() error
 	Recv() (*common.NfqGenMsg, map[int]*common.NfAttrResponsePayload, error)
 	ProcessPackets(ctx context.Context)
 	BindPf() error
+	ENOBUFSCount() uint64
+	OverflowCount() uint64
+	OversizedCount() uint64
 	setSockHandle(handle SockHandle) //private unexported function for tests
 }
 
-//SockHandle Opaque interface with unexported functions
-type SockHandle interface {
-	query(msg *syscall.NetlinkMessage) error
-	recv() error
-	send(msg *syscall.NetlinkMessage) error
-	getFd() int
-	getRcvBufSize() uint32
-	getLocalAddress() syscall.SockaddrNetlink
-	close()
-}
+//SockHandle -- Netlink socket used by the queue, shared with the other subsystems
+type SockHandle = common.SockHandle
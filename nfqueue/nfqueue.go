@@ -3,7 +3,11 @@ package nfqueue
 import (
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"reflect"
+	"runtime"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"unsafe"
@@ -40,12 +44,60 @@ import (
  */
 
 //NFPacket -- message format sent on channel
+//Buffer -- the packet. It points into the receive buffer of the queue and is only valid until the
+//callback returns, it must be copied to be kept longer. It can be passed to SetVerdict as is.
+//With WithOwnedPackets it is a copy owned by the packet, valid until Release.
+//Length -- length of the packet in the kernel, larger than Buffer when the copy was partial. The
+//verdict of a partial copy is always sent without the packet, it cannot be modified.
+//SkbInfo -- NfqaSkb flags: GSO packet, checksum not ready or not verified
+//Packets handed to the callback come from a pool, Release returns them once the verdict is set.
 type NFPacket struct {
 	Buffer      []byte
 	Mark        int
 	Xbuffer     []byte
 	QueueHandle *NfQueue
 	ID          int
+	Length      int
+	SkbInfo     uint32
+	owned       *[]byte
+}
+
+//Truncated -- True if Buffer holds only the start of the packet
+func (p *NFPacket) Truncated() bool {
+	return p.Length > len(p.Buffer)
+}
+
+//packetPool -- NFPackets handed to the callback, put back by Release
+var packetPool = sync.Pool{
+	New: func() interface{} {
+		return &NFPacket{}
+	},
+}
+
+//bufferPool -- Buffers owned packets are copied in, put back by Release
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, NfDefaultPacketSize)
+		return &buf
+	},
+}
+
+//Release -- Return the packet and the buffer it owns to the pool, they must not be used afterwards
+//Releasing a packet is optional, one which is not released is garbage collected
+func (p *NFPacket) Release() {
+	if p.owned != nil {
+		*p.owned = (*p.owned)[:0]
+		bufferPool.Put(p.owned)
+	}
+	*p = NFPacket{}
+	packetPool.Put(p)
+}
+
+//own -- Copy the packet in a pooled buffer owned by the packet
+func (p *NFPacket) own() {
+	p.owned = bufferPool.Get().(*[]byte)
+	*p.owned = append((*p.owned)[:0], p.Buffer...)
+	p.Buffer = *p.owned
 }
 
 //NfQueue Struct to hold global val for all instances of netlink socket
@@ -59,30 +111,77 @@ type NfQueue struct {
 	NotificationChannel chan *NFPacket
 	buf                 []byte
 	nfattrresponse      map[int]*common.NfAttrResponsePayload
-	hdrSlice            []byte
+	nfgenmsg            common.NfqGenMsg
+	msgs                common.MessageIterator
+	verdictLock         sync.Mutex
+	verdicts            *verdictHeaders
+	payloadHdr          [common.SizeofNfAttr]byte
+	iovecs              [4]syscall.Iovec
+	ownedPackets        bool
+	channelDelivery     bool
+	backpressure        BackpressurePolicy
+	overflowPackets     uint64
+	oversizedPackets    uint64
+	copyMode            nfqConfigMode
+	cfgFlags            uint32
 	Syscalls            syscallwrappers.Syscalls
 	acceptedPackets     uint64
 	droppedPackets      uint64
 	processedPackets    uint64
+	sockOpts            []common.SocketOption
+	enobufs             common.ENOBUFSHandler
+	batch               *common.RecvBatch
+	ackLock             sync.Mutex
+	reading             bool
+	pending             map[uint32]chan error
+	inCallback          int32
+	partial             map[uint32]struct{}
 }
 
+//ErrPacketTooLarge -- Reported to the error callback when the packet of a verdict is larger than
+//NfqnlMaxCopyRange. The verdict is sent without it: the kernel keeps the packet it queued and the
+//changes made to it, e.g. to a GSO packet, are discarded.
+var ErrPacketTooLarge = errors.New("packet too large to be sent back")
+
+//ErrConfigFromCallback -- Returned by the config calls made from the packet callback while ProcessPackets
+//runs: their ACK is read by ProcessPackets, which waits for the callback to return.
+var ErrConfigFromCallback = errors.New("config request from the packet callback")
+
+//processPacketsFunc -- Name of ProcessPackets in the stack of the goroutines running it
+var processPacketsFunc = runtime.FuncForPC(reflect.ValueOf((*NfQueue).ProcessPackets).Pointer()).Name()
+
+//errReaderStopped -- Returned to the config requests whose ACK was still expected when ProcessPackets stopped
+var errReaderStopped = errors.New("nfqueue reader stopped")
+
 var native binary.ByteOrder
 
 //NewNFQueue -- create a new NfQueue handle
-func NewNFQueue() NFQueue {
+//opts -- options applied to the handle, e.g. WithNetNS to open the socket in another network namespace
+func NewNFQueue(opts ...Option) NFQueue {
+	return newNFQueue(opts...)
+}
+
+//newNFQueue -- create a new NfQueue handle
+func newNFQueue(opts ...Option) *NfQueue {
 	nfqueueinit()
 	n := &NfQueue{
 		Syscalls:            syscallwrappers.NewSyscalls(),
 		NotificationChannel: make(chan *NFPacket, 100),
 		buf:                 make([]byte, common.NfnlBuffSize),
-		nfattrresponse:      make(map[int]*common.NfAttrResponsePayload, 3),
-		hdrSlice:            make([]byte, int(syscall.SizeofNlMsghdr)+int(common.SizeofNfGenMsg)+int(common.NfaLength(uint16(SizeofNfqMsgVerdictHdr)))+int(common.NfaLength(uint16(SizeofNfqMsgMarkHdr)))),
+		nfattrresponse:      make(map[int]*common.NfAttrResponsePayload, 5),
+		copyMode:            NfqnlCopyPacket,
 	}
 
 	// Allocating only required buffers
 	n.nfattrresponse[int(NfqaPacketHdr)] = common.SetNetlinkData(common.NfnlBuffSize)
 	n.nfattrresponse[int(NfqaMark)] = common.SetNetlinkData(common.NfnlBuffSize)
 	n.nfattrresponse[int(NfqaPayload)] = common.SetNetlinkData(common.NfnlBuffSize)
+	n.nfattrresponse[int(NfqaCapLen)] = common.SetNetlinkData(common.NfnlBuffSize)
+	n.nfattrresponse[int(NfqaSkbInfo)] = common.SetNetlinkData(common.NfnlBuffSize)
+
+	for _, opt := range opts {
+		opt(n)
+	}
 
 	return n
 }
@@ -90,37 +189,45 @@ func NewNFQueue() NFQueue {
 //CreateAndStartNfQueue -- Wrapper to create/bind to queue set all its params and start listening for packets.
 //queueID -- the queue to create/bind
 //maxPacketsInQueue -- max number of packets in Queue
-//packetSize -- The max expected packetsize
+//packetSize -- The max expected packetsize, the copy range. Larger packets are truncated, see NFPacket.Length
 //privateData -- We will return this on NFpacket.Opaque data for this system.
-func CreateAndStartNfQueue(ctx context.Context, queueID uint16, maxPacketsInQueue uint32, packetSize uint32, callback func(*NFPacket, interface{}), errorCallback func(err error, data interface{}), privateData interface{}) (Verdict, error) {
-	queuingHandle := NewNFQueue()
+//opts -- options applied to the handle
+func CreateAndStartNfQueue(ctx context.Context, queueID uint16, maxPacketsInQueue uint32, packetSize uint32, callback func(*NFPacket, interface{}), errorCallback func(err error, data interface{}), privateData interface{}, opts ...Option) (Verdict, error) {
+	queuingHandle := newNFQueue(opts...)
 
 	var err error
 	if _, err = queuingHandle.NfqOpen(); err != nil {
-		return nil, fmt.Errorf("Error opening NFQueue handle: %v ", err)
+		return nil, fmt.Errorf("Error opening NFQueue handle: %w ", err)
 	}
 	if err := queuingHandle.UnbindPf(); err != nil {
 		queuingHandle.NfqClose()
-		return nil, fmt.Errorf("Error unbinding existing NFQ handler from AfInet protocol family: %v ", err)
+		return nil, fmt.Errorf("Error unbinding existing NFQ handler from AfInet protocol family: %w ", err)
 	}
 	if err := queuingHandle.BindPf(); err != nil {
 		queuingHandle.NfqClose()
-		return nil, fmt.Errorf("Error binding to AfInet protocol family: %v ", err)
+		return nil, fmt.Errorf("Error binding to AfInet protocol family: %w ", err)
 	}
 
 	if err := queuingHandle.CreateQueue(queueID, callback, errorCallback, privateData); err != nil {
 		queuingHandle.NfqClose()
-		return nil, fmt.Errorf("Error binding to queue: %v ", err)
+		return nil, fmt.Errorf("Error binding to queue: %w ", err)
 	}
-	if err := queuingHandle.NfqSetMode(NfqnlCopyPacket, packetSize); err != nil {
+	if err := queuingHandle.NfqSetMode(queuingHandle.copyMode, packetSize); err != nil {
 		queuingHandle.NfqDestroyQueue()
 		queuingHandle.NfqClose()
-		return nil, fmt.Errorf("Unable to set packets copy mode: %v ", err)
+		return nil, fmt.Errorf("Unable to set packets copy mode: %w ", err)
 	}
 	if err := queuingHandle.NfqSetQueueMaxLen(maxPacketsInQueue); err != nil {
 		queuingHandle.NfqDestroyQueue()
 		queuingHandle.NfqClose()
-		return nil, fmt.Errorf("Unable to set max packets in queue: %v ", err)
+		return nil, fmt.Errorf("Unable to set max packets in queue: %w ", err)
+	}
+	if queuingHandle.cfgFlags != 0 {
+		if err := queuingHandle.NfqSetFlags(queuingHandle.cfgFlags, queuingHandle.cfgFlags); err != nil {
+			queuingHandle.NfqDestroyQueue()
+			queuingHandle.NfqClose()
+			return nil, fmt.Errorf("Unable to set queue flags: %w ", err)
+		}
 	}
 	go queuingHandle.ProcessPackets(ctx)
 	return queuingHandle, nil
@@ -131,102 +238,33 @@ func CreateAndStartNfQueue(ctx context.Context, queueID uint16, maxPacketsInQueu
 //Open a new socket and return it in the NfqHandle.
 //The fd for the socket is stored in an unexported handle
 func (q *NfQueue) NfqOpen() (SockHandle, error) {
-	nfqHandle := &NfqSockHandle{Syscalls: q.Syscalls, buf: q.buf}
 	q.SubscribedSubSys |= (0x1 << common.NFQUEUESUBSYSID)
-	fd, err := q.Syscalls.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_NETFILTER)
-	if err != nil {
-		return nil, err
-	}
-	nfqHandle.fd = fd
-	nfqHandle.rcvbufSize = common.NfnlBuffSize
 
-	nfqHandle.lsa.Family = syscall.AF_NETLINK
-	err = q.Syscalls.Bind(fd, &nfqHandle.lsa)
+	sockbuf := 500 * int(common.NfnlBuffSize)
+	opts := append([]common.SocketOption{
+		common.WithReadBuffer(q.buf),
+		common.WithNoENOBUFS(true),
+		common.WithRcvBufSize(sockbuf, true),
+		common.WithSndBufSize(sockbuf, true),
+	}, q.sockOpts...)
+	nfqHandle, err := common.NewSocket(q.Syscalls, opts...)
 	if err != nil {
 		return nil, err
 	}
-	opt := 1
-	sockbuf := 500 * int(common.NfnlBuffSize)
-	q.Syscalls.SetsockoptInt(fd, common.SolNetlink, syscall.NETLINK_NO_ENOBUFS, opt)
-	q.Syscalls.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUFFORCE, sockbuf)
-	q.Syscalls.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUFFORCE, sockbuf)
+
 	//This is a hunch it looks like the kernel does not support this flag for netlink socket
 	//Will need to try if this is honored from a path i did not see af_netlink.c
 	lingerconf := &syscall.Linger{
 		Onoff:  1,
 		Linger: 0,
 	}
-	syscall.SetsockoptLinger(fd, syscall.SOL_SOCKET, syscall.SO_LINGER, lingerconf)
+	syscall.SetsockoptLinger(nfqHandle.Fd(), syscall.SOL_SOCKET, syscall.SO_LINGER, lingerconf) // nolint
 	q.queueHandle = nfqHandle
 	return nfqHandle, nil
 }
 
-//query : Allows a send and a block until ack is received.
-//Returns an error is Send Failed or netlink returned an error
-//This is called with a Qhandle is a private function for the package
-func (qh *NfqSockHandle) query(msg *syscall.NetlinkMessage) error {
-
-	err := qh.send(msg)
-	if err != nil {
-		return err
-	}
-	return qh.recv()
-}
-
-func (qh *NfqSockHandle) getFd() int {
-	return qh.fd
-}
-
-func (qh *NfqSockHandle) getRcvBufSize() uint32 {
-	return qh.rcvbufSize
-
-}
-
-func (qh *NfqSockHandle) getLocalAddress() syscall.SockaddrNetlink {
-	return qh.lsa
-}
-
-func (qh *NfqSockHandle) close() {
-	qh.Syscalls.Close(qh.fd)
-}
-
-//recv --- private function to receive message on the socket corresponding to this queue.
-// Returns an error is recvfrom errors or netlink returns an error message
-func (qh *NfqSockHandle) recv() error {
-	buf := qh.buf
-	n, _, err := qh.Syscalls.Recvfrom(qh.fd, buf, 0)
-	if err != nil {
-		return fmt.Errorf("Recvfrom returned error %v", err)
-	}
-	hdr, next, _ := common.NetlinkMessageToStruct(buf[:n+1])
-
-	if hdr.Type == common.NlMsgError {
-		_, err := common.NetlinkErrMessagetoStruct(next)
-		if err.Error != 0 {
-			return fmt.Errorf("Netlink Returned errror %d", err.Error)
-		}
-	}
-
-	return nil
-
-}
-
-//send -- private function to send messages over the socket for this queue
-//returns an error if Sendto Fails
-func (qh *NfqSockHandle) send(msg *syscall.NetlinkMessage) error {
-	buf := make([]byte, syscall.SizeofNlMsghdr+len(msg.Data))
-
-	native.PutUint32(buf[0:4], msg.Header.Len)
-	native.PutUint16(buf[4:6], msg.Header.Type)
-	native.PutUint16(buf[6:8], msg.Header.Flags)
-	native.PutUint32(buf[8:12], msg.Header.Seq)
-	native.PutUint32(buf[12:16], msg.Header.Pid)
-	copy(buf[16:], msg.Data)
-	return qh.Syscalls.Sendto(qh.fd, buf, 0, &qh.lsa)
-
-}
-
 //UnbindPf -- passes an unbind command to nfnetlink for AF_INET.
+//Must not be called from the packet callback while ProcessPackets runs, it returns ErrConfigFromCallback
 func (q *NfQueue) UnbindPf() error {
 
 	config := &NfqMsgConfigCommand{
@@ -234,33 +272,15 @@ func (q *NfQueue) UnbindPf() error {
 		_pad:    116,
 		pf:      syscall.AF_INET, //nolint
 	}
-	/* NfqnlMsgConfig */
-	hdr := common.BuildNlMsgHeader(common.NfqnlMsgConfig,
-		common.NlmFRequest|common.NlmFAck,
-		0,
-	)
-
-	nfgen := common.BuildNfgenMsg(syscall.AF_UNSPEC, common.NFNetlinkV0, 0, hdr)
-	attr := common.BuildNfAttrMsg(NfqaCfgCmd, hdr, config.Length())
-	data := nfgen.ToWireFormat()
-	data = append(data, attr.ToWireFormat()...)
-	data = append(data, config.ToWireFormat()...)
-	netlinkMsg := &syscall.NetlinkMessage{
-		Header: *hdr,
-		Data:   data,
-	}
-
-	if q.queueHandle != nil {
-		return q.queueHandle.query(netlinkMsg)
-	}
-	return fmt.Errorf("NfqOpen was not called. No Socket open")
 
+	return q.sendConfig(0, NfqaCfgCmd, config.ToWireFormat())
 }
 
 //CreateQueue -- Create a queue
 //handle -- handle representing the opne netlink socket
 //num -- queue number
 //data -- private data associated with the queue
+//Must not be called from the packet callback while ProcessPackets runs, it returns ErrConfigFromCallback
 func (q *NfQueue) CreateQueue(num uint16, callback func(*NFPacket, interface{}), errorCallback func(err error, data interface{}), privateData interface{}) error {
 	q.QueueNum = num
 	q.callback = callback
@@ -271,206 +291,272 @@ func (q *NfQueue) CreateQueue(num uint16, callback func(*NFPacket, interface{}),
 		_pad:    0,
 		pf:      syscall.AF_UNSPEC,
 	}
-	hdr := common.BuildNlMsgHeader(common.NfqnlMsgConfig, common.NlmFRequest|common.NlmFAck, 0)
-	nfgen := common.BuildNfgenMsg(syscall.AF_UNSPEC, common.NFNetlinkV0, num, hdr)
-	attr := common.BuildNfAttrMsg(NfqaCfgCmd, hdr, config.Length())
-	nfgenData := nfgen.ToWireFormat()
-	nfgenData = append(nfgenData, attr.ToWireFormat()...)
-	nfgenData = append(nfgenData, config.ToWireFormat()...)
-	netlinkMsg := &syscall.NetlinkMessage{
-		Header: *hdr,
-		Data:   nfgenData,
-	}
 
-	if q.queueHandle != nil {
-		return q.queueHandle.query(netlinkMsg)
-	}
-	return fmt.Errorf("NfqOpen was not called. No Socket open")
+	return q.sendConfig(num, NfqaCfgCmd, config.ToWireFormat())
 }
 
 //NfqSetMode -- Set queue mode copynone/copymeta/copypacket
 //handle -- handle representing the opne netlink socket
-//mode -- Copy mode for this queue
-//packetSize -- The range of bytes from packets to copy
+//mode -- Copy mode for this queue. Without NfqnlCopyPacket verdicts are always sent without packet.
+//packetSize -- The range of bytes from packets to copy, packets larger than it are truncated
+//Must not be called from the packet callback while ProcessPackets runs, it returns ErrConfigFromCallback
 func (q *NfQueue) NfqSetMode(mode nfqConfigMode, packetSize uint32) error {
-	hdr := common.BuildNlMsgHeader(common.NfqnlMsgConfig, common.NlmFRequest|common.NlmFAck, 0)
-	nfgen := common.BuildNfgenMsg(syscall.AF_UNSPEC, common.NFNetlinkV0, q.QueueNum, hdr)
 	config := &NfqMsgConfigParams{
 		copyMode:  uint8(mode),
 		copyRange: packetSize,
 	}
-	attr := common.BuildNfAttrMsg(NfqaCfgParams, hdr, config.Length())
-	nfgenData := nfgen.ToWireFormat()
-	nfgenData = append(nfgenData, attr.ToWireFormat()...)
-	nfgenData = append(nfgenData, config.ToWireFormat()...)
-	netlinkMsg := &syscall.NetlinkMessage{
-		Header: *hdr,
-		Data:   nfgenData,
-	}
 
-	if q.queueHandle != nil {
-		return q.queueHandle.query(netlinkMsg)
+	if err := q.sendConfig(q.QueueNum, NfqaCfgParams, config.ToWireFormat()); err != nil {
+		return err
 	}
-	return fmt.Errorf("NfqOpen was not called. No Socket open")
+	q.verdictLock.Lock()
+	q.copyMode = mode
+	q.verdictLock.Unlock()
+	return nil
+}
+
+//NfqSetFlags -- Set the queue flags in mask to their value in flags
+//mask, flags -- NfqaCfgF flags, e.g. NfqaCfgFGSO to queue GSO packets whole
+//Must not be called from the packet callback while ProcessPackets runs, it returns ErrConfigFromCallback
+func (q *NfQueue) NfqSetFlags(mask uint32, flags uint32) error {
+	req := common.NewNfnlRequest(nil, common.NfqnlMsgConfig, common.NlmFRequest|common.NlmFAck, syscall.AF_UNSPEC, q.QueueNum)
+	req.PutUint32(uint16(NfqaCfgMask), mask)
+	req.PutUint32(uint16(NfqaCfgFlags), flags)
+
+	return q.sendConfigRequest(req)
 }
 
 //NfqSetQueueMaxLen -- THe maximum number of packets in queue
 //handle -- handle representing the opne netlink socket
 //queuelen -- Length of queue
+//Must not be called from the packet callback while ProcessPackets runs, it returns ErrConfigFromCallback
 func (q *NfQueue) NfqSetQueueMaxLen(queuelen uint32) error {
-	hdr := common.BuildNlMsgHeader(common.NfqnlMsgConfig, common.NlmFRequest|common.NlmFAck, 0)
-	nfgen := common.BuildNfgenMsg(syscall.AF_UNSPEC, common.NFNetlinkV0, q.QueueNum, hdr)
 	config := &NfqMsgConfigQueueLen{
 		queueLen: queuelen,
 	}
-	attr := common.BuildNfAttrMsg(NfqaCfgQueueMaxLen, hdr, config.Length())
-	nfgenData := nfgen.ToWireFormat()
-	nfgenData = append(nfgenData, attr.ToWireFormat()...)
-	nfgenData = append(nfgenData, config.ToWireFormat()...)
-	netlinkMsg := &syscall.NetlinkMessage{
-		Header: *hdr,
-		Data:   nfgenData,
-	}
 
-	if q.queueHandle != nil {
-		return q.queueHandle.query(netlinkMsg)
-	}
-	return fmt.Errorf("NfqOpen was not called. No Socket open")
+	return q.sendConfig(q.QueueNum, NfqaCfgQueueMaxLen, config.ToWireFormat())
 }
 
 //SetVerdict -- SetVerdict on the packet -- accept/drop
+//verdict -- one of the NF_ verdicts
+//The packet is sent back to the kernel, which replaces the queued one with it. Pass a nil packet
+//when it was not modified, or use Accept and Drop, so that it is not copied back.
+//A partial copy, see NFPacket.Truncated, is not sent back and the kernel keeps the whole packet.
+//A packet larger than NfqnlMaxCopyRange is not sent back, see ErrPacketTooLarge.
+//Errors are passed to the error callback of the queue.
 func (q *NfQueue) SetVerdict(queueNum uint32, verdict uint32, packetLen uint32, packetID uint32, packet []byte) {
-	if verdict == 1 {
+	switch NfVerdict(verdict) & NF_VERDICT_MASK {
+	case NF_ACCEPT:
 		atomic.AddUint64(&q.acceptedPackets, 1)
-	} else {
+	case NF_DROP:
 		atomic.AddUint64(&q.droppedPackets, 1)
 	}
-	hdr := common.BuildNlMsgHeader(common.NfqnlMsgVerdict, common.NlmFRequest, 0)
-	nfgen := common.BuildNfgenMsg(syscall.AF_UNSPEC, common.NFNetlinkV0, q.QueueNum, hdr)
-	configVerdict := NfqMsgVerdictHdr{
-		verdict: verdict,
-		id:      packetID,
-	}
-
-	verdicthdr := common.BuildNfAttrMsg(NfqaVerdictHdr, hdr, configVerdict.Length())
-	iovecLen := hdr.Len
-
-	var payloadnfattr common.NfAttr
-
-	payloadnfattr.SetNfaLen(common.NfaLength(uint16(packetLen)))
-	payloadnfattr.SetNfaType(uint16(NfqaPayload))
-
-	payloadnfattrbuf := payloadnfattr.ToWireFormat()
-	hdr.Len += uint32(payloadnfattr.GetNfaLen())
-	iovec := make([]syscall.Iovec, 3)
-	hdrBuf := common.SerializeNlMsgHdr(hdr)
-	hdrBuf = append(hdrBuf, nfgen.ToWireFormat()...)
-	vedicthdrbuf := append(hdrBuf, verdicthdr.ToWireFormat()...)
-	vedicthdrbuf = append(vedicthdrbuf, configVerdict.ToWireFormat()...)
 
-	iovec[0].Base = &vedicthdrbuf[0]
-	iovec[0].Len = uint64(iovecLen)
+	q.verdictLock.Lock()
+	hdr := q.verdictHeaders().plain
+	binary.BigEndian.PutUint32(hdr[verdictOffset:], verdict)
+	native.PutUint32(hdr[verdictOffset+4:], packetID)
+	err := q.sendVerdict(hdr, packetLen, packet)
+	q.verdictLock.Unlock()
 
-	iovec[1].Base = &payloadnfattrbuf[0]
-	iovec[1].Len = uint64(len(payloadnfattrbuf))
-	pad := make([]byte, common.NfaAlign(uint16(packetLen))-uint16(packetLen))
-	if len(pad) > 0 {
-		packet = append(packet, pad...)
-	}
-	iovec[2].Base = &packet[0]
-	iovec[2].Len = uint64(common.NfaAlign(uint16(packetLen)))
-	q.sendmsg(q.queueHandle.getFd(), iovec)
+	q.reportVerdictError(err)
 }
 
 //SetVerdict2 -- SetVerdict on the packet -- accept/drop also mark
 func (q *NfQueue) SetVerdict2(queueNum uint32, verdict uint32, mark uint32, packetLen uint32, packetID uint32, packet []byte) {
-	hdr := common.BuildNlMsgHeader(common.NfqnlMsgVerdict, common.NlmFRequest, 0)
-	nfgen := common.BuildNfgenMsg(syscall.AF_UNSPEC, common.NFNetlinkV0, q.QueueNum, hdr)
+	q.verdictLock.Lock()
+	hdr := q.verdictHeaders().mark
+	binary.BigEndian.PutUint32(hdr[verdictOffset:], verdict)
+	native.PutUint32(hdr[verdictOffset+4:], packetID)
+	binary.BigEndian.PutUint32(hdr[markOffset:], mark)
+	err := q.sendVerdict(hdr, packetLen, packet)
+	q.verdictLock.Unlock()
+
+	q.reportVerdictError(err)
+}
 
-	configVerdict := NfqMsgVerdictHdr{
-		verdict: verdict,
-		id:      packetID,
+//reportVerdictError -- Pass the error of a verdict to the error callback
+//Called without verdictLock held, so that the callback can set verdicts
+func (q *NfQueue) reportVerdictError(err error) {
+	if err == nil {
+		return
 	}
-	configMark := NfqMsgMarkHdr{
-		mark: mark,
+	if q.errorCallback != nil {
+		q.errorCallback(err, q.privateData)
+		return
 	}
+	if !errors.Is(err, ErrPacketTooLarge) {
+		fmt.Println("Error", err)
+	}
+}
 
-	verdicthdr := common.BuildNfAttrMsg(NfqaVerdictHdr, hdr, configVerdict.Length())
-	markhdr := common.BuildNfAttrMsg(uint16(NfqaMark), hdr, configMark.Length())
-
-	iovecLen := hdr.Len
+//verdictHeaders -- Headers of the verdict messages of the queue, built on first use
+//Must be called with verdictLock held
+func (q *NfQueue) verdictHeaders() *verdictHeaders {
+	if q.verdicts == nil || q.verdicts.queueNum != q.QueueNum {
+		q.verdicts = newVerdictHeaders(q.QueueNum)
+	}
+	return q.verdicts
+}
 
-	var payloadnfattr common.NfAttr
+//Accept -- Accept the packet packetID as it was queued
+func (q *NfQueue) Accept(packetID uint32) {
+	q.SetVerdictByID(packetID, uint32(NF_ACCEPT))
+}
 
-	//payloadnfattr.SetNfaLen(common.NfaLength(uint16(packetLen)))
-	payloadnfattr.SetNfaType(uint16(NfqaPayload))
+//Drop -- Drop the packet packetID
+func (q *NfQueue) Drop(packetID uint32) {
+	q.SetVerdictByID(packetID, uint32(NF_DROP))
+}
 
-	payloadnfattr.SetNfaLen((uint16(packetLen)) + 4)
-	// sliceLength := syscall.SizeofNlMsghdr + SizeofNfGenMsg + uint32(NfaLength(uint16(configVerdict.Length()))) + uint32(NfaLength(uint16(configMark.Length())))
-	// hdrSlice := make([]byte, sliceLength)
-	hdrSlice := q.hdrSlice
-	payloadnfattrbuf := payloadnfattr.ToWireFormat()
-	hdr.Len += uint32((payloadnfattr.GetNfaLen()))
-	pad := make([]byte, common.NfaAlign(uint16(packetLen))-uint16(packetLen))
-	iovec := make([]syscall.Iovec, 3)
+//Requeue -- Move the packet packetID to queue num
+//bypass -- accept the packet instead of dropping it if no program listens on queue num
+func (q *NfQueue) Requeue(packetID uint32, num uint16, bypass bool) {
+	q.SetVerdictByID(packetID, uint32(QueueVerdict(num, bypass)))
+}
 
-	copyIndex := common.SerializeNlMsgHdrBuf(hdr, hdrSlice)
-	copyIndex += nfgen.ToWireFormatBuf(hdrSlice[copyIndex:])
-	copyIndex += verdicthdr.ToWireFormatBuf(hdrSlice[copyIndex:])
-	copyIndex += configVerdict.ToWireFormatBuf(hdrSlice[copyIndex:])
-	copyIndex += markhdr.ToWireFormatBuf(hdrSlice[copyIndex:])
-	configMark.ToWireFormatBuf(hdrSlice[copyIndex:])
+//Repeat -- Run the packet packetID through the hook again with a new mark
+//The rule which queued the packet should skip the mark, or the packet is queued again
+func (q *NfQueue) Repeat(packetID uint32, mark uint32) {
+	q.SetVerdictMarkByID(packetID, uint32(NF_REPEAT), mark)
+}
 
-	iovec[0].Base = &hdrSlice[0]
-	iovec[0].Len = uint64(iovecLen)
+//SetVerdictByID -- Set the verdict of the packet packetID without sending it back, the kernel
+//keeps the packet it queued. The packet bytes are not needed, so the verdict can be set from any
+//goroutine after the callback returned.
+func (q *NfQueue) SetVerdictByID(packetID uint32, verdict uint32) {
+	q.SetVerdict(uint32(q.QueueNum), verdict, 0, packetID, nil)
+}
 
-	iovec[1].Base = &payloadnfattrbuf[0]
-	iovec[1].Len = uint64(len(payloadnfattrbuf))
+//SetVerdictMarkByID -- SetVerdictByID also mark
+func (q *NfQueue) SetVerdictMarkByID(packetID uint32, verdict uint32, mark uint32) {
+	q.SetVerdict2(uint32(q.QueueNum), verdict, mark, 0, packetID, nil)
+}
 
-	if len(pad) > 0 {
-		packet = append(packet, pad...)
+//sendVerdict -- Send the verdict header hdr followed by the packet
+//The packet is not copied, it is passed to the kernel in its own iovec and the padding in another one.
+//Without packet no NFQA_PAYLOAD is sent, an empty one would make the kernel truncate the packet.
+//The packet is never sent when the queue does not copy whole packets, nor when it is a partial copy:
+//packetLen larger than the packet, or a packet the kernel queued cut to the copy range. The kernel
+//would trim the queued packet to the bytes sent. It is not sent either when it is larger than
+//NfqnlMaxCopyRange since its length would not fit in the attribute: the verdict is sent without it,
+//counted by OversizedCount, and ErrPacketTooLarge is returned.
+//Must be called with verdictLock held
+func (q *NfQueue) sendVerdict(hdr []byte, packetLen uint32, packet []byte) error {
+	partial := int(packetLen) > len(packet)
+	if len(q.partial) > 0 {
+		id := native.Uint32(hdr[verdictOffset+4:])
+		if _, ok := q.partial[id]; ok {
+			partial = true
+			delete(q.partial, id)
+		}
 	}
+	if partial || q.copyMode != NfqnlCopyPacket {
+		packetLen = 0
+	}
+	var tooLarge error
+	if packetLen > NfqnlMaxCopyRange {
+		atomic.AddUint64(&q.oversizedPackets, 1)
+		tooLarge = fmt.Errorf("verdict of packet %d sent without its %d bytes: %w", native.Uint32(hdr[verdictOffset+4:]), packetLen, ErrPacketTooLarge)
+		packetLen = 0
+	}
+	padLen := int(common.NfaAlign32(packetLen) - packetLen)
+
+	iovecs := q.iovecs[:1]
+	iovecs[0].Base = &hdr[0]
+	iovecs[0].SetLen(len(hdr))
+	msgLen := uint32(len(hdr))
+
+	if packetLen > 0 {
+		native.PutUint16(q.payloadHdr[:], uint16(uint32(common.SizeofNfAttr)+packetLen))
+		native.PutUint16(q.payloadHdr[2:], uint16(NfqaPayload))
+		iovecs = append(iovecs, syscall.Iovec{Base: &q.payloadHdr[0]}, syscall.Iovec{Base: &packet[0]})
+		iovecs[1].SetLen(len(q.payloadHdr))
+		iovecs[2].SetLen(int(packetLen))
+		msgLen += uint32(len(q.payloadHdr)) + packetLen
+
+		if padLen > 0 {
+			iovecs = append(iovecs, syscall.Iovec{Base: &verdictPad[0]})
+			iovecs[3].SetLen(padLen)
+			msgLen += uint32(padLen)
+		}
+	}
+	native.PutUint32(hdr, msgLen)
 
-	iovec[2].Base = &packet[0]
-	iovec[2].Len = uint64((len(packet)))
-
-	q.sendmsg(q.queueHandle.getFd(), iovec)
-
+	if err := q.queueHandle.Sendmsg(iovecs); err != nil {
+		return err
+	}
+	return tooLarge
 }
 
 //Recv -- Recv packets from socket and parse them return nfgen and nfattr slices
+//A read can return several packets, they are returned one per call before the socket is read again.
+//With WithBatchRecv a read returns several datagrams, they are all parsed before the next read.
+//The nfgen header and the attributes are reused and point into the receive buffer, they are only
+//valid until the next call.
 func (q *NfQueue) Recv() (*common.NfqGenMsg, map[int]*common.NfAttrResponsePayload, error) {
-	var err error
-	buf := q.buf
-	n, _, err := q.Syscalls.Recvfrom(q.queueHandle.getFd(), buf, syscall.MSG_WAITALL)
-	if err != nil {
-		return nil, nil, fmt.Errorf("Unable to read from socket %v", err)
-	}
-	hdr, payload, err := common.NetlinkMessageToStruct(buf[:n])
+	for {
+		for q.msgs.Next() {
+			hdr := q.msgs.Header()
+			if hdr.Type == syscall.NLMSG_ERROR && q.ackRequest(hdr.Seq, q.msgs.MessageErr()) {
+				continue
+			}
+			if err := q.msgs.MessageErr(); err != nil {
+				return nil, nil, err
+			}
+			if hdr.Type != uint16(common.NfqnlMsgPacket) {
+				continue
+			}
 
-	if hdr.Type == common.NlMsgError {
-		_, err := common.NetlinkErrMessagetoStruct(payload)
-		if err.Error != 0 {
-			return nil, nil, fmt.Errorf("Netlink Returned errror %d", err.Error)
+			payload, err := common.NetlinkMessageToNfGenStructBuf(q.msgs.Data(), &q.nfgenmsg)
+			if err != nil {
+				return nil, nil, fmt.Errorf("NfGen struct format invalid : %v", err)
+			}
+
+			nfattrmsg, _, err := common.NetlinkMessageToNfAttrStruct(payload, q.nfattrresponse)
+
+			return &q.nfgenmsg, nfattrmsg, err
+		}
+		if err := q.msgs.Err(); err != nil {
+			q.msgs = common.NewMessageIterator(nil)
+			return nil, nil, fmt.Errorf("Netlink message format invalid : %w", err)
 		}
-	}
-	if err != nil {
-		//fmt.Printf("HEader Type %v,Header Length %v Flags %x\n", hdr.Type, hdr.Len, hdr.Flags)
-		return nil, nil, fmt.Errorf("Netlink message format invalid : %v", err)
-	}
-	nfgenmsg, payload, err := common.NetlinkMessageToNfGenStruct(payload)
 
-	if err != nil {
-		return nil, nil, fmt.Errorf("NfGen struct format invalid : %v", err)
+		buf, err := q.recvDatagram()
+		if err != nil {
+			return nil, nil, fmt.Errorf("Unable to read from socket %w", err)
+		}
+		q.msgs = common.NewMessageIterator(buf)
 	}
+}
 
-	nfattrmsg, _, err := common.NetlinkMessageToNfAttrStruct(payload, q.nfattrresponse)
+//recvDatagram -- Read the next datagram, from the batch read last if it has some left
+func (q *NfQueue) recvDatagram() ([]byte, error) {
+	if q.batch == nil {
+		return q.queueHandle.Recv(syscall.MSG_WAITALL)
+	}
 
-	return nfgenmsg, nfattrmsg, err
+	for {
+		if buf, ok := q.batch.Next(); ok {
+			return buf, nil
+		}
+		if _, err := q.queueHandle.RecvBatch(q.batch, syscall.MSG_WAITFORONE); err != nil {
+			return nil, err
+		}
+	}
 }
 
 //ProcessPackets -- Function to wait on socket to receive packets and post it back to channel
+//Packets are passed to the callback, or pushed to the notification channel with WithChannelDelivery
+//While it runs it reads every message of the socket, the ACKs of the config requests included
 func (q *NfQueue) ProcessPackets(ctx context.Context) {
+	if q.channelDelivery {
+		defer close(q.NotificationChannel)
+	}
+	q.startReading()
+	defer q.stopReading()
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -478,10 +564,13 @@ func (q *NfQueue) ProcessPackets(ctx context.Context) {
 			return
 		default:
 			nfgenmsg, attr, err := q.Recv()
+			if q.enobufs.Handle(err) {
+				continue
+			}
 
 			if err != nil {
 				if q.errorCallback != nil {
-					q.errorCallback(fmt.Errorf("Netlink error %v", err), nfgenmsg)
+					q.errorCallback(fmt.Errorf("Netlink error %w", err), nfgenmsg)
 					continue
 				} else {
 					fmt.Println("Received Error from netlink", err)
@@ -493,101 +582,243 @@ func (q *NfQueue) ProcessPackets(ctx context.Context) {
 
 			packetid, mark, packet := GetPacketInfo(attr)
 			atomic.AddUint64(&q.processedPackets, 1)
-			q.callback(&NFPacket{
-				Buffer:      packet,
-				Mark:        mark,
-				QueueHandle: q,
-				ID:          packetid,
-			}, q.privateData)
+			p := packetPool.Get().(*NFPacket)
+			p.Buffer = packet
+			p.Mark = mark
+			p.QueueHandle = q
+			p.ID = packetid
+			p.Length = GetPacketLength(attr)
+			p.SkbInfo = GetSkbInfo(attr)
+			if p.Truncated() {
+				q.trackPartial(uint32(packetid))
+			}
+			if q.ownedPackets {
+				p.own()
+			}
+			if q.channelDelivery {
+				q.deliver(ctx, p)
+				continue
+			}
+			q.runCallback(p)
+		}
+	}
+}
+
+//runCallback -- Pass the packet to the callback, the config requests it makes fail with ErrConfigFromCallback
+func (q *NfQueue) runCallback(p *NFPacket) {
+	atomic.StoreInt32(&q.inCallback, 1)
+	defer atomic.StoreInt32(&q.inCallback, 0)
+
+	q.callback(p, q.privateData)
+}
+
+//fromCallback -- Tell if the caller runs in the packet callback, under ProcessPackets in its stack.
+//The requests of the other goroutines get their ACK once the callback returned.
+func (q *NfQueue) fromCallback() bool {
+	if atomic.LoadInt32(&q.inCallback) == 0 {
+		return false
+	}
+
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(2, pcs)
+	for n == len(pcs) {
+		pcs = make([]uintptr, 2*len(pcs))
+		n = runtime.Callers(2, pcs)
+	}
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if frame.Function == processPacketsFunc {
+			return true
+		}
+		if !more {
+			return false
+		}
+	}
+}
+
+//trackPartial -- Remember that the packet packetID was copied partially, its verdict is sent without it
+func (q *NfQueue) trackPartial(packetID uint32) {
+	q.verdictLock.Lock()
+	if q.partial == nil {
+		q.partial = make(map[uint32]struct{})
+	}
+	q.partial[packetID] = struct{}{}
+	q.verdictLock.Unlock()
+}
+
+//deliver -- Push the packet to the notification channel, applying the backpressure policy when it is full
+func (q *NfQueue) deliver(ctx context.Context, p *NFPacket) {
+	select {
+	case q.NotificationChannel <- p:
+		return
+	default:
+	}
+
+	switch q.backpressure {
+	case BackpressureAccept, BackpressureDrop:
+		atomic.AddUint64(&q.overflowPackets, 1)
+		if q.backpressure == BackpressureDrop {
+			q.Drop(uint32(p.ID))
+		} else {
+			q.Accept(uint32(p.ID))
+		}
+		p.Release()
+	default:
+		select {
+		case q.NotificationChannel <- p:
+		case <-ctx.Done():
+			p.Release()
 		}
 	}
 }
 
+//OverflowCount -- Number of packets given a verdict without being delivered because the
+//notification channel was full
+func (q *NfQueue) OverflowCount() uint64 {
+	return atomic.LoadUint64(&q.overflowPackets)
+}
+
+//OversizedCount -- Number of verdicts sent without their packet because it was larger than
+//NfqnlMaxCopyRange, see ErrPacketTooLarge
+func (q *NfQueue) OversizedCount() uint64 {
+	return atomic.LoadUint64(&q.oversizedPackets)
+}
+
+//ENOBUFSCount -- Number of ENOBUFS counted by the common.ENOBUFSCount and common.ENOBUFSResync policies
+func (q *NfQueue) ENOBUFSCount() uint64 {
+	return q.enobufs.Count()
+}
+
 //BindPf -- Bind to a PF family
+//Must not be called from the packet callback while ProcessPackets runs, it returns ErrConfigFromCallback
 func (q *NfQueue) BindPf() error {
 	config := &NfqMsgConfigCommand{
 		Command: NfqnlCfgCmdPfBind,
 		_pad:    0,
 		pf:      syscall.AF_INET,
 	}
-	hdr := common.BuildNlMsgHeader(common.NfqnlMsgConfig, common.NlmFRequest|common.NlmFAck, 0)
-	nfgen := common.BuildNfgenMsg(syscall.AF_UNSPEC, common.NFNetlinkV0, 0, hdr)
-	attr := common.BuildNfAttrMsg(NfqaCfgCmd, hdr, config.Length())
-	data := nfgen.ToWireFormat()
-	data = append(data, attr.ToWireFormat()...)
-	data = append(data, config.ToWireFormat()...)
-	netlinkMsg := &syscall.NetlinkMessage{
-		Header: *hdr,
-		Data:   data,
-	}
 
-	if q.queueHandle != nil {
-		return q.queueHandle.query(netlinkMsg)
-	}
-	return fmt.Errorf("NfqOpen was not called. No Socket open")
+	return q.sendConfig(0, NfqaCfgCmd, config.ToWireFormat())
 }
 
 //GetNotificationChannel -- Return a handle to the notification channel
+//Packets are only pushed to it with WithChannelDelivery
 func (q *NfQueue) GetNotificationChannel() chan *NFPacket {
 	return q.NotificationChannel
 }
 
-//sendmsg -- wrapper around syscall.SYS_SENDMSG. need to populate msgHdr struct
-func (q *NfQueue) sendmsg(fd int, iovecs []syscall.Iovec) {
-	msg := &syscall.Msghdr{}
-	lsa := q.queueHandle.getLocalAddress()
-	msg.Name = (*byte)(unsafe.Pointer(&lsa))
-	msg.Control = nil
-	msg.Controllen = 0
-	msg.Namelen = syscall.SizeofSockaddrNetlink
-	msg.Iov = &iovecs[0]
-	msg.Iovlen = uint64(len(iovecs))
-	msg.Flags = 0
-	_, _, err := q.Syscalls.Syscall(syscall.SYS_SENDMSG, uintptr(fd), uintptr(unsafe.Pointer(msg)), uintptr(0))
-
-	if err != 0 {
-		fmt.Println("Error", err)
-	}
-}
-
 //NfqClose -- Close the netlink socket for this queue
 func (q *NfQueue) NfqClose() {
 	if q.queueHandle != nil {
-		q.queueHandle.close()
+		q.queueHandle.Close() // nolint
 	}
 
 }
 
 //StopQueue -- Destroy queue and close socket
+//Must not be called from the packet callback while ProcessPackets runs, it returns ErrConfigFromCallback
 func (q *NfQueue) StopQueue() error {
 	if err := q.NfqDestroyQueue(); err != nil {
 		return err
 	}
-	q.queueHandle.close()
+	q.queueHandle.Close() // nolint
 	return nil
 }
 
 //NfqDestroyQueue -- unbind queue
+//Must not be called from the packet callback while ProcessPackets runs, it returns ErrConfigFromCallback
 func (q *NfQueue) NfqDestroyQueue() error {
 	config := &NfqMsgConfigCommand{
 		Command: NfqnlCfgCmdUnbind, //NFQNL_CFG_CMD_BIND,
 		_pad:    0,
 		pf:      syscall.AF_UNSPEC,
 	}
-	hdr := common.BuildNlMsgHeader(common.NfqnlMsgConfig, common.NlmFRequest|common.NlmFAck, 0)
-	nfgen := common.BuildNfgenMsg(syscall.AF_UNSPEC, common.NFNetlinkV0, q.QueueNum, hdr)
-	attr := common.BuildNfAttrMsg(NfqaCfgCmd, hdr, config.Length())
-	nfgenData := nfgen.ToWireFormat()
-	nfgenData = append(nfgenData, attr.ToWireFormat()...)
-	nfgenData = append(nfgenData, config.ToWireFormat()...)
-	netlinkMsg := &syscall.NetlinkMessage{
-		Header: *hdr,
-		Data:   nfgenData,
+
+	return q.sendConfig(q.QueueNum, NfqaCfgCmd, config.ToWireFormat())
+}
+
+//sendConfig -- Send a NfqnlMsgConfig request with one attribute and wait for the ACK
+//resID -- queue number the request applies to, 0 for the PF commands
+func (q *NfQueue) sendConfig(resID uint16, attrType uint16, data []byte) error {
+	req := common.NewNfnlRequest(nil, common.NfqnlMsgConfig, common.NlmFRequest|common.NlmFAck, syscall.AF_UNSPEC, resID)
+	req.PutBytes(attrType, data)
+
+	return q.sendConfigRequest(req)
+}
+
+//sendConfigRequest -- Send a NfqnlMsgConfig request and wait for the ACK
+//Once ProcessPackets runs it reads every message of the socket, the ACK is handed over by Recv.
+//Before that the ACK is read in the receive buffer, ProcessPackets waits for it to start reading.
+//Requests made from the packet callback would wait for their ACK forever, they fail with ErrConfigFromCallback.
+func (q *NfQueue) sendConfigRequest(req *common.NfnlRequest) error {
+	if q.queueHandle == nil {
+		return fmt.Errorf("NfqOpen was not called. No Socket open")
 	}
-	if q.queueHandle != nil {
-		return q.queueHandle.query(netlinkMsg)
+	if err := req.Err(); err != nil {
+		return err
+	}
+
+	msg := req.Message()
+	q.ackLock.Lock()
+	if !q.reading {
+		defer q.ackLock.Unlock()
+		return q.queueHandle.Query(msg)
+	}
+	if q.fromCallback() {
+		q.ackLock.Unlock()
+		return ErrConfigFromCallback
+	}
+	msg.Header.Seq = q.queueHandle.NextSeq()
+	msg.Header.Pid = q.queueHandle.LocalAddress().Pid
+	ack := make(chan error, 1)
+	q.pending[msg.Header.Seq] = ack
+	q.ackLock.Unlock()
+
+	if err := q.queueHandle.Send(msg); err != nil {
+		q.ackLock.Lock()
+		delete(q.pending, msg.Header.Seq)
+		q.ackLock.Unlock()
+		return err
+	}
+
+	return <-ack
+}
+
+//startReading -- From now on the ACKs of the config requests are read by ProcessPackets
+func (q *NfQueue) startReading() {
+	q.ackLock.Lock()
+	defer q.ackLock.Unlock()
+
+	q.reading = true
+	q.pending = map[uint32]chan error{}
+}
+
+//stopReading -- Fail the requests still waiting for their ACK, the next ones read it themselves
+func (q *NfQueue) stopReading() {
+	q.ackLock.Lock()
+	defer q.ackLock.Unlock()
+
+	q.reading = false
+	for seq, ack := range q.pending {
+		ack <- errReaderStopped
+		delete(q.pending, seq)
+	}
+}
+
+//ackRequest -- Hand the reply of a config request sent while ProcessPackets runs to its sender
+//Returns false if no request with this sequence number waits for a reply
+func (q *NfQueue) ackRequest(seq uint32, err error) bool {
+	q.ackLock.Lock()
+	defer q.ackLock.Unlock()
+
+	ack, ok := q.pending[seq]
+	if !ok {
+		return false
 	}
-	return fmt.Errorf("NfqOpen was not called. No Socket open")
+	delete(q.pending, seq)
+	ack <- err
+	return true
 }
 
 func (q *NfQueue) setSockHandle(handle SockHandle) {
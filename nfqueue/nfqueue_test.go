@@ -2,15 +2,21 @@ package nfqueue
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"os"
 	"syscall"
 	"testing"
+	"time"
+	"unsafe"
 
 	"github.com/golang/mock/gomock"
 	. "github.com/smartystreets/goconvey/convey"
 	"go.aporeto.io/netlink-go/common"
+	"go.aporeto.io/netlink-go/common/nltest"
 	"go.aporeto.io/netlink-go/common/syscallwrappers"
+	"golang.org/x/sys/unix"
 )
 
 var isCalled int
@@ -26,6 +32,13 @@ func passVerdict(buf *NFPacket, data interface{}) {
 func errorCallback(err error, data interface{}) {
 }
 
+// queueSockopts -- Socket options set by NfqOpen with the default buffer size
+var queueSockopts = []nltest.Sockopt{
+	nltest.NoENOBUFS,
+	nltest.RcvBufForce(500 * int(common.NfnlBuffSize)),
+	nltest.SndBufForce(500 * int(common.NfnlBuffSize)),
+}
+
 func TestNfqOpen(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -37,15 +50,9 @@ func TestNfqOpen(t *testing.T) {
 		So(newNFQ, ShouldNotBeNil)
 
 		Convey("When I try to open a socket ", func() {
-			sockrcvbuf := 500 * int(common.NfnlBuffSize)
-
 			newNFQ.(*NfQueue).Syscalls = mockSyscalls
 
-			mockSyscalls.EXPECT().Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_NETFILTER).Times(1).Return(5, nil)
-			mockSyscalls.EXPECT().Bind(5, gomock.Any()).Times(1).Return(nil)
-			mockSyscalls.EXPECT().SetsockoptInt(5, common.SolNetlink, syscall.NETLINK_NO_ENOBUFS, 1).Times(1)
-			mockSyscalls.EXPECT().SetsockoptInt(5, syscall.SOL_SOCKET, syscall.SO_RCVBUFFORCE, sockrcvbuf).Times(1)
-			mockSyscalls.EXPECT().SetsockoptInt(5, syscall.SOL_SOCKET, syscall.SO_SNDBUFFORCE, sockrcvbuf).Times(1)
+			nltest.ExpectSocket(mockSyscalls, 5, 100, queueSockopts...)
 			nfqHandle, err := newNFQ.NfqOpen()
 
 			Convey("Then I should not see any error", func() {
@@ -67,17 +74,11 @@ func TestUnbindPf(t *testing.T) {
 		So(newNFQ, ShouldNotBeNil)
 
 		Convey("When I try to Unbind a socket ", func() {
-			sockrcvbuf := 500 * int(common.NfnlBuffSize)
-
 			newNFQ.(*NfQueue).Syscalls = mockSyscalls
 			newNFQ.(*NfQueue).buf = []byte{0x45, 0x00, 0x00, 0x40, 0xf4, 0x1f, 0x40, 0x00, 0x40, 0x06, 0xa9, 0x6f, 0x0a, 0x01, 0x0a, 0x4c, 0xa4, 0x43, 0xe4, 0x98, 0xe1, 0xa1, 0x00, 0x50, 0x4d, 0xa6, 0xac, 0x48, 0x00, 0x00, 0x00, 0x00, 0xb0, 0x02, 0xff, 0xff, 0x6b, 0x6c, 0x00, 0x00, 0x02, 0x04, 0x05, 0xb4, 0x01, 0x03, 0x03, 0x05, 0x01, 0x01, 0x08, 0x0a, 0x1b, 0x4f, 0x37, 0x38, 0x00, 0x00, 0x00, 0x00, 0x04, 0x02, 0x00, 0x00}
 
 			Convey("When I try to open a socket", func() {
-				mockSyscalls.EXPECT().Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_NETFILTER).Times(1).Return(5, nil)
-				mockSyscalls.EXPECT().Bind(5, gomock.Any()).Times(1).Return(nil)
-				mockSyscalls.EXPECT().SetsockoptInt(5, common.SolNetlink, syscall.NETLINK_NO_ENOBUFS, 1).Times(1)
-				mockSyscalls.EXPECT().SetsockoptInt(5, syscall.SOL_SOCKET, syscall.SO_RCVBUFFORCE, sockrcvbuf).Times(1)
-				mockSyscalls.EXPECT().SetsockoptInt(5, syscall.SOL_SOCKET, syscall.SO_SNDBUFFORCE, sockrcvbuf).Times(1)
+				nltest.ExpectSocket(mockSyscalls, 5, 100, queueSockopts...)
 				nfqHandle, err := newNFQ.NfqOpen()
 
 				Convey("Then I should not get any error", func() {
@@ -86,8 +87,7 @@ func TestUnbindPf(t *testing.T) {
 				})
 
 				Convey("When I try to unbind a socket", func() {
-					mockSyscalls.EXPECT().Sendto(5, gomock.Any(), 0, gomock.Any()).AnyTimes()
-					mockSyscalls.EXPECT().Recvfrom(5, newNFQ.(*NfQueue).buf, 0).AnyTimes().Return(15, nil, nil)
+					nltest.ExpectQueries(mockSyscalls, 5, newNFQ.(*NfQueue).buf)
 					err := newNFQ.UnbindPf()
 					Convey("Then I should not get any error", func() {
 						So(err, ShouldBeNil)
@@ -109,17 +109,11 @@ func TestBindPf(t *testing.T) {
 		So(newNFQ, ShouldNotBeNil)
 
 		Convey("When I try to bind a socket ", func() {
-			sockrcvbuf := 500 * int(common.NfnlBuffSize)
-			mockSyscalls.EXPECT().Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_NETFILTER).Times(1).Return(5, nil)
-
 			newNFQ.(*NfQueue).Syscalls = mockSyscalls
 			newNFQ.(*NfQueue).buf = []byte{0x45, 0x00, 0x00, 0x40, 0xf4, 0x1f, 0x40, 0x00, 0x40, 0x06, 0xa9, 0x6f, 0x0a, 0x01, 0x0a, 0x4c, 0xa4, 0x43, 0xe4, 0x98, 0xe1, 0xa1, 0x00, 0x50, 0x4d, 0xa6, 0xac, 0x48, 0x00, 0x00, 0x00, 0x00, 0xb0, 0x02, 0xff, 0xff, 0x6b, 0x6c, 0x00, 0x00, 0x02, 0x04, 0x05, 0xb4, 0x01, 0x03, 0x03, 0x05, 0x01, 0x01, 0x08, 0x0a, 0x1b, 0x4f, 0x37, 0x38, 0x00, 0x00, 0x00, 0x00, 0x04, 0x02, 0x00, 0x00}
 
 			Convey("When I try to open a socket", func() {
-				mockSyscalls.EXPECT().Bind(5, gomock.Any()).Times(1).Return(nil)
-				mockSyscalls.EXPECT().SetsockoptInt(5, common.SolNetlink, syscall.NETLINK_NO_ENOBUFS, 1).Times(1)
-				mockSyscalls.EXPECT().SetsockoptInt(5, syscall.SOL_SOCKET, syscall.SO_RCVBUFFORCE, sockrcvbuf).Times(1)
-				mockSyscalls.EXPECT().SetsockoptInt(5, syscall.SOL_SOCKET, syscall.SO_SNDBUFFORCE, sockrcvbuf).Times(1)
+				nltest.ExpectSocket(mockSyscalls, 5, 100, queueSockopts...)
 				nfqHandle, err := newNFQ.NfqOpen()
 
 				Convey("Then I should not get any error", func() {
@@ -128,8 +122,7 @@ func TestBindPf(t *testing.T) {
 				})
 
 				Convey("When I try to unbind a socket", func() {
-					mockSyscalls.EXPECT().Sendto(5, gomock.Any(), 0, gomock.Any()).AnyTimes()
-					mockSyscalls.EXPECT().Recvfrom(5, newNFQ.(*NfQueue).buf, 0).AnyTimes().Return(15, nil, nil)
+					nltest.ExpectQueries(mockSyscalls, 5, newNFQ.(*NfQueue).buf)
 					err := newNFQ.UnbindPf()
 					Convey("Then I should not get any error", func() {
 						So(err, ShouldBeNil)
@@ -158,17 +151,11 @@ func TestCreateQueue(t *testing.T) {
 		So(newNFQ, ShouldNotBeNil)
 
 		Convey("When I try to bind a socket ", func() {
-			sockrcvbuf := 500 * int(common.NfnlBuffSize)
-			mockSyscalls.EXPECT().Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_NETFILTER).Times(1).Return(5, nil)
-
 			newNFQ.(*NfQueue).Syscalls = mockSyscalls
 			newNFQ.(*NfQueue).buf = []byte{0x45, 0x00, 0x00, 0x40, 0xf4, 0x1f, 0x40, 0x00, 0x40, 0x06, 0xa9, 0x6f, 0x0a, 0x01, 0x0a, 0x4c, 0xa4, 0x43, 0xe4, 0x98, 0xe1, 0xa1, 0x00, 0x50, 0x4d, 0xa6, 0xac, 0x48, 0x00, 0x00, 0x00, 0x00, 0xb0, 0x02, 0xff, 0xff, 0x6b, 0x6c, 0x00, 0x00, 0x02, 0x04, 0x05, 0xb4, 0x01, 0x03, 0x03, 0x05, 0x01, 0x01, 0x08, 0x0a, 0x1b, 0x4f, 0x37, 0x38, 0x00, 0x00, 0x00, 0x00, 0x04, 0x02, 0x00, 0x00}
 
 			Convey("When I try to open a socket", func() {
-				mockSyscalls.EXPECT().Bind(5, gomock.Any()).Times(1).Return(nil)
-				mockSyscalls.EXPECT().SetsockoptInt(5, common.SolNetlink, syscall.NETLINK_NO_ENOBUFS, 1).Times(1)
-				mockSyscalls.EXPECT().SetsockoptInt(5, syscall.SOL_SOCKET, syscall.SO_RCVBUFFORCE, sockrcvbuf).Times(1)
-				mockSyscalls.EXPECT().SetsockoptInt(5, syscall.SOL_SOCKET, syscall.SO_SNDBUFFORCE, sockrcvbuf).Times(1)
+				nltest.ExpectSocket(mockSyscalls, 5, 100, queueSockopts...)
 				nfqHandle, err := newNFQ.NfqOpen()
 				Convey("Then I should not get any error", func() {
 					So(nfqHandle, ShouldNotBeNil)
@@ -176,8 +163,7 @@ func TestCreateQueue(t *testing.T) {
 				})
 
 				Convey("When I try to unbind a socket", func() {
-					mockSyscalls.EXPECT().Sendto(5, gomock.Any(), 0, gomock.Any()).AnyTimes()
-					mockSyscalls.EXPECT().Recvfrom(5, newNFQ.(*NfQueue).buf, 0).AnyTimes().Return(15, nil, nil)
+					nltest.ExpectQueries(mockSyscalls, 5, newNFQ.(*NfQueue).buf)
 					err := newNFQ.UnbindPf()
 					Convey("Then I should not get any error", func() {
 						So(err, ShouldBeNil)
@@ -213,17 +199,11 @@ func TestNfqSetMode(t *testing.T) {
 		So(newNFQ, ShouldNotBeNil)
 
 		Convey("When I try to set mode for queue ", func() {
-			sockrcvbuf := 500 * int(common.NfnlBuffSize)
-			mockSyscalls.EXPECT().Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_NETFILTER).Times(1).Return(5, nil)
-
 			newNFQ.(*NfQueue).Syscalls = mockSyscalls
 			newNFQ.(*NfQueue).buf = []byte{0x45, 0x00, 0x00, 0x40, 0xf4, 0x1f, 0x40, 0x00, 0x40, 0x06, 0xa9, 0x6f, 0x0a, 0x01, 0x0a, 0x4c, 0xa4, 0x43, 0xe4, 0x98, 0xe1, 0xa1, 0x00, 0x50, 0x4d, 0xa6, 0xac, 0x48, 0x00, 0x00, 0x00, 0x00, 0xb0, 0x02, 0xff, 0xff, 0x6b, 0x6c, 0x00, 0x00, 0x02, 0x04, 0x05, 0xb4, 0x01, 0x03, 0x03, 0x05, 0x01, 0x01, 0x08, 0x0a, 0x1b, 0x4f, 0x37, 0x38, 0x00, 0x00, 0x00, 0x00, 0x04, 0x02, 0x00, 0x00}
 
 			Convey("When I try to open a socket", func() {
-				mockSyscalls.EXPECT().Bind(5, gomock.Any()).Times(1).Return(nil)
-				mockSyscalls.EXPECT().SetsockoptInt(5, common.SolNetlink, syscall.NETLINK_NO_ENOBUFS, 1).Times(1)
-				mockSyscalls.EXPECT().SetsockoptInt(5, syscall.SOL_SOCKET, syscall.SO_RCVBUFFORCE, sockrcvbuf).Times(1)
-				mockSyscalls.EXPECT().SetsockoptInt(5, syscall.SOL_SOCKET, syscall.SO_SNDBUFFORCE, sockrcvbuf).Times(1)
+				nltest.ExpectSocket(mockSyscalls, 5, 100, queueSockopts...)
 				nfqHandle, err := newNFQ.NfqOpen()
 				Convey("Then I should not get any error", func() {
 					So(nfqHandle, ShouldNotBeNil)
@@ -231,8 +211,7 @@ func TestNfqSetMode(t *testing.T) {
 				})
 
 				Convey("When I try to unbind a socket", func() {
-					mockSyscalls.EXPECT().Sendto(5, gomock.Any(), 0, gomock.Any()).AnyTimes()
-					mockSyscalls.EXPECT().Recvfrom(5, newNFQ.(*NfQueue).buf, 0).AnyTimes().Return(15, nil, nil)
+					nltest.ExpectQueries(mockSyscalls, 5, newNFQ.(*NfQueue).buf)
 					err := newNFQ.UnbindPf()
 					Convey("Then I should not get any error", func() {
 						So(err, ShouldBeNil)
@@ -276,17 +255,11 @@ func TestNfqSetQueueMaxLen(t *testing.T) {
 		So(newNFQ, ShouldNotBeNil)
 
 		Convey("When I try to set mode for queue ", func() {
-			sockrcvbuf := 500 * int(common.NfnlBuffSize)
-			mockSyscalls.EXPECT().Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_NETFILTER).Times(1).Return(5, nil)
-
 			newNFQ.(*NfQueue).Syscalls = mockSyscalls
 			newNFQ.(*NfQueue).buf = []byte{0x45, 0x00, 0x00, 0x40, 0xf4, 0x1f, 0x40, 0x00, 0x40, 0x06, 0xa9, 0x6f, 0x0a, 0x01, 0x0a, 0x4c, 0xa4, 0x43, 0xe4, 0x98, 0xe1, 0xa1, 0x00, 0x50, 0x4d, 0xa6, 0xac, 0x48, 0x00, 0x00, 0x00, 0x00, 0xb0, 0x02, 0xff, 0xff, 0x6b, 0x6c, 0x00, 0x00, 0x02, 0x04, 0x05, 0xb4, 0x01, 0x03, 0x03, 0x05, 0x01, 0x01, 0x08, 0x0a, 0x1b, 0x4f, 0x37, 0x38, 0x00, 0x00, 0x00, 0x00, 0x04, 0x02, 0x00, 0x00}
 
 			Convey("When I try to open a socket", func() {
-				mockSyscalls.EXPECT().Bind(5, gomock.Any()).Times(1).Return(nil)
-				mockSyscalls.EXPECT().SetsockoptInt(5, common.SolNetlink, syscall.NETLINK_NO_ENOBUFS, 1).Times(1)
-				mockSyscalls.EXPECT().SetsockoptInt(5, syscall.SOL_SOCKET, syscall.SO_RCVBUFFORCE, sockrcvbuf).Times(1)
-				mockSyscalls.EXPECT().SetsockoptInt(5, syscall.SOL_SOCKET, syscall.SO_SNDBUFFORCE, sockrcvbuf).Times(1)
+				nltest.ExpectSocket(mockSyscalls, 5, 100, queueSockopts...)
 				nfqHandle, err := newNFQ.NfqOpen()
 				Convey("Then I should not get any error", func() {
 					So(nfqHandle, ShouldNotBeNil)
@@ -294,8 +267,7 @@ func TestNfqSetQueueMaxLen(t *testing.T) {
 				})
 
 				Convey("When I try to unbind a socket", func() {
-					mockSyscalls.EXPECT().Sendto(5, gomock.Any(), 0, gomock.Any()).AnyTimes()
-					mockSyscalls.EXPECT().Recvfrom(5, newNFQ.(*NfQueue).buf, 0).AnyTimes().Return(15, nil, nil)
+					nltest.ExpectQueries(mockSyscalls, 5, newNFQ.(*NfQueue).buf)
 					err := newNFQ.UnbindPf()
 					Convey("Then I should not get any error", func() {
 						So(err, ShouldBeNil)
@@ -346,17 +318,11 @@ func TestNfqClose(t *testing.T) {
 		So(newNFQ, ShouldNotBeNil)
 
 		Convey("When I try to set mode for queue ", func() {
-			sockrcvbuf := 500 * int(common.NfnlBuffSize)
-
 			newNFQ.(*NfQueue).Syscalls = mockSyscalls
 			newNFQ.(*NfQueue).buf = []byte{0x45, 0x00, 0x00, 0x40, 0xf4, 0x1f, 0x40, 0x00, 0x40, 0x06, 0xa9, 0x6f, 0x0a, 0x01, 0x0a, 0x4c, 0xa4, 0x43, 0xe4, 0x98, 0xe1, 0xa1, 0x00, 0x50, 0x4d, 0xa6, 0xac, 0x48, 0x00, 0x00, 0x00, 0x00, 0xb0, 0x02, 0xff, 0xff, 0x6b, 0x6c, 0x00, 0x00, 0x02, 0x04, 0x05, 0xb4, 0x01, 0x03, 0x03, 0x05, 0x01, 0x01, 0x08, 0x0a, 0x1b, 0x4f, 0x37, 0x38, 0x00, 0x00, 0x00, 0x00, 0x04, 0x02, 0x00, 0x00}
 
 			Convey("When I try to open a socket", func() {
-				mockSyscalls.EXPECT().Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_NETFILTER).Times(1).Return(5, nil)
-				mockSyscalls.EXPECT().Bind(5, gomock.Any()).Times(1).Return(nil)
-				mockSyscalls.EXPECT().SetsockoptInt(5, common.SolNetlink, syscall.NETLINK_NO_ENOBUFS, 1).Times(1)
-				mockSyscalls.EXPECT().SetsockoptInt(5, syscall.SOL_SOCKET, syscall.SO_RCVBUFFORCE, sockrcvbuf).Times(1)
-				mockSyscalls.EXPECT().SetsockoptInt(5, syscall.SOL_SOCKET, syscall.SO_SNDBUFFORCE, sockrcvbuf).Times(1)
+				nltest.ExpectSocket(mockSyscalls, 5, 100, queueSockopts...)
 				nfqHandle, err := newNFQ.NfqOpen()
 				Convey("Then I should not get any error", func() {
 					So(nfqHandle, ShouldNotBeNil)
@@ -364,8 +330,7 @@ func TestNfqClose(t *testing.T) {
 				})
 
 				Convey("When I try to unbind a socket", func() {
-					mockSyscalls.EXPECT().Sendto(5, gomock.Any(), 0, gomock.Any()).AnyTimes()
-					mockSyscalls.EXPECT().Recvfrom(5, newNFQ.(*NfQueue).buf, 0).AnyTimes().Return(15, nil, nil)
+					nltest.ExpectQueries(mockSyscalls, 5, newNFQ.(*NfQueue).buf)
 					err := newNFQ.UnbindPf()
 					Convey("Then I should not get any error", func() {
 						So(err, ShouldBeNil)
@@ -421,17 +386,11 @@ func TestNfqDestroyQueue(t *testing.T) {
 		So(newNFQ, ShouldNotBeNil)
 
 		Convey("When I try to set mode for queue ", func() {
-			sockrcvbuf := 500 * int(common.NfnlBuffSize)
-
 			newNFQ.(*NfQueue).Syscalls = mockSyscalls
 			newNFQ.(*NfQueue).buf = []byte{0x45, 0x00, 0x00, 0x40, 0xf4, 0x1f, 0x40, 0x00, 0x40, 0x06, 0xa9, 0x6f, 0x0a, 0x01, 0x0a, 0x4c, 0xa4, 0x43, 0xe4, 0x98, 0xe1, 0xa1, 0x00, 0x50, 0x4d, 0xa6, 0xac, 0x48, 0x00, 0x00, 0x00, 0x00, 0xb0, 0x02, 0xff, 0xff, 0x6b, 0x6c, 0x00, 0x00, 0x02, 0x04, 0x05, 0xb4, 0x01, 0x03, 0x03, 0x05, 0x01, 0x01, 0x08, 0x0a, 0x1b, 0x4f, 0x37, 0x38, 0x00, 0x00, 0x00, 0x00, 0x04, 0x02, 0x00, 0x00}
 
 			Convey("When I try to open a socket", func() {
-				mockSyscalls.EXPECT().Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_NETFILTER).Times(1).Return(5, nil)
-				mockSyscalls.EXPECT().Bind(5, gomock.Any()).Times(1).Return(nil)
-				mockSyscalls.EXPECT().SetsockoptInt(5, common.SolNetlink, syscall.NETLINK_NO_ENOBUFS, 1).Times(1)
-				mockSyscalls.EXPECT().SetsockoptInt(5, syscall.SOL_SOCKET, syscall.SO_RCVBUFFORCE, sockrcvbuf).Times(1)
-				mockSyscalls.EXPECT().SetsockoptInt(5, syscall.SOL_SOCKET, syscall.SO_SNDBUFFORCE, sockrcvbuf).Times(1)
+				nltest.ExpectSocket(mockSyscalls, 5, 100, queueSockopts...)
 				nfqHandle, err := newNFQ.NfqOpen()
 				Convey("Then I should not get any error", func() {
 					So(nfqHandle, ShouldNotBeNil)
@@ -439,8 +398,7 @@ func TestNfqDestroyQueue(t *testing.T) {
 				})
 
 				Convey("When I try to unbind a socket", func() {
-					mockSyscalls.EXPECT().Sendto(5, gomock.Any(), 0, gomock.Any()).AnyTimes()
-					mockSyscalls.EXPECT().Recvfrom(5, newNFQ.(*NfQueue).buf, 0).AnyTimes().Return(15, nil, nil)
+					nltest.ExpectQueries(mockSyscalls, 5, newNFQ.(*NfQueue).buf)
 					err := newNFQ.UnbindPf()
 					Convey("Then I should not get any error", func() {
 						So(err, ShouldBeNil)
@@ -553,6 +511,768 @@ func TestCreateQueueWithoutSocket(t *testing.T) {
 	})
 }
 
+// packetMessage builds a NFQNL_MSG_PACKET message for queue 10
+func packetMessage(id uint32, mark uint32, payload []byte) []byte {
+	req := common.NewNfnlRequest(nil, common.NfqnlMsgPacket, 0, syscall.AF_INET, 10)
+	native.PutUint32(req.Reserve(uint16(NfqaPacketHdr), 7), id)
+	req.PutUint32(uint16(NfqaMark), mark)
+	req.PutBytes(uint16(NfqaPayload), payload)
+	return req.Bytes()
+}
+
+func TestRecv(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockSyscalls := syscallwrappers.NewMockSyscalls(ctrl)
+
+	Convey("Given I create a new nfqueue with a mocked socket", t, func() {
+		newNFQ := NewNFQueue()
+		newNFQ.(*NfQueue).Syscalls = mockSyscalls
+		nltest.ExpectSocket(mockSyscalls, 3, 100, queueSockopts...)
+		_, err := newNFQ.NfqOpen()
+		So(err, ShouldBeNil)
+
+		Convey("When a single read returns several packets", func() {
+			var datagram []byte
+			datagram = append(datagram, packetMessage(1, 11, []byte{0x45, 0x00, 0x00, 0x14})...)
+			datagram = append(datagram, packetMessage(2, 12, []byte{0x45, 0x00, 0x00, 0x15, 0x01})...)
+			mockSyscalls.EXPECT().Recvfrom(3, gomock.Any(), syscall.MSG_WAITALL).Times(1).DoAndReturn(nltest.RecvMessage(datagram))
+
+			Convey("Then every packet should be returned before the socket is read again", func() {
+				_, attr, err := newNFQ.Recv()
+				So(err, ShouldBeNil)
+				id, mark, payload := GetPacketInfo(attr)
+				So(id, ShouldEqual, 1)
+				So(mark, ShouldEqual, 11)
+				So(payload, ShouldResemble, []byte{0x45, 0x00, 0x00, 0x14})
+
+				_, attr, err = newNFQ.Recv()
+				So(err, ShouldBeNil)
+				id, mark, payload = GetPacketInfo(attr)
+				So(id, ShouldEqual, 2)
+				So(mark, ShouldEqual, 12)
+				So(payload, ShouldResemble, []byte{0x45, 0x00, 0x00, 0x15, 0x01})
+			})
+		})
+
+		Convey("When a read returns a packet truncated to the copy range", func() {
+			req := common.NewNfnlRequest(nil, common.NfqnlMsgPacket, 0, syscall.AF_INET, 10)
+			native.PutUint32(req.Reserve(uint16(NfqaPacketHdr), 7), 1)
+			req.PutBytes(uint16(NfqaPayload), []byte{0x45, 0x00, 0x05, 0xdc})
+			req.PutUint32(uint16(NfqaCapLen), 1500)
+			mockSyscalls.EXPECT().Recvfrom(3, gomock.Any(), syscall.MSG_WAITALL).Times(1).DoAndReturn(nltest.RecvMessage(req.Bytes()))
+
+			Convey("Then I should get the length of the whole packet", func() {
+				_, attr, err := newNFQ.Recv()
+				So(err, ShouldBeNil)
+				_, _, payload := GetPacketInfo(attr)
+				So(payload, ShouldHaveLength, 4)
+				So(GetPacketLength(attr), ShouldEqual, 1500)
+			})
+		})
+
+		Convey("When a read returns a GSO packet larger than 64KiB", func() {
+			payload := make([]byte, NfqnlMaxCopyRange)
+			payload[len(payload)-1] = 0xee
+			req := common.NewNfnlRequest(nil, common.NfqnlMsgPacket, 0, syscall.AF_INET, 10)
+			native.PutUint32(req.Reserve(uint16(NfqaPacketHdr), 7), 1)
+			req.PutBytes(uint16(NfqaPayload), payload)
+			req.PutUint32(uint16(NfqaCapLen), 70000)
+			req.PutUint32(uint16(NfqaSkbInfo), NfqaSkbGSO|NfqaSkbCsumNotReady)
+			mockSyscalls.EXPECT().Recvfrom(3, gomock.Any(), syscall.MSG_WAITALL).Times(1).DoAndReturn(nltest.RecvMessage(req.Bytes()))
+
+			Convey("Then the payload, length and skb flags should be decoded", func() {
+				_, attr, err := newNFQ.Recv()
+				So(err, ShouldBeNil)
+				_, _, packet := GetPacketInfo(attr)
+				So(packet, ShouldHaveLength, NfqnlMaxCopyRange)
+				So(packet[len(packet)-1], ShouldEqual, 0xee)
+				So(GetPacketLength(attr), ShouldEqual, 70000)
+				So(GetSkbInfo(attr)&NfqaSkbGSO, ShouldNotEqual, 0)
+				So(GetSkbInfo(attr)&NfqaSkbCsumNotVerified, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When a read returns a truncated message", func() {
+			mockSyscalls.EXPECT().Recvfrom(3, gomock.Any(), syscall.MSG_WAITALL).Times(1).DoAndReturn(nltest.RecvMessage(packetMessage(1, 11, []byte{0x45})[:20]))
+
+			Convey("Then I should get an error", func() {
+				_, _, err := newNFQ.Recv()
+				So(errors.Is(err, common.ErrMsgTruncated), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+// recvBatch returns a Recvmmsg stub which copies one datagram in each buffer of the batch
+func recvBatch(datagrams ...[]byte) func(int, []syscallwrappers.Mmsghdr, int) (int, error) {
+	return func(fd int, msgs []syscallwrappers.Mmsghdr, flags int) (int, error) {
+		for i, d := range datagrams {
+			iov := msgs[i].Hdr.Iov
+			msgs[i].Len = uint32(copy((*[1 << 30]byte)(unsafe.Pointer(iov.Base))[:iov.Len:iov.Len], d))
+		}
+		return len(datagrams), nil
+	}
+}
+
+func TestRecvBatch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockSyscalls := syscallwrappers.NewMockSyscalls(ctrl)
+
+	Convey("Given I create a new nfqueue reading batches of datagrams", t, func() {
+		newNFQ := NewNFQueue(WithBatchRecv(8))
+		newNFQ.(*NfQueue).Syscalls = mockSyscalls
+		nltest.ExpectSocket(mockSyscalls, 3, 100, queueSockopts...)
+		_, err := newNFQ.NfqOpen()
+		So(err, ShouldBeNil)
+
+		Convey("When one call reads several datagrams", func() {
+			mockSyscalls.EXPECT().Recvmmsg(3, gomock.Any(), syscall.MSG_WAITFORONE).Times(1).DoAndReturn(recvBatch(
+				packetMessage(1, 11, []byte{0x45, 0x00, 0x00, 0x14}),
+				packetMessage(2, 12, []byte{0x45, 0x00, 0x00, 0x15}),
+				packetMessage(3, 13, []byte{0x45, 0x00, 0x00, 0x16}),
+			))
+
+			Convey("Then every packet should be returned before the socket is read again", func() {
+				for i := uint32(1); i <= 3; i++ {
+					_, attr, err := newNFQ.Recv()
+					So(err, ShouldBeNil)
+					id, mark, _ := GetPacketInfo(attr)
+					So(id, ShouldEqual, i)
+					So(mark, ShouldEqual, 10+i)
+				}
+			})
+		})
+	})
+}
+
+// pairSyscalls hands one end of a socketpair out in place of the netlink socket, so the
+// benchmarks measure the real receive syscalls without privileges
+type pairSyscalls struct {
+	syscallwrappers.Syscalls
+	fd int
+}
+
+func (p *pairSyscalls) Socket(domain, typ, proto int) (int, error) {
+	return p.fd, nil
+}
+
+func (p *pairSyscalls) Bind(fd int, sa syscall.Sockaddr) error {
+	return nil
+}
+
+func (p *pairSyscalls) Getsockname(fd int) (syscall.Sockaddr, error) {
+	return &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}, nil
+}
+
+func (p *pairSyscalls) SetsockoptInt(fd, level, opt int, value int) error {
+	return nil
+}
+
+// benchmarkRecv reads b.N packets written to a socketpair and reports the packet rate
+func benchmarkRecv(b *testing.B, opts ...Option) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		b.Skip("socketpair not available: ", err)
+	}
+	defer syscall.Close(fds[1]) // nolint
+
+	q := NewNFQueue(opts...).(*NfQueue)
+	q.Syscalls = &pairSyscalls{Syscalls: syscallwrappers.NewSyscalls(), fd: fds[0]}
+	if _, err := q.NfqOpen(); err != nil {
+		b.Fatal(err)
+	}
+	defer q.queueHandle.Close() // nolint
+
+	msg := packetMessage(1, 0, make([]byte, 64))
+	go func() {
+		for i := 0; i < b.N; i++ {
+			syscall.Write(fds[1], msg) // nolint
+		}
+	}()
+
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := q.Recv(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "pkts/s")
+}
+
+func BenchmarkRecv(b *testing.B) {
+	benchmarkRecv(b)
+}
+
+func BenchmarkRecvBatch(b *testing.B) {
+	benchmarkRecv(b, WithBatchRecv(64))
+}
+
+// sentVerdicts collects the messages sent on a mocked socket. It is shared by the
+// expectations of every test run since goconvey registers them again for each leaf
+var sentVerdicts [][]byte
+
+// sentMessage gathers the iovecs of the msghdr passed to sendmsg
+func sentMessage(msghdr uintptr) []byte {
+	hdr := *(**syscall.Msghdr)(unsafe.Pointer(&msghdr))
+	iovecs := (*[4]syscall.Iovec)(unsafe.Pointer(hdr.Iov))[:hdr.Iovlen:hdr.Iovlen]
+
+	var msg []byte
+	for _, iov := range iovecs {
+		msg = append(msg, (*[1 << 30]byte)(unsafe.Pointer(iov.Base))[:iov.Len:iov.Len]...)
+	}
+	return msg
+}
+
+func TestSetVerdict(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockSyscalls := syscallwrappers.NewMockSyscalls(ctrl)
+
+	Convey("Given I create a new nfqueue with a mocked socket", t, func() {
+		newNFQ := NewNFQueue().(*NfQueue)
+		newNFQ.Syscalls = mockSyscalls
+		newNFQ.QueueNum = 10
+		nltest.ExpectSocket(mockSyscalls, 3, 100, queueSockopts...)
+		_, err := newNFQ.NfqOpen()
+		So(err, ShouldBeNil)
+
+		sentVerdicts = nil
+		mockSyscalls.EXPECT().Syscall(uintptr(unix.SYS_SENDMSG), uintptr(3), gomock.Any(), uintptr(0)).AnyTimes().DoAndReturn(func(trap, a1, a2, a3 uintptr) (uintptr, uintptr, syscall.Errno) {
+			sentVerdicts = append(sentVerdicts, sentMessage(a2))
+			return 0, 0, 0
+		})
+
+		Convey("When I set a verdict with a mark on a packet which is not aligned", func() {
+			packet := []byte{0x45, 0x00, 0x00, 0x05, 0x01, 0xff, 0xff}
+			newNFQ.SetVerdict2(10, 1, 0x11, 5, 7, packet[:5])
+
+			Convey("Then the padding should be sent without touching the bytes after the packet", func() {
+				So(sentVerdicts, ShouldHaveLength, 1)
+				expected := packetMessage(7, 0x11, packet[:5])
+				common.NativeEndian().PutUint16(expected[4:], uint16(common.NfqnlMsgVerdict))
+				expected[syscall.SizeofNlMsghdr] = syscall.AF_UNSPEC
+				common.NativeEndian().PutUint16(expected[6:], uint16(common.NlmFRequest))
+				binary.BigEndian.PutUint32(expected[verdictOffset:], 1)
+				native.PutUint32(expected[verdictOffset+4:], 7)
+				common.NativeEndian().PutUint16(expected[verdictOffset-4:], uint16(common.SizeofNfAttr)+uint16(SizeofNfqMsgVerdictHdr))
+				common.NativeEndian().PutUint16(expected[verdictOffset-2:], NfqaVerdictHdr)
+				So(sentVerdicts[0], ShouldResemble, expected)
+				So(packet[5:], ShouldResemble, []byte{0xff, 0xff})
+			})
+		})
+
+		Convey("When I set verdicts on several packets", func() {
+			newNFQ.SetVerdict(10, 0, 4, 1, []byte{0x45, 0x00, 0x00, 0x04})
+			newNFQ.SetVerdict(10, 1, 8, 2, []byte{0x45, 0x00, 0x00, 0x08, 0x01, 0x02, 0x03, 0x04})
+
+			Convey("Then each message should carry its own verdict, id and length", func() {
+				So(sentVerdicts, ShouldHaveLength, 2)
+				So(common.NativeEndian().Uint32(sentVerdicts[0]), ShouldEqual, len(sentVerdicts[0]))
+				So(binary.BigEndian.Uint32(sentVerdicts[0][verdictOffset:]), ShouldEqual, 0)
+				So(native.Uint32(sentVerdicts[0][verdictOffset+4:]), ShouldEqual, 1)
+				So(common.NativeEndian().Uint32(sentVerdicts[1]), ShouldEqual, len(sentVerdicts[1]))
+				So(binary.BigEndian.Uint32(sentVerdicts[1][verdictOffset:]), ShouldEqual, 1)
+				So(native.Uint32(sentVerdicts[1][verdictOffset+4:]), ShouldEqual, 2)
+				So(sentVerdicts[1][len(sentVerdicts[1])-8:], ShouldResemble, []byte{0x45, 0x00, 0x00, 0x08, 0x01, 0x02, 0x03, 0x04})
+			})
+		})
+
+		Convey("When I use the verdict methods", func() {
+			newNFQ.Accept(1)
+			newNFQ.Drop(2)
+			newNFQ.Requeue(3, 0x1234, true)
+			newNFQ.Repeat(4, 0x33)
+
+			Convey("Then the typed verdicts should be sent without payload", func() {
+				So(sentVerdicts, ShouldHaveLength, 4)
+				verdicts := []uint32{uint32(NF_ACCEPT), uint32(NF_DROP), 0x12348003, uint32(NF_REPEAT)}
+				for i, verdict := range verdicts {
+					So(binary.BigEndian.Uint32(sentVerdicts[i][verdictOffset:]), ShouldEqual, verdict)
+					So(native.Uint32(sentVerdicts[i][verdictOffset+4:]), ShouldEqual, i+1)
+					So(common.NativeEndian().Uint32(sentVerdicts[i]), ShouldEqual, len(sentVerdicts[i]))
+				}
+				So(sentVerdicts[0], ShouldHaveLength, verdictOffset+int(SizeofNfqMsgVerdictHdr))
+				So(binary.BigEndian.Uint32(sentVerdicts[3][markOffset:]), ShouldEqual, 0x33)
+				So(newNFQ.acceptedPackets, ShouldEqual, 1)
+				So(newNFQ.droppedPackets, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When I set the verdict of a partial copy with the length of the whole packet", func() {
+			partial := make([]byte, 64)
+			partial[0] = 0x45
+			newNFQ.SetVerdict(10, uint32(NF_ACCEPT), 1500, 5, partial)
+
+			Convey("Then no NFQA_PAYLOAD should be sent so the kernel keeps the whole packet", func() {
+				So(sentVerdicts, ShouldHaveLength, 1)
+				So(sentVerdicts[0], ShouldHaveLength, verdictOffset+int(SizeofNfqMsgVerdictHdr))
+				So(common.NativeEndian().Uint32(sentVerdicts[0]), ShouldEqual, len(sentVerdicts[0]))
+				So(native.Uint32(sentVerdicts[0][verdictOffset+4:]), ShouldEqual, 5)
+			})
+		})
+
+		Convey("When I set the verdict of a partial copy with the length of its buffer", func() {
+			partial := make([]byte, 64)
+			partial[0] = 0x45
+			newNFQ.trackPartial(6)
+			newNFQ.SetVerdict(10, uint32(NF_ACCEPT), uint32(len(partial)), 6, partial)
+			newNFQ.SetVerdict(10, uint32(NF_ACCEPT), uint32(len(partial)), 7, partial)
+
+			Convey("Then only the packets copied whole should be sent back", func() {
+				So(sentVerdicts, ShouldHaveLength, 2)
+				So(sentVerdicts[0], ShouldHaveLength, verdictOffset+int(SizeofNfqMsgVerdictHdr))
+				So(sentVerdicts[1], ShouldHaveLength, verdictOffset+int(SizeofNfqMsgVerdictHdr)+int(common.SizeofNfAttr)+len(partial))
+				So(newNFQ.partial, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I set verdicts on packets at and above the largest payload", func() {
+			var reported []error
+			newNFQ.errorCallback = func(err error, _ interface{}) {
+				reported = append(reported, err)
+			}
+			largest := make([]byte, NfqnlMaxCopyRange)
+			largest[len(largest)-1] = 0xee
+			newNFQ.SetVerdict(10, uint32(NF_ACCEPT), uint32(len(largest)), 1, largest)
+			tooLarge := make([]byte, 70000)
+			newNFQ.SetVerdict(10, uint32(NF_ACCEPT), uint32(len(tooLarge)), 2, tooLarge)
+
+			Convey("Then the lengths should not be truncated to 16 bits", func() {
+				So(sentVerdicts, ShouldHaveLength, 2)
+				hdrLen := verdictOffset + int(SizeofNfqMsgVerdictHdr)
+				So(sentVerdicts[0], ShouldHaveLength, hdrLen+int(common.SizeofNfAttr)+len(largest)+1)
+				So(common.NativeEndian().Uint32(sentVerdicts[0]), ShouldEqual, len(sentVerdicts[0]))
+				So(common.NativeEndian().Uint16(sentVerdicts[0][hdrLen:]), ShouldEqual, 0xffff)
+				So(common.NativeEndian().Uint16(sentVerdicts[0][hdrLen+2:]), ShouldEqual, NfqaPayload)
+				So(sentVerdicts[0][len(sentVerdicts[0])-2:], ShouldResemble, []byte{0xee, 0x00})
+			})
+
+			Convey("Then a packet too large for the attribute should not be sent back", func() {
+				So(sentVerdicts[1], ShouldHaveLength, verdictOffset+int(SizeofNfqMsgVerdictHdr))
+				So(common.NativeEndian().Uint32(sentVerdicts[1]), ShouldEqual, len(sentVerdicts[1]))
+				So(native.Uint32(sentVerdicts[1][verdictOffset+4:]), ShouldEqual, 2)
+			})
+
+			Convey("Then the packet too large should be reported and counted", func() {
+				So(reported, ShouldHaveLength, 1)
+				So(errors.Is(reported[0], ErrPacketTooLarge), ShouldBeTrue)
+				So(reported[0].Error(), ShouldContainSubstring, "packet 2")
+				So(newNFQ.OversizedCount(), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When I enable GSO on the queue", func() {
+			var sent []byte
+			mockSyscalls.EXPECT().Sendto(3, gomock.Any(), 0, gomock.Any()).Times(1).DoAndReturn(func(fd int, p []byte, flags int, to syscall.Sockaddr) error {
+				sent = append([]byte(nil), p...)
+				return nil
+			})
+			mockSyscalls.EXPECT().Recvfrom(3, gomock.Any(), 0).Times(1).DoAndReturn(func(fd int, p []byte, flags int) (int, syscall.Sockaddr, error) {
+				return copy(p, nltest.AckMessage(common.NativeEndian().Uint32(sent[8:]), common.NativeEndian().Uint32(sent[12:]), 0)), nil, nil
+			})
+			err := newNFQ.NfqSetFlags(NfqaCfgFGSO, NfqaCfgFGSO)
+
+			Convey("Then the mask and flags should be sent for the queue", func() {
+				So(err, ShouldBeNil)
+				So(sent, ShouldHaveLength, syscall.SizeofNlMsghdr+int(common.SizeofNfGenMsg)+16)
+				So(binary.BigEndian.Uint16(sent[syscall.SizeofNlMsghdr+2:]), ShouldEqual, 10)
+				attrs := sent[syscall.SizeofNlMsghdr+int(common.SizeofNfGenMsg):]
+				So(common.NativeEndian().Uint16(attrs[2:]), ShouldEqual, NfqaCfgMask)
+				So(binary.BigEndian.Uint32(attrs[4:]), ShouldEqual, NfqaCfgFGSO)
+				So(common.NativeEndian().Uint16(attrs[10:]), ShouldEqual, NfqaCfgFlags)
+				So(binary.BigEndian.Uint32(attrs[12:]), ShouldEqual, NfqaCfgFGSO)
+			})
+		})
+
+		Convey("When the queue copies metadata only", func() {
+			nltest.ExpectQueries(mockSyscalls, 3, newNFQ.buf)
+			So(newNFQ.NfqSetMode(NfqnlCopyMeta, 0), ShouldBeNil)
+			newNFQ.SetVerdict(10, uint32(NF_ACCEPT), 4, 5, []byte{0x45, 0x00, 0x00, 0x04})
+
+			Convey("Then the verdict should be sent without payload so the kernel keeps the original", func() {
+				So(sentVerdicts, ShouldHaveLength, 1)
+				So(sentVerdicts[0], ShouldHaveLength, verdictOffset+int(SizeofNfqMsgVerdictHdr))
+				So(common.NativeEndian().Uint32(sentVerdicts[0]), ShouldEqual, len(sentVerdicts[0]))
+			})
+		})
+
+		Convey("When I set verdicts by packet id", func() {
+			newNFQ.SetVerdictByID(3, 1)
+			newNFQ.SetVerdictMarkByID(4, 1, 0x22)
+
+			Convey("Then no payload should be sent so the kernel keeps the queued packet", func() {
+				So(sentVerdicts, ShouldHaveLength, 2)
+				So(sentVerdicts[0], ShouldHaveLength, verdictOffset+int(SizeofNfqMsgVerdictHdr))
+				So(common.NativeEndian().Uint32(sentVerdicts[0]), ShouldEqual, len(sentVerdicts[0]))
+				So(native.Uint32(sentVerdicts[0][verdictOffset+4:]), ShouldEqual, 3)
+				So(sentVerdicts[1], ShouldHaveLength, markOffset+SizeofNfqMsgMarkHdr)
+				So(common.NativeEndian().Uint32(sentVerdicts[1]), ShouldEqual, len(sentVerdicts[1]))
+				So(native.Uint32(sentVerdicts[1][verdictOffset+4:]), ShouldEqual, 4)
+				So(binary.BigEndian.Uint32(sentVerdicts[1][markOffset:]), ShouldEqual, 0x22)
+			})
+		})
+	})
+}
+
+func TestOwnedPackets(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockSyscalls := syscallwrappers.NewMockSyscalls(ctrl)
+
+	Convey("Given I create a new nfqueue delivering owned packets", t, func() {
+		newNFQ := NewNFQueue(WithOwnedPackets()).(*NfQueue)
+		newNFQ.Syscalls = mockSyscalls
+		nltest.ExpectSocket(mockSyscalls, 3, 100, queueSockopts...)
+		_, err := newNFQ.NfqOpen()
+		So(err, ShouldBeNil)
+
+		Convey("When packets are kept after the callback returned", func() {
+			gomock.InOrder(
+				mockSyscalls.EXPECT().Recvfrom(3, gomock.Any(), syscall.MSG_WAITALL).Times(1).DoAndReturn(nltest.RecvMessage(packetMessage(1, 11, []byte{0x45, 0x00, 0x00, 0x14}))),
+				mockSyscalls.EXPECT().Recvfrom(3, gomock.Any(), syscall.MSG_WAITALL).Times(1).DoAndReturn(nltest.RecvMessage(packetMessage(2, 12, []byte{0x45, 0x01, 0x00, 0x15}))),
+			)
+			mockSyscalls.EXPECT().Close(3).Times(1)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			var kept []*NFPacket
+			newNFQ.callback = func(p *NFPacket, data interface{}) {
+				kept = append(kept, p)
+				if len(kept) == 2 {
+					cancel()
+				}
+			}
+			newNFQ.ProcessPackets(ctx)
+
+			Convey("Then each packet should still hold its own bytes", func() {
+				So(kept, ShouldHaveLength, 2)
+				So(kept[0].ID, ShouldEqual, 1)
+				So(kept[0].Buffer, ShouldResemble, []byte{0x45, 0x00, 0x00, 0x14})
+				So(kept[1].ID, ShouldEqual, 2)
+				So(kept[1].Buffer, ShouldResemble, []byte{0x45, 0x01, 0x00, 0x15})
+				kept[0].Release()
+				kept[1].Release()
+			})
+		})
+	})
+}
+
+func TestPartialCopy(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockSyscalls := syscallwrappers.NewMockSyscalls(ctrl)
+
+	Convey("Given I process a queue copying only the start of the packets", t, func() {
+		newNFQ := NewNFQueue().(*NfQueue)
+		newNFQ.Syscalls = mockSyscalls
+		nltest.ExpectSocket(mockSyscalls, 3, 100, queueSockopts...)
+		_, err := newNFQ.NfqOpen()
+		So(err, ShouldBeNil)
+
+		Convey("When the callback sets the verdict of a partial copy with its buffer", func() {
+			req := common.NewNfnlRequest(nil, common.NfqnlMsgPacket, 0, syscall.AF_INET, 10)
+			native.PutUint32(req.Reserve(uint16(NfqaPacketHdr), 7), 1)
+			req.PutBytes(uint16(NfqaPayload), []byte{0x45, 0x00, 0x05, 0xdc})
+			req.PutUint32(uint16(NfqaCapLen), 1500)
+			mockSyscalls.EXPECT().Recvfrom(3, gomock.Any(), syscall.MSG_WAITALL).Times(1).DoAndReturn(nltest.RecvMessage(req.Bytes()))
+			sentVerdicts = nil
+			mockSyscalls.EXPECT().Syscall(uintptr(unix.SYS_SENDMSG), uintptr(3), gomock.Any(), uintptr(0)).Times(1).DoAndReturn(func(trap, a1, a2, a3 uintptr) (uintptr, uintptr, syscall.Errno) {
+				sentVerdicts = append(sentVerdicts, sentMessage(a2))
+				return 0, 0, 0
+			})
+			mockSyscalls.EXPECT().Close(3).Times(1)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			newNFQ.callback = func(p *NFPacket, data interface{}) {
+				So(p.Truncated(), ShouldBeTrue)
+				p.QueueHandle.SetVerdict(10, uint32(NF_ACCEPT), uint32(len(p.Buffer)), uint32(p.ID), p.Buffer)
+				p.Release()
+				cancel()
+			}
+			newNFQ.ProcessPackets(ctx)
+
+			Convey("Then the verdict should be sent without the packet", func() {
+				So(sentVerdicts, ShouldHaveLength, 1)
+				So(sentVerdicts[0], ShouldHaveLength, verdictOffset+int(SizeofNfqMsgVerdictHdr))
+				So(newNFQ.partial, ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+func TestChannelDelivery(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockSyscalls := syscallwrappers.NewMockSyscalls(ctrl)
+
+	Convey("Given I create a new nfqueue delivering on a channel of one packet which drops on overflow", t, func() {
+		newNFQ := NewNFQueue(WithChannelDelivery(1, BackpressureDrop)).(*NfQueue)
+		newNFQ.Syscalls = mockSyscalls
+		newNFQ.errorCallback = errorCallback
+		nltest.ExpectSocket(mockSyscalls, 3, 100, queueSockopts...)
+		_, err := newNFQ.NfqOpen()
+		So(err, ShouldBeNil)
+
+		Convey("When two packets are read before the consumer runs", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			gomock.InOrder(
+				mockSyscalls.EXPECT().Recvfrom(3, gomock.Any(), syscall.MSG_WAITALL).Times(1).DoAndReturn(nltest.RecvMessage(packetMessage(1, 11, []byte{0x45, 0x00, 0x00, 0x14}))),
+				mockSyscalls.EXPECT().Recvfrom(3, gomock.Any(), syscall.MSG_WAITALL).Times(1).DoAndReturn(nltest.RecvMessage(packetMessage(2, 12, []byte{0x45, 0x01, 0x00, 0x15}))),
+				mockSyscalls.EXPECT().Recvfrom(3, gomock.Any(), syscall.MSG_WAITALL).Times(1).DoAndReturn(func(fd int, p []byte, flags int) (int, syscall.Sockaddr, error) {
+					cancel()
+					return -1, nil, syscall.EINTR
+				}),
+			)
+			sentVerdicts = nil
+			mockSyscalls.EXPECT().Syscall(uintptr(unix.SYS_SENDMSG), uintptr(3), gomock.Any(), uintptr(0)).Times(1).DoAndReturn(func(trap, a1, a2, a3 uintptr) (uintptr, uintptr, syscall.Errno) {
+				sentVerdicts = append(sentVerdicts, sentMessage(a2))
+				return 0, 0, 0
+			})
+			mockSyscalls.EXPECT().Close(3).Times(1)
+
+			newNFQ.ProcessPackets(ctx)
+
+			Convey("Then the first packet should be delivered and the second one dropped", func() {
+				var delivered []*NFPacket
+				for p := range newNFQ.GetNotificationChannel() {
+					delivered = append(delivered, p)
+				}
+				So(delivered, ShouldHaveLength, 1)
+				So(delivered[0].ID, ShouldEqual, 1)
+				So(delivered[0].Buffer, ShouldResemble, []byte{0x45, 0x00, 0x00, 0x14})
+				delivered[0].Release()
+
+				So(newNFQ.OverflowCount(), ShouldEqual, 1)
+				So(sentVerdicts, ShouldHaveLength, 1)
+				So(binary.BigEndian.Uint32(sentVerdicts[0][verdictOffset:]), ShouldEqual, 0)
+				So(native.Uint32(sentVerdicts[0][verdictOffset+4:]), ShouldEqual, 2)
+			})
+		})
+	})
+}
+
+func TestConfigWhileProcessing(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockSyscalls := syscallwrappers.NewMockSyscalls(ctrl)
+
+	Convey("Given I process the packets of a queue", t, func() {
+		newNFQ := NewNFQueue().(*NfQueue)
+		newNFQ.Syscalls = mockSyscalls
+		nltest.ExpectSocket(mockSyscalls, 3, 100, queueSockopts...)
+		_, err := newNFQ.NfqOpen()
+		So(err, ShouldBeNil)
+
+		// The kernel acknowledges the requests on the socket the packets are read from
+		incoming := make(chan []byte, 16)
+		mockSyscalls.EXPECT().Sendto(3, gomock.Any(), 0, gomock.Any()).AnyTimes().DoAndReturn(func(fd int, p []byte, flags int, to syscall.Sockaddr) error {
+			incoming <- nltest.AckMessage(common.NativeEndian().Uint32(p[8:]), 100, 0)
+			return nil
+		})
+		mockSyscalls.EXPECT().Recvfrom(3, gomock.Any(), syscall.MSG_WAITALL).AnyTimes().DoAndReturn(func(fd int, p []byte, flags int) (int, syscall.Sockaddr, error) {
+			msg := <-incoming
+			if msg == nil {
+				return -1, nil, syscall.EBADF
+			}
+			return copy(p, msg), nil, nil
+		})
+		mockSyscalls.EXPECT().Close(3).Times(1)
+
+		packets := make(chan int, 16)
+		newNFQ.callback = func(p *NFPacket, _ interface{}) {
+			packets <- p.ID
+		}
+		newNFQ.errorCallback = errorCallback
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			newNFQ.ProcessPackets(ctx)
+			close(done)
+		}()
+		for reading := false; !reading; {
+			newNFQ.ackLock.Lock()
+			reading = newNFQ.reading
+			newNFQ.ackLock.Unlock()
+		}
+
+		Convey("When I configure the queue while packets are read", func() {
+			errs := make(chan error, 8)
+			for i := 0; i < 8; i++ {
+				go func(i int) {
+					errs <- newNFQ.NfqSetQueueMaxLen(uint32(100 + i))
+				}(i)
+				incoming <- packetMessage(uint32(i+1), 0, []byte{0x45, 0x00, 0x00, 0x14})
+			}
+
+			var ids []int
+			for i := 0; i < 8; i++ {
+				So(<-errs, ShouldBeNil)
+				ids = append(ids, <-packets)
+			}
+			cancel()
+			incoming <- nil
+			<-done
+
+			Convey("Then every request should get its ACK from the reader and every packet be delivered", func() {
+				So(ids, ShouldResemble, []int{1, 2, 3, 4, 5, 6, 7, 8})
+				So(newNFQ.reading, ShouldBeFalse)
+			})
+		})
+	})
+}
+
+// loopSyscalls returns the same datagram on every read and accepts every sendmsg without any
+// syscall, so the benchmarks count the allocations of the package alone
+type loopSyscalls struct {
+	syscallwrappers.Syscalls
+	datagram []byte
+}
+
+func (l *loopSyscalls) Socket(domain, typ, proto int) (int, error) {
+	return -1, nil
+}
+
+func (l *loopSyscalls) Bind(fd int, sa syscall.Sockaddr) error {
+	return nil
+}
+
+func (l *loopSyscalls) Getsockname(fd int) (syscall.Sockaddr, error) {
+	return &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}, nil
+}
+
+func (l *loopSyscalls) SetsockoptInt(fd, level, opt int, value int) error {
+	return nil
+}
+
+func (l *loopSyscalls) Recvfrom(fd int, p []byte, flags int) (int, syscall.Sockaddr, error) {
+	return copy(p, l.datagram), nil, nil
+}
+
+func (l *loopSyscalls) Syscall(trap, a1, a2, a3 uintptr) (uintptr, uintptr, syscall.Errno) {
+	return 0, 0, 0
+}
+
+func (l *loopSyscalls) Close(fd int) error {
+	return nil
+}
+
+// newLoopQueue opens a queue reading payload in a loop
+func newLoopQueue(b *testing.B, payload []byte) *NfQueue {
+	q := NewNFQueue().(*NfQueue)
+	q.Syscalls = &loopSyscalls{datagram: packetMessage(1, 0, payload)}
+	if _, err := q.NfqOpen(); err != nil {
+		b.Fatal(err)
+	}
+	q.QueueNum = 10
+	return q
+}
+
+func BenchmarkSetVerdict(b *testing.B) {
+	q := newLoopQueue(b, nil)
+	packet := make([]byte, 1499)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		q.SetVerdict2(10, 1, 0x11, uint32(len(packet)), uint32(i), packet)
+	}
+}
+
+func BenchmarkProcessPackets(b *testing.B) {
+	q := newLoopQueue(b, make([]byte, 1499))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var processed int
+	q.callback = func(p *NFPacket, data interface{}) {
+		p.QueueHandle.SetVerdict2(uint32(p.QueueHandle.QueueNum), 1, 0x11, uint32(len(p.Buffer)), uint32(p.ID), p.Buffer)
+		p.Release()
+		if processed++; processed == b.N {
+			cancel()
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	q.ProcessPackets(ctx)
+}
+
+func TestConfigFromCallback(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockSyscalls := syscallwrappers.NewMockSyscalls(ctrl)
+
+	Convey("Given I process the packets of a queue", t, func() {
+		newNFQ := NewNFQueue().(*NfQueue)
+		newNFQ.Syscalls = mockSyscalls
+		nltest.ExpectSocket(mockSyscalls, 3, 100, queueSockopts...)
+		_, err := newNFQ.NfqOpen()
+		So(err, ShouldBeNil)
+
+		incoming := make(chan []byte, 16)
+		sent := make(chan struct{}, 16)
+		mockSyscalls.EXPECT().Sendto(3, gomock.Any(), 0, gomock.Any()).AnyTimes().DoAndReturn(func(fd int, p []byte, flags int, to syscall.Sockaddr) error {
+			incoming <- nltest.AckMessage(common.NativeEndian().Uint32(p[8:]), 100, 0)
+			sent <- struct{}{}
+			return nil
+		})
+		mockSyscalls.EXPECT().Recvfrom(3, gomock.Any(), syscall.MSG_WAITALL).AnyTimes().DoAndReturn(func(fd int, p []byte, flags int) (int, syscall.Sockaddr, error) {
+			msg := <-incoming
+			if msg == nil {
+				return -1, nil, syscall.EBADF
+			}
+			return copy(p, msg), nil, nil
+		})
+		mockSyscalls.EXPECT().Close(3).Times(1)
+
+		fromCallback := make(chan error, 1)
+		release := make(chan struct{})
+		newNFQ.callback = func(p *NFPacket, _ interface{}) {
+			fromCallback <- p.QueueHandle.NfqSetQueueMaxLen(200)
+			<-release
+		}
+		newNFQ.errorCallback = errorCallback
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			newNFQ.ProcessPackets(ctx)
+			close(done)
+		}()
+
+		Convey("When the callback configures the queue while another goroutine does", func() {
+			incoming <- packetMessage(1, 0, []byte{0x45, 0x00, 0x00, 0x14})
+			callbackErr := <-fromCallback
+
+			other := make(chan error, 1)
+			go func() {
+				other <- newNFQ.NfqSetQueueMaxLen(300)
+			}()
+			<-sent
+			close(release)
+			otherErr := <-other
+			cancel()
+			incoming <- nil
+			<-done
+
+			Convey("Then the request of the callback should fail and the other one get its ACK after the callback", func() {
+				So(errors.Is(callbackErr, ErrConfigFromCallback), ShouldBeTrue)
+				So(otherErr, ShouldBeNil)
+				So(sent, ShouldBeEmpty)
+			})
+		})
+	})
+}
+
 func TestProcessPackets(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -565,21 +1285,15 @@ func TestProcessPackets(t *testing.T) {
 		So(newNFQ, ShouldNotBeNil)
 
 		newNFQ.(*NfQueue).Syscalls = mockSyscalls
-		newNFQ.(*NfQueue).buf = []byte{0x78, 0x00, 0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x0a, 0x0b, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x00, 0x01, 0x00, 0x08, 0x00, 0x05, 0x00, 0x00, 0x00, 0x00, 0x02, 0x10, 0x00, 0x09, 0x00, 0x00, 0x06, 0x00, 0x00, 0x52, 0x54, 0x00, 0x12, 0x35, 0x02, 0x00, 0x00, 0x14, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x59, 0x5d, 0x7a, 0xb6, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0e, 0x69, 0xe3, 0x2c, 0x00, 0x0a, 0x00, 0x45, 0x00, 0x00, 0x28, 0x6c, 0x1d, 0x00, 0x00, 0x40, 0x06, 0xf6, 0xa2, 0x0a, 0x00, 0x02, 0x02, 0x0a, 0x00, 0x02, 0x0f, 0x00, 0x50, 0xde, 0xba, 0x01, 0x7c, 0xdc, 0x02, 0xb5, 0x09, 0xc5, 0x27, 0x50, 0x11, 0xff, 0xff, 0x61, 0x08, 0x00, 0x00}
-
-		sockrcvbuf := 500 * int(common.NfnlBuffSize)
+		packetMsg := []byte{0x78, 0x00, 0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x0a, 0x0b, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x00, 0x01, 0x00, 0x08, 0x00, 0x05, 0x00, 0x00, 0x00, 0x00, 0x02, 0x10, 0x00, 0x09, 0x00, 0x00, 0x06, 0x00, 0x00, 0x52, 0x54, 0x00, 0x12, 0x35, 0x02, 0x00, 0x00, 0x14, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x59, 0x5d, 0x7a, 0xb6, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0e, 0x69, 0xe3, 0x2c, 0x00, 0x0a, 0x00, 0x45, 0x00, 0x00, 0x28, 0x6c, 0x1d, 0x00, 0x00, 0x40, 0x06, 0xf6, 0xa2, 0x0a, 0x00, 0x02, 0x02, 0x0a, 0x00, 0x02, 0x0f, 0x00, 0x50, 0xde, 0xba, 0x01, 0x7c, 0xdc, 0x02, 0xb5, 0x09, 0xc5, 0x27, 0x50, 0x11, 0xff, 0xff, 0x61, 0x08, 0x00, 0x00}
+		newNFQ.(*NfQueue).buf = make([]byte, len(packetMsg))
 
-		mockSyscalls.EXPECT().Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_NETFILTER).Times(1).Return(3, nil)
-		mockSyscalls.EXPECT().Bind(3, gomock.Any()).Times(1).Return(nil)
-		mockSyscalls.EXPECT().SetsockoptInt(3, common.SolNetlink, syscall.NETLINK_NO_ENOBUFS, 1).Times(1)
-		mockSyscalls.EXPECT().SetsockoptInt(3, syscall.SOL_SOCKET, syscall.SO_RCVBUFFORCE, sockrcvbuf).Times(1)
-		mockSyscalls.EXPECT().SetsockoptInt(3, syscall.SOL_SOCKET, syscall.SO_SNDBUFFORCE, sockrcvbuf).Times(1)
+		nltest.ExpectSocket(mockSyscalls, 3, 100, queueSockopts...)
 		nfqHandle, err := newNFQ.NfqOpen()
 		So(nfqHandle, ShouldNotBeNil)
 		So(err, ShouldBeNil)
 
-		mockSyscalls.EXPECT().Sendto(3, gomock.Any(), 0, gomock.Any()).AnyTimes()
-		mockSyscalls.EXPECT().Recvfrom(3, newNFQ.(*NfQueue).buf, 0).AnyTimes().Return(15, nil, nil)
+		nltest.ExpectQueries(mockSyscalls, 3, newNFQ.(*NfQueue).buf)
 		err = newNFQ.UnbindPf()
 		So(err, ShouldBeNil)
 
@@ -604,8 +1318,8 @@ func TestProcessPackets(t *testing.T) {
 			So(oldHeaderSlice, ShouldNotResemble, newNFQ.(*NfQueue).buf)
 		})
 		Convey("When I try to process packets, I expect the callback to be called", func() {
-			mockSyscalls.EXPECT().Recvfrom(3, newNFQ.(*NfQueue).buf, 256).AnyTimes().Return(120, nil, nil)
-			mockSyscalls.EXPECT().Syscall(uintptr(46), uintptr(3), gomock.Any(), uintptr(0)).AnyTimes()
+			mockSyscalls.EXPECT().Recvfrom(3, newNFQ.(*NfQueue).buf, 256).AnyTimes().DoAndReturn(nltest.RecvMessage(packetMsg))
+			mockSyscalls.EXPECT().Syscall(uintptr(unix.SYS_SENDMSG), uintptr(3), gomock.Any(), uintptr(0)).AnyTimes()
 			newNFQ.ProcessPackets(context.Background())
 		})
 	})
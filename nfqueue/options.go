@@ -0,0 +1,95 @@
+package nfqueue
+
+import "go.aporeto.io/netlink-go/common"
+
+//Option -- Option of a queue handle, passed to NewNFQueue or CreateAndStartNfQueue
+type Option func(*NfQueue)
+
+//WithNetNS -- Open the queue socket in the network namespace referred to by fd
+//The fd is only used while the socket is opened, the caller keeps ownership of it
+func WithNetNS(fd int) Option {
+	return func(q *NfQueue) {
+		q.sockOpts = append(q.sockOpts, common.WithNetNS(fd))
+	}
+}
+
+//WithNetNSPath -- Open the queue socket in the network namespace bound at path,
+//as /var/run/netns/name or /proc/pid/ns/net
+func WithNetNSPath(path string) Option {
+	return func(q *NfQueue) {
+		q.sockOpts = append(q.sockOpts, common.WithNetNSPath(path))
+	}
+}
+
+//WithRcvBufSize -- Size of the socket receive buffer, 500 times NfnlBuffSize by default
+//force -- use SO_RCVBUFFORCE to go over rmem_max, needs CAP_NET_ADMIN
+func WithRcvBufSize(size int, force bool) Option {
+	return func(q *NfQueue) {
+		q.sockOpts = append(q.sockOpts, common.WithRcvBufSize(size, force))
+	}
+}
+
+//WithNoENOBUFS -- Set or clear NETLINK_NO_ENOBUFS, set by default. When cleared the kernel
+//reports ENOBUFS when it drops packets because the receive buffer is full
+func WithNoENOBUFS(enable bool) Option {
+	return func(q *NfQueue) {
+		q.sockOpts = append(q.sockOpts, common.WithNoENOBUFS(enable))
+	}
+}
+
+//WithENOBUFSPolicy -- What ProcessPackets does on ENOBUFS, the error callback gets it by default
+//resync -- called with the common.ENOBUFSResync policy, may be nil
+func WithENOBUFSPolicy(policy common.ENOBUFSPolicy, resync func()) Option {
+	return func(q *NfQueue) {
+		q.enobufs.Policy = policy
+		q.enobufs.Resync = resync
+	}
+}
+
+//WithBatchRecv -- Read up to count datagrams per recvmmsg call instead of one per recvfrom
+//Each datagram gets its own buffer of NfnlBuffSize bytes
+func WithBatchRecv(count int) Option {
+	return func(q *NfQueue) {
+		q.batch = common.NewRecvBatch(count, int(common.NfnlBuffSize))
+	}
+}
+
+//WithOwnedPackets -- Copy each packet in a buffer it owns before handing it to the callback
+//The packet stays valid after the callback returns, until it is released, so it can be handed to
+//another goroutine which sets the verdict. The buffers come from a pool, Release returns them.
+func WithOwnedPackets() Option {
+	return func(q *NfQueue) {
+		q.ownedPackets = true
+	}
+}
+
+//WithChannelDelivery -- Deliver packets on the notification channel instead of the callback
+//capacity -- capacity of the channel
+//policy -- what to do with a packet when the channel is full
+//Packets on the channel own their bytes as with WithOwnedPackets, the consumer sets their verdict
+//and releases them. The channel is closed when ProcessPackets returns.
+func WithChannelDelivery(capacity int, policy BackpressurePolicy) Option {
+	return func(q *NfQueue) {
+		q.NotificationChannel = make(chan *NFPacket, capacity)
+		q.channelDelivery = true
+		q.backpressure = policy
+		q.ownedPackets = true
+	}
+}
+
+//WithCopyMode -- Copy mode CreateAndStartNfQueue sets, NfqnlCopyPacket by default
+//With NfqnlCopyMeta packets carry no payload and their verdict is sent without one, decisions are
+//made on the metadata only. The packet size passed to CreateAndStartNfQueue is then ignored.
+func WithCopyMode(mode nfqConfigMode) Option {
+	return func(q *NfQueue) {
+		q.copyMode = mode
+	}
+}
+
+//WithQueueFlags -- NfqaCfgF flags CreateAndStartNfQueue sets on the queue
+//NfqaCfgFGSO queues GSO packets whole: they are not segmented but may be larger than the copy range
+func WithQueueFlags(flags uint32) Option {
+	return func(q *NfQueue) {
+		q.cfgFlags = flags
+	}
+}
@@ -1,9 +1,7 @@
 package nfqueue
 
 import (
-	"syscall"
-
-	"go.aporeto.io/netlink-go/common/syscallwrappers"
+	"go.aporeto.io/netlink-go/common"
 )
 
 //Types for various enums needed by the nfqueue subsys in linux
@@ -11,6 +9,21 @@ type nfqaAttr int
 type nfqConfigCommands uint8
 type nfqConfigMode int
 
+//NfVerdict -- Verdict on a queued packet, one of the NF_ verdicts
+type NfVerdict uint32
+
+//BackpressurePolicy -- What ProcessPackets does with a packet when the notification channel is full
+type BackpressurePolicy int
+
+const (
+	//BackpressureBlock -- Wait for room in the channel, packets wait in the kernel queue meanwhile
+	BackpressureBlock BackpressurePolicy = iota
+	//BackpressureAccept -- Accept the packet without delivering it, the queue fails open
+	BackpressureAccept
+	//BackpressureDrop -- Drop the packet without delivering it, the queue fails closed
+	BackpressureDrop
+)
+
 //We will write a method to serialize these messages into a byte slice. So so need to be packed
 
 //NfqMsgPacketHdr PacketHdr
@@ -77,13 +90,5 @@ type NfqMsgConfigQueueLen struct {
 }
 
 //NfqSockHandle -- Sock handle of netlink socket
-//fd -- fd of socket
-//rcvbufSize -- rcv buffer Size
-//lsa -- local address
-type NfqSockHandle struct {
-	Syscalls   syscallwrappers.Syscalls
-	fd         int
-	rcvbufSize uint32
-	buf        []byte
-	lsa        syscall.SockaddrNetlink
-}
+//Deprecated: the queue uses the netlink socket shared by all the subsystems, common.Socket
+type NfqSockHandle = common.Socket
@@ -0,0 +1,44 @@
+package nfqueue
+
+import (
+	"syscall"
+
+	"go.aporeto.io/netlink-go/common"
+)
+
+const (
+	//verdictOffset -- offset of the verdict and packet id in the verdict headers
+	verdictOffset = syscall.SizeofNlMsghdr + int(common.SizeofNfGenMsg) + int(common.SizeofNfAttr)
+	//markOffset -- offset of the mark in the verdict header with a mark
+	markOffset = verdictOffset + int(SizeofNfqMsgVerdictHdr) + int(common.SizeofNfAttr)
+)
+
+//verdictPad -- zeroes sent after the packet to align it, the packet buffer is never extended
+var verdictPad [4]byte
+
+//verdictHeaders -- Verdict messages of a queue without the payload, built once.
+//Only the verdict, packet id, mark and length are written for each packet.
+//plain -- netlink header, nfgen header, NFQA_VERDICT_HDR
+//mark -- same with NFQA_MARK
+//queueNum -- queue the headers are built for
+type verdictHeaders struct {
+	plain    []byte
+	mark     []byte
+	queueNum uint16
+}
+
+//newVerdictHeaders -- Build the verdict headers of queue queueNum
+func newVerdictHeaders(queueNum uint16) *verdictHeaders {
+	v := &verdictHeaders{queueNum: queueNum}
+
+	req := common.NewNfnlRequest(nil, common.NfqnlMsgVerdict, common.NlmFRequest, syscall.AF_UNSPEC, queueNum)
+	req.Reserve(NfqaVerdictHdr, int(SizeofNfqMsgVerdictHdr))
+	v.plain = req.Bytes()
+
+	req = common.NewNfnlRequest(nil, common.NfqnlMsgVerdict, common.NlmFRequest, syscall.AF_UNSPEC, queueNum)
+	req.Reserve(NfqaVerdictHdr, int(SizeofNfqMsgVerdictHdr))
+	req.PutUint32(uint16(NfqaMark), 0)
+	v.mark = req.Bytes()
+
+	return v
+}
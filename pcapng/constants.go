@@ -0,0 +1,56 @@
+// +build linux !darwin
+
+package pcapng
+
+import (
+	"encoding/binary"
+
+	"go.aporeto.io/netlink-go/common"
+)
+
+// Block types
+const (
+	blockTypeSHB = 0x0a0d0d0a
+	blockTypeIDB = 0x00000001
+	blockTypeEPB = 0x00000006
+)
+
+// Section header
+const (
+	byteOrderMagic       = 0x1a2b3c4d
+	versionMajor         = 1
+	versionMinor         = 0
+	sectionLengthUnknown = 0xffffffffffffffff
+)
+
+// Option codes
+const (
+	optEndOfOpt    = 0
+	optComment     = 1
+	optSHBUserAppl = 4
+	optIfName      = 2
+	optIfTsresol   = 9
+	optEPBFlags    = 2
+	optEPBPacketID = 5
+)
+
+const (
+	// tsresolNanoseconds -- if_tsresol of the interfaces, timestamps are in 10^-9 s
+	tsresolNanoseconds = 9
+	// epbFlagsDirectionMask -- bits of the direction in epb_flags
+	epbFlagsDirectionMask = 0x3
+)
+
+// LINKTYPE_NFLOG header
+const (
+	nflogVersion      = 0
+	nflogPacketHdrLen = 4
+	nflogTimestampLen = 16
+)
+
+var (
+	// byteOrder -- Blocks are written in host byte order, like the TLV headers of LINKTYPE_NFLOG
+	byteOrder = common.NativeEndian()
+	// byteOrderNetwork -- Order of the values of the NFULA_ attributes
+	byteOrderNetwork = binary.BigEndian
+)
@@ -0,0 +1,382 @@
+// +build linux !darwin
+
+package pcapng
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/netlink-go/common"
+	"go.aporeto.io/netlink-go/nflog"
+	"go.aporeto.io/netlink-go/nfqueue"
+)
+
+// block -- A block read back from a capture
+type block struct {
+	blockType uint32
+	body      []byte
+}
+
+// readBlocks splits a capture in blocks, checking the lengths at both ends of each block
+func readBlocks(buf []byte) []block {
+	var blocks []block
+	for len(buf) > 0 {
+		So(len(buf), ShouldBeGreaterThanOrEqualTo, 12)
+		length := byteOrder.Uint32(buf[4:])
+		So(length%4, ShouldEqual, 0)
+		So(int(length), ShouldBeLessThanOrEqualTo, len(buf))
+		So(byteOrder.Uint32(buf[length-4:]), ShouldEqual, length)
+		blocks = append(blocks, block{blockType: byteOrder.Uint32(buf), body: buf[8 : length-4]})
+		buf = buf[length:]
+	}
+	return blocks
+}
+
+// readOptions decodes the options of a block, up to opt_endofopt
+func readOptions(buf []byte) map[uint16][][]byte {
+	opts := make(map[uint16][][]byte)
+	for len(buf) >= 4 {
+		code := byteOrder.Uint16(buf)
+		length := int(byteOrder.Uint16(buf[2:]))
+		if code == optEndOfOpt {
+			break
+		}
+		opts[code] = append(opts[code], buf[4:4+length])
+		buf = buf[4+int(common.NfaAlign32(uint32(length))):]
+	}
+	return opts
+}
+
+// epb -- An enhanced packet block read back
+type epb struct {
+	ifaceID uint32
+	ts      uint64
+	capLen  uint32
+	origLen uint32
+	data    []byte
+	opts    map[uint16][][]byte
+}
+
+func readEPB(b block) epb {
+	So(b.blockType, ShouldEqual, blockTypeEPB)
+	e := epb{
+		ifaceID: byteOrder.Uint32(b.body),
+		ts:      uint64(byteOrder.Uint32(b.body[4:]))<<32 | uint64(byteOrder.Uint32(b.body[8:])),
+		capLen:  byteOrder.Uint32(b.body[12:]),
+		origLen: byteOrder.Uint32(b.body[16:]),
+	}
+	e.data = b.body[20 : 20+e.capLen]
+	e.opts = readOptions(b.body[20+common.NfaAlign32(e.capLen):])
+	return e
+}
+
+// failingWriter fails every write after the first n
+type failingWriter struct {
+	n int
+}
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	if f.n == 0 {
+		return 0, errors.New("disk full")
+	}
+	f.n--
+	return len(p), nil
+}
+
+var (
+	// ipv4Packet -- IPv4 header of a 60 bytes UDP packet and the start of its payload
+	ipv4Packet = []byte{
+		0x45, 0x00, 0x00, 0x3c, 0x00, 0x01, 0x00, 0x00, 0x40, 0x11, 0x00, 0x00,
+		10, 0, 0, 1, 10, 0, 0, 2,
+		0x30, 0x39, 0x00, 0x35, 0x00, 0x28, 0x00, 0x00,
+		0xde, 0xad,
+	}
+	// ipv6Packet -- IPv6 header without payload
+	ipv6Packet = append([]byte{0x60, 0, 0, 0, 0, 0, 59, 64}, make([]byte, 32)...)
+	// interfaceNames -- Names of the interfaces of the tests
+	interfaceNames = WithInterfaceNames(func(index uint32) string {
+		return map[uint32]string{1: "lo", 2: "eth0"}[index]
+	})
+)
+
+func TestWriter(t *testing.T) {
+	Convey("Given a pcapng writer", t, func() {
+		var out bytes.Buffer
+		w, err := NewWriter(&out, LinkTypeRaw, interfaceNames)
+		So(err, ShouldBeNil)
+
+		Convey("The section header should be written in host byte order", func() {
+			blocks := readBlocks(out.Bytes())
+			So(len(blocks), ShouldEqual, 1)
+			So(blocks[0].blockType, ShouldEqual, blockTypeSHB)
+			So(byteOrder.Uint32(blocks[0].body), ShouldEqual, byteOrderMagic)
+			So(byteOrder.Uint16(blocks[0].body[4:]), ShouldEqual, 1)
+			So(byteOrder.Uint16(blocks[0].body[6:]), ShouldEqual, 0)
+			So(byteOrder.Uint64(blocks[0].body[8:]), ShouldEqual, uint64(sectionLengthUnknown))
+			So(string(readOptions(blocks[0].body[16:])[optSHBUserAppl][0]), ShouldEqual, "netlink-go")
+		})
+
+		Convey("A packet should be written with its interface, timestamp, direction, mark and comment", func() {
+			ts := time.Unix(1600000000, 123456789)
+			So(w.WritePacket(&Packet{
+				Data:      ipv4Packet,
+				Length:    60,
+				Timestamp: ts,
+				Interface: "eth0",
+				Direction: DirectionOutbound,
+				Mark:      0x42,
+				Comment:   "drop-ssh",
+				ID:        7,
+			}), ShouldBeNil)
+
+			blocks := readBlocks(out.Bytes())
+			So(len(blocks), ShouldEqual, 3)
+			So(blocks[1].blockType, ShouldEqual, blockTypeIDB)
+			So(byteOrder.Uint16(blocks[1].body), ShouldEqual, uint16(LinkTypeRaw))
+			idbOpts := readOptions(blocks[1].body[8:])
+			So(string(idbOpts[optIfName][0]), ShouldEqual, "eth0")
+			So(idbOpts[optIfTsresol][0], ShouldResemble, []byte{tsresolNanoseconds})
+
+			e := readEPB(blocks[2])
+			So(e.ifaceID, ShouldEqual, 0)
+			So(e.ts, ShouldEqual, uint64(ts.UnixNano()))
+			So(e.capLen, ShouldEqual, len(ipv4Packet))
+			So(e.origLen, ShouldEqual, 60)
+			So(e.data, ShouldResemble, ipv4Packet)
+			So(len(e.opts[optComment]), ShouldEqual, 2)
+			So(string(e.opts[optComment][0]), ShouldEqual, "drop-ssh")
+			So(string(e.opts[optComment][1]), ShouldEqual, "mark 0x42")
+			So(byteOrder.Uint32(e.opts[optEPBFlags][0]), ShouldEqual, 2)
+			So(byteOrder.Uint64(e.opts[optEPBPacketID][0]), ShouldEqual, 7)
+		})
+
+		Convey("The interface block should be written once per interface", func() {
+			So(w.WritePacket(&Packet{Data: ipv4Packet, Interface: "eth0"}), ShouldBeNil)
+			So(w.WritePacket(&Packet{Data: ipv6Packet, Interface: "lo"}), ShouldBeNil)
+			So(w.WritePacket(&Packet{Data: ipv4Packet, Interface: "eth0"}), ShouldBeNil)
+
+			blocks := readBlocks(out.Bytes())
+			So(len(blocks), ShouldEqual, 6)
+			So(blocks[1].blockType, ShouldEqual, blockTypeIDB)
+			So(readEPB(blocks[2]).ifaceID, ShouldEqual, 0)
+			So(blocks[3].blockType, ShouldEqual, blockTypeIDB)
+			So(readEPB(blocks[4]).ifaceID, ShouldEqual, 1)
+			So(readEPB(blocks[5]).ifaceID, ShouldEqual, 0)
+		})
+
+		Convey("A packet without options should have no option", func() {
+			So(w.WritePacket(&Packet{Data: ipv4Packet, Timestamp: time.Unix(1, 0)}), ShouldBeNil)
+
+			e := readEPB(readBlocks(out.Bytes())[2])
+			So(len(e.opts), ShouldEqual, 0)
+			So(e.origLen, ShouldEqual, len(ipv4Packet))
+		})
+
+		Convey("Packets which are not IP should be skipped", func() {
+			So(w.WritePacket(&Packet{Data: []byte{0x00, 0x01, 0x08, 0x00}}), ShouldBeNil)
+			So(w.WritePacket(&Packet{}), ShouldBeNil)
+			So(len(readBlocks(out.Bytes())), ShouldEqual, 1)
+		})
+	})
+
+	Convey("Given a pcapng writer with a snap length", t, func() {
+		var out bytes.Buffer
+		w, err := NewWriter(&out, LinkTypeRaw, WithSnapLen(20), WithApplication("test"))
+		So(err, ShouldBeNil)
+
+		Convey("Packets should be truncated to the snap length", func() {
+			So(w.WritePacket(&Packet{Data: ipv4Packet, Length: 60}), ShouldBeNil)
+
+			blocks := readBlocks(out.Bytes())
+			So(string(readOptions(blocks[0].body[16:])[optSHBUserAppl][0]), ShouldEqual, "test")
+			So(byteOrder.Uint32(blocks[1].body[4:]), ShouldEqual, 20)
+			e := readEPB(blocks[2])
+			So(e.capLen, ShouldEqual, 20)
+			So(e.origLen, ShouldEqual, 60)
+			So(e.data, ShouldResemble, ipv4Packet[:20])
+		})
+	})
+
+	Convey("Given a writer failing after the section header", t, func() {
+		fw := &failingWriter{n: 1}
+		w, err := NewWriter(fw, LinkTypeRaw)
+		So(err, ShouldBeNil)
+
+		Convey("The first error should be kept and returned by the following writes", func() {
+			err := w.WritePacket(&Packet{Data: ipv4Packet})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "disk full")
+			fw.n = 10
+			So(w.WritePacket(&Packet{Data: ipv4Packet}), ShouldEqual, err)
+			So(w.Err(), ShouldEqual, err)
+			So(fw.n, ShouldEqual, 10)
+		})
+	})
+
+	Convey("Given an unsupported link type", t, func() {
+		_, err := NewWriter(&bytes.Buffer{}, LinkType(1))
+
+		Convey("The writer should not be created", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestNFLOGLinkType(t *testing.T) {
+	Convey("Given a pcapng writer of LINKTYPE_NFLOG packets", t, func() {
+		var out bytes.Buffer
+		w, err := NewWriter(&out, LinkTypeNFLOG, interfaceNames)
+		So(err, ShouldBeNil)
+
+		Convey("A logged packet should be written as a nflog message", func() {
+			ts := time.Unix(1600000000, 5000)
+			So(w.WritePacket(&Packet{
+				Data:       ipv4Packet,
+				Length:     60,
+				Timestamp:  ts,
+				Interface:  "eth0",
+				Mark:       0x42,
+				Comment:    "drop-ssh",
+				Family:     syscall.AF_INET,
+				ResID:      10,
+				HwProtocol: 0x0800,
+				Hook:       1,
+				InDev:      2,
+			}), ShouldBeNil)
+
+			blocks := readBlocks(out.Bytes())
+			So(byteOrder.Uint16(blocks[1].body), ShouldEqual, uint16(LinkTypeNFLOG))
+			e := readEPB(blocks[2])
+			So(e.origLen, ShouldEqual, int(e.capLen)+60-len(ipv4Packet))
+			So(e.data[:4], ShouldResemble, []byte{syscall.AF_INET, 0, 0, 10})
+
+			attrs := common.NewAttrDecoder(e.data[4:])
+			found := make(map[uint16][]byte)
+			for attrs.Next() {
+				found[attrs.Type()] = attrs.Bytes()
+			}
+			So(attrs.Err(), ShouldBeNil)
+			So(found[nflog.NFULA_PACKET_HDR][:3], ShouldResemble, []byte{0x08, 0x00, 1})
+			So(binary.BigEndian.Uint32(found[nflog.NFULA_MARK]), ShouldEqual, 0x42)
+			So(binary.BigEndian.Uint64(found[nflog.NFULA_TIMESTAMP]), ShouldEqual, 1600000000)
+			So(binary.BigEndian.Uint64(found[nflog.NFULA_TIMESTAMP][8:]), ShouldEqual, 5)
+			So(binary.BigEndian.Uint32(found[nflog.NFULA_IFINDEX_INDEV]), ShouldEqual, 2)
+			So(found, ShouldNotContainKey, uint16(nflog.NFULA_IFINDEX_OUTDEV))
+			So(string(found[nflog.NFULA_PREFIX]), ShouldEqual, "drop-ssh\x00")
+			So(found[nflog.NFULA_PAYLOAD], ShouldResemble, ipv4Packet)
+		})
+
+		Convey("Packets which are not IP should be written", func() {
+			So(w.WritePacket(&Packet{Data: []byte{0x00, 0x01, 0x08, 0x00}, Family: syscall.AF_BRIDGE}), ShouldBeNil)
+			So(len(readBlocks(out.Bytes())), ShouldEqual, 3)
+		})
+	})
+}
+
+func TestTaps(t *testing.T) {
+	Convey("Given a pcapng writer", t, func() {
+		var out bytes.Buffer
+		w, err := NewWriter(&out, LinkTypeRaw, interfaceNames)
+		So(err, ShouldBeNil)
+
+		Convey("The nflog tap should write the packets and call the callback", func() {
+			var called []*nflog.NfPacket
+			tap := w.NflogTap(func(p *nflog.NfPacket, data interface{}) {
+				called = append(called, p)
+				So(data, ShouldEqual, "data")
+			})
+
+			received := &nflog.NfPacket{Prefix: "in", Payload: ipv4Packet}
+			received.Group = 10
+			received.InDev = 2
+			received.Timestamp = time.Unix(1600000000, 0)
+			sent := &nflog.NfPacket{Prefix: "out", Payload: ipv6Packet}
+			sent.OutDev = 1
+			sent.Mark = 3
+			local := &nflog.NfPacket{Payload: ipv4Packet}
+			local.Group = 4
+			tap(received, "data")
+			tap(sent, "data")
+			tap(local, "data")
+
+			So(called, ShouldResemble, []*nflog.NfPacket{received, sent, local})
+			So(w.Err(), ShouldBeNil)
+
+			blocks := readBlocks(out.Bytes())
+			So(len(blocks), ShouldEqual, 7)
+			So(string(readOptions(blocks[1].body[8:])[optIfName][0]), ShouldEqual, "eth0")
+			e := readEPB(blocks[2])
+			So(e.ts, ShouldEqual, uint64(received.Timestamp.UnixNano()))
+			So(string(e.opts[optComment][0]), ShouldEqual, "in")
+			So(byteOrder.Uint32(e.opts[optEPBFlags][0]), ShouldEqual, 1)
+
+			So(string(readOptions(blocks[3].body[8:])[optIfName][0]), ShouldEqual, "lo")
+			e = readEPB(blocks[4])
+			So(e.ifaceID, ShouldEqual, 1)
+			So(string(e.opts[optComment][1]), ShouldEqual, "mark 0x3")
+			So(byteOrder.Uint32(e.opts[optEPBFlags][0]), ShouldEqual, 2)
+
+			So(string(readOptions(blocks[5].body[8:])[optIfName][0]), ShouldEqual, "nflog4")
+			So(readEPB(blocks[6]).opts, ShouldNotContainKey, uint16(optEPBFlags))
+		})
+
+		Convey("The nfqueue tap should write the packets and call the callback", func() {
+			var called int
+			tap := w.NfqueueTap(func(p *nfqueue.NFPacket, data interface{}) {
+				called++
+			})
+
+			tap(&nfqueue.NFPacket{
+				Buffer:      ipv4Packet,
+				Length:      60,
+				Mark:        5,
+				ID:          12,
+				QueueHandle: &nfqueue.NfQueue{QueueNum: 3},
+			}, nil)
+
+			So(called, ShouldEqual, 1)
+			blocks := readBlocks(out.Bytes())
+			So(len(blocks), ShouldEqual, 3)
+			So(string(readOptions(blocks[1].body[8:])[optIfName][0]), ShouldEqual, "nfqueue3")
+			e := readEPB(blocks[2])
+			So(e.origLen, ShouldEqual, 60)
+			So(string(e.opts[optComment][0]), ShouldEqual, "mark 0x5")
+			So(byteOrder.Uint64(e.opts[optEPBPacketID][0]), ShouldEqual, 12)
+		})
+
+		Convey("The taps should call the callback when the writes fail", func() {
+			w, err := NewWriter(&failingWriter{n: 1}, LinkTypeRaw)
+			So(err, ShouldBeNil)
+
+			var called int
+			tap := w.NfqueueTap(func(p *nfqueue.NFPacket, data interface{}) {
+				called++
+			})
+			tap(&nfqueue.NFPacket{Buffer: ipv4Packet}, nil)
+			tap(&nfqueue.NFPacket{Buffer: ipv4Packet}, nil)
+
+			So(called, ShouldEqual, 2)
+			So(w.Err(), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestLookupInterface(t *testing.T) {
+	Convey("Given the interfaces of the namespace", t, func() {
+		ifaces, err := net.Interfaces()
+		So(err, ShouldBeNil)
+
+		Convey("Existing interfaces should be named and missing ones numbered", func() {
+			for _, iface := range ifaces {
+				So(lookupInterface(uint32(iface.Index)), ShouldEqual, iface.Name)
+			}
+			So(lookupInterface(0x7fffffff), ShouldEqual, "if2147483647")
+		})
+	})
+}
@@ -0,0 +1,95 @@
+// +build linux !darwin
+
+package pcapng
+
+import (
+	"fmt"
+	"syscall"
+
+	"go.aporeto.io/netlink-go/nflog"
+	"go.aporeto.io/netlink-go/nfqueue"
+)
+
+// WriteNflog -- Write a logged packet on its input interface, or its output interface
+// for a locally generated packet. The prefix is the comment of the packet
+func (w *Writer) WriteNflog(p *nflog.NfPacket) error {
+	return w.WritePacket(w.nflogPacket(p))
+}
+
+// WriteNfqueue -- Write a queued packet on an interface named after its queue, nfqueueN
+func (w *Writer) WriteNfqueue(p *nfqueue.NFPacket) error {
+	return w.WritePacket(nfqueuePacket(p))
+}
+
+// NflogTap -- Wrap a nflog callback: each packet is written before callback is called with it.
+// Write errors do not stop the callback, they are reported by Err
+func (w *Writer) NflogTap(callback func(*nflog.NfPacket, interface{})) func(*nflog.NfPacket, interface{}) {
+	return func(p *nflog.NfPacket, data interface{}) {
+		w.WriteNflog(p) // nolint
+		if callback != nil {
+			callback(p, data)
+		}
+	}
+}
+
+// NfqueueTap -- Wrap a nfqueue callback: each packet is written before callback is called with it,
+// the callback still sets the verdict. Write errors do not stop the callback, they are reported by Err
+func (w *Writer) NfqueueTap(callback func(*nfqueue.NFPacket, interface{})) func(*nfqueue.NFPacket, interface{}) {
+	return func(p *nfqueue.NFPacket, data interface{}) {
+		w.WriteNfqueue(p) // nolint
+		if callback != nil {
+			callback(p, data)
+		}
+	}
+}
+
+// nflogPacket -- Packet of a logged packet
+func (w *Writer) nflogPacket(p *nflog.NfPacket) *Packet {
+	pkt := &Packet{
+		Data:       p.Payload,
+		Length:     int(p.Length),
+		Timestamp:  p.Timestamp,
+		Mark:       p.Mark,
+		Comment:    p.Prefix,
+		Family:     p.Family,
+		ResID:      p.Group,
+		HwProtocol: p.HwProtocol,
+		Hook:       p.Hook,
+		InDev:      p.InDev,
+		OutDev:     p.OutDev,
+	}
+
+	w.lock.Lock()
+	switch {
+	case p.InDev != 0:
+		pkt.Interface = w.interfaceName(p.InDev)
+		pkt.Direction = DirectionInbound
+	case p.OutDev != 0:
+		pkt.Interface = w.interfaceName(p.OutDev)
+		pkt.Direction = DirectionOutbound
+	default:
+		pkt.Interface = fmt.Sprintf("nflog%d", p.Group)
+	}
+	w.lock.Unlock()
+
+	return pkt
+}
+
+// nfqueuePacket -- Packet of a queued packet, nfqueue does not pass the interfaces nor the time
+func nfqueuePacket(p *nfqueue.NFPacket) *Packet {
+	pkt := &Packet{
+		Data:   p.Buffer,
+		Length: p.Length,
+		Mark:   uint32(p.Mark),
+		ID:     uint64(p.ID),
+		Family: syscall.AF_INET,
+	}
+	if len(p.Buffer) > 0 && p.Buffer[0]>>4 == 6 {
+		pkt.Family = syscall.AF_INET6
+	}
+	if p.QueueHandle != nil {
+		pkt.ResID = p.QueueHandle.QueueNum
+		pkt.Interface = fmt.Sprintf("nfqueue%d", p.QueueHandle.QueueNum)
+	}
+	return pkt
+}
@@ -0,0 +1,366 @@
+// +build linux !darwin
+
+package pcapng
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"go.aporeto.io/netlink-go/common"
+	"go.aporeto.io/netlink-go/nflog"
+)
+
+// LinkType -- Link type of the interfaces of a capture, the LINKTYPE_ value
+type LinkType uint16
+
+const (
+	// LinkTypeRaw -- LINKTYPE_RAW, packets start with the IPv4 or IPv6 header
+	LinkTypeRaw LinkType = 101
+	// LinkTypeNFLOG -- LINKTYPE_NFLOG, packets are nflog messages: the nfgen header followed by the
+	// NFULA_ attributes. Wireshark shows the prefix, mark, hook and interfaces of each packet
+	LinkTypeNFLOG LinkType = 239
+)
+
+// Direction -- Direction of a packet on its interface, written in the epb_flags option
+type Direction uint8
+
+const (
+	// DirectionUnknown -- No direction written
+	DirectionUnknown Direction = iota
+	// DirectionInbound -- Packet received on the interface
+	DirectionInbound
+	// DirectionOutbound -- Packet sent on the interface
+	DirectionOutbound
+)
+
+// Packet -- One packet of a capture
+// Data -- the packet, starting at the IP header
+// Length -- length of the packet on the wire, larger than Data when only the start was copied. len(Data) if 0
+// Timestamp -- time the packet was captured, the time it is written if zero
+// Interface -- name of the interface the packet is written on, an interface block is written for each new name
+// Direction -- direction of the packet on the interface
+// Mark -- packet mark, written as a comment when not 0
+// Comment -- packet comment, the nflog prefix
+// ID -- packet id written in the epb_packetid option when not 0, the nfqueue packet id
+// Family, ResID -- nfgen family and resource id (nflog group or nfqueue number) of a LINKTYPE_NFLOG packet
+// HwProtocol, Hook, InDev, OutDev -- written in the NFULA_ attributes of a LINKTYPE_NFLOG packet when not 0
+type Packet struct {
+	Data       []byte
+	Length     int
+	Timestamp  time.Time
+	Interface  string
+	Direction  Direction
+	Mark       uint32
+	Comment    string
+	ID         uint64
+	Family     uint8
+	ResID      uint16
+	HwProtocol uint16
+	Hook       uint8
+	InDev      uint32
+	OutDev     uint32
+}
+
+// Writer -- Writes packets in the pcapng format. The blocks are written in host byte order,
+// each one with a single Write on the underlying writer. A Writer is safe for concurrent use.
+// The first write error is kept: the following writes return it without writing anything,
+// the file would not be readable past a partial block.
+type Writer struct {
+	w          io.Writer
+	linkType   LinkType
+	snapLen    uint32
+	appName    string
+	ifaceNames func(index uint32) string
+	lock       sync.Mutex
+	ifaces     map[string]uint32
+	names      map[uint32]string
+	buf        []byte
+	msg        []byte
+	err        error
+}
+
+// Option -- Option of a Writer, passed to NewWriter
+type Option func(*Writer)
+
+// WithSnapLen -- Write at most snapLen bytes of each packet, 0 writes the whole packets
+func WithSnapLen(snapLen uint32) Option {
+	return func(w *Writer) {
+		w.snapLen = snapLen
+	}
+}
+
+// WithApplication -- Name of the application written in the section header, netlink-go by default
+func WithApplication(name string) Option {
+	return func(w *Writer) {
+		w.appName = name
+	}
+}
+
+// WithInterfaceNames -- Name of the interface of an index, used by the nflog taps.
+// The interfaces of the current network namespace are looked up by default, which is wrong
+// for packets logged in another namespace
+func WithInterfaceNames(names func(index uint32) string) Option {
+	return func(w *Writer) {
+		w.ifaceNames = names
+	}
+}
+
+// NewWriter -- Create a writer of packets of linkType to w and write the section header
+func NewWriter(w io.Writer, linkType LinkType, opts ...Option) (*Writer, error) {
+	if linkType != LinkTypeRaw && linkType != LinkTypeNFLOG {
+		return nil, fmt.Errorf("unsupported link type %d", linkType)
+	}
+
+	pw := &Writer{
+		w:          w,
+		linkType:   linkType,
+		appName:    "netlink-go",
+		ifaceNames: lookupInterface,
+		ifaces:     make(map[string]uint32),
+		names:      make(map[uint32]string),
+	}
+	for _, opt := range opts {
+		opt(pw)
+	}
+
+	pw.lock.Lock()
+	defer pw.lock.Unlock()
+
+	pw.beginBlock(blockTypeSHB)
+	pw.putUint32(byteOrderMagic)
+	pw.putUint16(versionMajor)
+	pw.putUint16(versionMinor)
+	pw.putUint64(sectionLengthUnknown)
+	if pw.appName != "" {
+		pw.putOption(optSHBUserAppl, []byte(pw.appName))
+		pw.putOption(optEndOfOpt, nil)
+	}
+	if err := pw.endBlock(); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+// LinkType -- Link type of the packets written
+func (w *Writer) LinkType() LinkType {
+	return w.linkType
+}
+
+// Err -- The write error which stopped the writer, nil if every write succeeded.
+// The taps cannot return it, it is only reported here
+func (w *Writer) Err() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.err
+}
+
+// WritePacket -- Write a packet, and the interface block of its interface the first time it is used.
+// With LinkTypeRaw packets which are neither IPv4 nor IPv6 are skipped.
+func (w *Writer) WritePacket(p *Packet) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+	if w.linkType == LinkTypeRaw && !isIP(p.Data) {
+		return nil
+	}
+
+	ifaceID, err := w.interfaceID(p.Interface)
+	if err != nil {
+		return err
+	}
+
+	data := p.Data
+	length := p.Length
+	if length < len(data) {
+		length = len(data)
+	}
+	if w.linkType == LinkTypeNFLOG {
+		data = w.nflogMessage(p)
+		length += len(data) - len(p.Data)
+	}
+	if w.snapLen != 0 && len(data) > int(w.snapLen) {
+		data = data[:w.snapLen]
+	}
+
+	ts := p.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	nsec := uint64(ts.UnixNano())
+
+	w.beginBlock(blockTypeEPB)
+	w.putUint32(ifaceID)
+	w.putUint32(uint32(nsec >> 32))
+	w.putUint32(uint32(nsec))
+	w.putUint32(uint32(len(data)))
+	w.putUint32(uint32(length))
+	w.putPadded(data)
+
+	hasOptions := false
+	if p.Comment != "" {
+		w.putOption(optComment, []byte(p.Comment))
+		hasOptions = true
+	}
+	if p.Mark != 0 {
+		w.putOption(optComment, []byte(fmt.Sprintf("mark 0x%x", p.Mark)))
+		hasOptions = true
+	}
+	if p.Direction != DirectionUnknown {
+		var flags [4]byte
+		byteOrder.PutUint32(flags[:], uint32(p.Direction)&epbFlagsDirectionMask)
+		w.putOption(optEPBFlags, flags[:])
+		hasOptions = true
+	}
+	if p.ID != 0 {
+		var id [8]byte
+		byteOrder.PutUint64(id[:], p.ID)
+		w.putOption(optEPBPacketID, id[:])
+		hasOptions = true
+	}
+	if hasOptions {
+		w.putOption(optEndOfOpt, nil)
+	}
+	return w.endBlock()
+}
+
+// interfaceID -- Id of the interface name, its interface block is written the first time
+func (w *Writer) interfaceID(name string) (uint32, error) {
+	if id, ok := w.ifaces[name]; ok {
+		return id, nil
+	}
+
+	id := uint32(len(w.ifaces))
+	w.beginBlock(blockTypeIDB)
+	w.putUint16(uint16(w.linkType))
+	w.putUint16(0)
+	w.putUint32(w.snapLen)
+	if name != "" {
+		w.putOption(optIfName, []byte(name))
+	}
+	w.putOption(optIfTsresol, []byte{tsresolNanoseconds})
+	w.putOption(optEndOfOpt, nil)
+	if err := w.endBlock(); err != nil {
+		return 0, err
+	}
+
+	w.ifaces[name] = id
+	return id, nil
+}
+
+// interfaceName -- Name of the interface index, looked up once
+func (w *Writer) interfaceName(index uint32) string {
+	if name, ok := w.names[index]; ok {
+		return name
+	}
+	name := w.ifaceNames(index)
+	w.names[index] = name
+	return name
+}
+
+// nflogMessage -- The LINKTYPE_NFLOG message of a packet, valid until the next call
+func (w *Writer) nflogMessage(p *Packet) []byte {
+	w.msg = append(w.msg[:0], p.Family, nflogVersion, byte(p.ResID>>8), byte(p.ResID))
+	attrs := common.NewAttrBuilder(w.msg)
+
+	hdr := attrs.Reserve(nflog.NFULA_PACKET_HDR, nflogPacketHdrLen)
+	hdr[0] = byte(p.HwProtocol >> 8)
+	hdr[1] = byte(p.HwProtocol)
+	hdr[2] = p.Hook
+	if p.Mark != 0 {
+		attrs.PutUint32(nflog.NFULA_MARK, p.Mark)
+	}
+	if !p.Timestamp.IsZero() {
+		ts := attrs.Reserve(nflog.NFULA_TIMESTAMP, nflogTimestampLen)
+		byteOrderNetwork.PutUint64(ts, uint64(p.Timestamp.Unix()))
+		byteOrderNetwork.PutUint64(ts[8:], uint64(p.Timestamp.Nanosecond()/1000))
+	}
+	if p.InDev != 0 {
+		attrs.PutUint32(nflog.NFULA_IFINDEX_INDEV, p.InDev)
+	}
+	if p.OutDev != 0 {
+		attrs.PutUint32(nflog.NFULA_IFINDEX_OUTDEV, p.OutDev)
+	}
+	if p.Comment != "" {
+		attrs.PutString(nflog.NFULA_PREFIX, p.Comment)
+	}
+	attrs.PutBytes(nflog.NFULA_PAYLOAD, p.Data)
+
+	w.msg = attrs.Bytes()
+	return w.msg
+}
+
+// beginBlock -- Start a block in the block buffer, its length is filled in by endBlock
+func (w *Writer) beginBlock(blockType uint32) {
+	w.buf = w.buf[:0]
+	w.putUint32(blockType)
+	w.putUint32(0)
+}
+
+// endBlock -- Fill in the length of the block, repeated at its end, and write it
+func (w *Writer) endBlock() error {
+	length := uint32(len(w.buf) + 4)
+	byteOrder.PutUint32(w.buf[4:], length)
+	w.putUint32(length)
+
+	if _, err := w.w.Write(w.buf); err != nil {
+		w.err = fmt.Errorf("unable to write pcapng block: %w", err)
+		return w.err
+	}
+	return nil
+}
+
+// putOption -- Add an option to the block, its value padded to 4 bytes
+func (w *Writer) putOption(code uint16, value []byte) {
+	w.putUint16(code)
+	w.putUint16(uint16(len(value)))
+	w.putPadded(value)
+}
+
+// putPadded -- Add data padded to 4 bytes to the block
+func (w *Writer) putPadded(data []byte) {
+	w.buf = append(w.buf, data...)
+	for len(w.buf)%4 != 0 {
+		w.buf = append(w.buf, 0)
+	}
+}
+
+func (w *Writer) putUint16(v uint16) {
+	var b [2]byte
+	byteOrder.PutUint16(b[:], v)
+	w.buf = append(w.buf, b[:]...)
+}
+
+func (w *Writer) putUint32(v uint32) {
+	var b [4]byte
+	byteOrder.PutUint32(b[:], v)
+	w.buf = append(w.buf, b[:]...)
+}
+
+func (w *Writer) putUint64(v uint64) {
+	var b [8]byte
+	byteOrder.PutUint64(b[:], v)
+	w.buf = append(w.buf, b[:]...)
+}
+
+// isIP -- True if data starts with an IPv4 or IPv6 header version
+func isIP(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+	version := data[0] >> 4
+	return version == 4 || version == 6
+}
+
+// lookupInterface -- Name of the interface index in the current network namespace, ifN if it does not exist
+func lookupInterface(index uint32) string {
+	if iface, err := net.InterfaceByIndex(int(index)); err == nil {
+		return iface.Name
+	}
+	return fmt.Sprintf("if%d", index)
+}